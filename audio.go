@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// audioArgs returns the stream mapping and codec options that mux the -audio
+// track as AAC, cut at the end of the video, or nothing without -audio.
+func audioArgs(opts Options) []string {
+	if opts.Audio == "" {
+		return nil
+	}
+
+	var args []string
+	audio := 0
+	if opts.Watermark != "" {
+		// The watermark graph already maps its output
+		audio = 1
+	} else {
+		args = append(args, "-map", fmt.Sprintf("%d:v:0", videoInput(opts)))
+	}
+	codec := "aac"
+	if opts.Codec == "vp9" {
+		// WebM only holds Opus and Vorbis
+		codec = "libopus"
+	}
+	return append(args,
+		"-map", fmt.Sprintf("%d:a:0", audio),
+		"-c:a", codec, "-b:a", fmt.Sprintf("%dk", audioBitrate),
+		"-shortest",
+	)
+}
+
+// checkAudio validates the -audio options.
+func checkAudio(opts Options) error {
+	if opts.AudioFit != "loop" && opts.AudioFit != "trim" {
+		return fmt.Errorf("invalid -audiofit %q (use loop or trim)", opts.AudioFit)
+	}
+	if _, err := os.Stat(opts.Audio); err != nil {
+		return fmt.Errorf("failed to open audio: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchJob is a single input/output pair in a batch conversion.
+type batchJob struct {
+	Input  string
+	Output string
+}
+
+// batchResult records the outcome of converting a single file in a batch.
+type batchResult struct {
+	batchJob
+	Result  Result
+	Err     error
+	Elapsed time.Duration
+	// Skipped is set when -skip-existing or -overwrite-older found the
+	// output up to date, or, with Short, when the input was below
+	// -min-frames or -min-duration
+	Skipped bool
+	Short   bool
+}
+
+// batchFilter picks the batch inputs that are skipped instead of converted.
+type batchFilter struct {
+	// SkipExisting skips inputs whose output is up to date
+	SkipExisting bool
+	// MinFrames and MinDuration skip inputs with fewer frames or a shorter
+	// animation, 0 meaning no minimum
+	MinFrames   int
+	MinDuration time.Duration
+}
+
+// active reports whether the filter can skip anything.
+func (f batchFilter) active() bool {
+	return f.SkipExisting || f.MinFrames > 0 || f.MinDuration > 0
+}
+
+// skip returns why job should be skipped, or "" to convert it, and whether
+// that is because the input is too short. Only the header is read to find
+// the length, and inputs that can't be inspected are left for the
+// conversion to report.
+func (f batchFilter) skip(job batchJob) (reason string, short bool) {
+	if f.MinFrames > 0 || f.MinDuration > 0 {
+		if info, err := inspectCached(job.Input); err == nil {
+			if info.Frames < f.MinFrames {
+				return fmt.Sprintf("frame count %d is below -min-frames %d", info.Frames, f.MinFrames), true
+			}
+			if info.Duration < f.MinDuration {
+				return fmt.Sprintf("duration %s is below -min-duration %s", info.Duration, f.MinDuration), true
+			}
+		}
+	}
+	if f.SkipExisting && upToDate(job.Input, job.Output) {
+		return job.Output + " is up to date", false
+	}
+	return "", false
+}
+
+// expandInputs resolves the -i argument into a list of files. A directory
+// yields every .webp file inside it and a pattern containing glob
+// metacharacters is expanded. The boolean reports whether batch mode applies.
+func expandInputs(input string) ([]string, bool, error) {
+	if isURL(input) {
+		return []string{input}, false, nil
+	}
+
+	if info, err := os.Stat(input); err == nil && info.IsDir() {
+		entries, err := os.ReadDir(input)
+		if err != nil {
+			return nil, true, fmt.Errorf("failed to read directory: %w", err)
+		}
+		var files []string
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.EqualFold(filepath.Ext(entry.Name()), ".webp") {
+				files = append(files, filepath.Join(input, entry.Name()))
+			}
+		}
+		if len(files) == 0 {
+			return nil, true, fmt.Errorf("no .webp files found in %s", input)
+		}
+		return files, true, nil
+	}
+
+	if strings.ContainsAny(input, "*?[") {
+		files, err := filepath.Glob(input)
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid glob pattern: %w", err)
+		}
+		if len(files) == 0 {
+			return nil, true, fmt.Errorf("no files match %s", input)
+		}
+		return files, true, nil
+	}
+
+	return []string{input}, false, nil
+}
+
+// expandAll expands every -i argument, keeping their order. More than one
+// argument means batch mode, as a directory or glob does.
+func expandAll(args []string) ([]string, bool, error) {
+	if len(args) == 1 {
+		return expandInputs(args[0])
+	}
+	var files []string
+	for _, arg := range args {
+		expanded, _, err := expandInputs(arg)
+		if err != nil {
+			return nil, true, err
+		}
+		files = append(files, expanded...)
+	}
+	return files, true, nil
+}
+
+// readInputList reads the -list file: one input per line, skipping blank
+// lines and # comments. Relative paths are taken relative to the list.
+func readInputList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read -list: %w", err)
+	}
+	var inputs []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) && !isURL(line) {
+			line = filepath.Join(filepath.Dir(path), line)
+		}
+		inputs = append(inputs, line)
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("-list %s names no inputs", path)
+	}
+	return inputs, nil
+}
+
+// defaultOutput derives the output path by swapping the input's extension
+// for the container's, .mp4 unless -container says otherwise.
+func defaultOutput(input, container string) string {
+	ext := filepath.Ext(input)
+	if container == "" {
+		container = "mp4"
+	}
+	output := strings.TrimSuffix(input, ext) + "." + strings.ToLower(container)
+	if strings.EqualFold(output, input) {
+		// -format webp would otherwise replace its own source
+		output = strings.TrimSuffix(input, ext) + "_optimized." + strings.ToLower(container)
+	}
+	return output
+}
+
+// convertFiltered is convertBatch for -skip-existing, -overwrite-older,
+// -min-frames and -min-duration: jobs the filter skips are reported as
+// skipped instead of converted. Outdated outputs still need -overwrite to
+// be replaced, which -overwrite-older turns on.
+func convertFiltered(jobs []batchJob, filter batchFilter, opts Options, workers int, report bool, manifest *manifestWriter) []batchResult {
+	results := make([]batchResult, len(jobs))
+	var pending []batchJob
+	var indices []int
+	for i, job := range jobs {
+		reason, short := filter.skip(job)
+		if reason == "" {
+			pending = append(pending, job)
+			indices = append(indices, i)
+			continue
+		}
+		results[i] = batchResult{batchJob: job, Result: Result{Input: job.Input, Output: job.Output}, Skipped: true, Short: short}
+		if manifest != nil {
+			manifest.add(results[i])
+		}
+		if report {
+			fmt.Printf("Skipped %s, %s\n", job.Input, reason)
+		}
+	}
+	for i, r := range convertBatch(pending, opts, workers, report, manifest) {
+		results[indices[i]] = r
+	}
+	return results
+}
+
+// upToDate reports whether output exists and was modified no earlier than
+// input. An input that can't be stat'ed, such as a URL, is never up to date.
+func upToDate(input, output string) bool {
+	out, err := os.Stat(output)
+	if err != nil {
+		return false
+	}
+	in, err := os.Stat(input)
+	return err == nil && !out.ModTime().Before(in.ModTime())
+}
+
+// batchRun is what batch mode adds to ConvertBatch: where each output goes,
+// which inputs are skipped, and the lines and manifest rows written as
+// files finish.
+type batchRun struct {
+	// outputs holds the output of each input, nil to write each next to
+	// its input
+	outputs  []string
+	filter   batchFilter
+	report   bool
+	manifest *manifestWriter
+}
+
+// ConvertBatch converts inputs, opts.Concurrency of them at a time, each to
+// the default output next to it. The results are in the same order as
+// inputs, with Err set on those that failed, and the returned error joins
+// their failures.
+func ConvertBatch(inputs []string, opts Options) ([]Result, error) {
+	run := opts.batch
+	if run == nil {
+		run = &batchRun{}
+	}
+	jobs := make([]batchJob, len(inputs))
+	for i, in := range inputs {
+		out := defaultOutput(in, opts.outputExt())
+		if run.outputs != nil {
+			out = run.outputs[i]
+		}
+		jobs[i] = batchJob{Input: in, Output: out}
+	}
+
+	workers := max(opts.Concurrency, 1)
+	var batch []batchResult
+	if run.filter.active() {
+		batch = convertFiltered(jobs, run.filter, opts, workers, run.report, run.manifest)
+	} else {
+		batch = convertBatch(jobs, opts, workers, run.report, run.manifest)
+	}
+
+	results := make([]Result, len(batch))
+	var errs []error
+	for i, r := range batch {
+		results[i] = r.Result
+		results[i].Input, results[i].Output = r.Input, r.Output
+		results[i].Err, results[i].skipped, results[i].short = r.Err, r.Skipped, r.Short
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.Input, r.Err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// batchResults turns the results of ConvertBatch back into the
+// batchResults that batch mode reports.
+func batchResults(results []Result) []batchResult {
+	batch := make([]batchResult, len(results))
+	for i, r := range results {
+		batch[i] = batchResult{
+			batchJob: batchJob{Input: r.Input, Output: r.Output},
+			Result:   r,
+			Err:      r.Err,
+			Elapsed:  time.Duration(r.Elapsed * float64(time.Second)),
+			Skipped:  r.skipped,
+			Short:    r.short,
+		}
+	}
+	return batch
+}
+
+// convertBatch converts jobs using a pool of workers. Each conversion gets
+// its own temp directory, so workers never share intermediate files.
+// Results are returned in the same order as jobs. When report is set, a line
+// is printed as each file finishes, followed by the overall count on stderr.
+// A non-nil manifest gets a row for each file as it finishes.
+func convertBatch(jobs []batchJob, opts Options, workers int, report bool, manifest *manifestWriter) []batchResult {
+	results := make([]batchResult, len(jobs))
+	queue := make(chan int)
+	progress := newBatchProgress(os.Stderr, len(jobs))
+	if opts.Progress && workers > 1 && len(jobs) > 1 {
+		// Files encoding at once would redraw over each other and over the
+		// batch lines, which still show how far the batch has got
+		slog.Debug("-progress only shows the batch progress with -j above 1")
+		opts.Progress = false
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				start := time.Now()
+				result, err := convertWebPToMP4(jobs[i].Input, jobs[i].Output, opts)
+				results[i] = batchResult{batchJob: jobs[i], Result: result, Err: err, Elapsed: time.Since(start)}
+				if manifest != nil {
+					manifest.add(results[i])
+				}
+
+				if !report {
+					continue
+				}
+				if err != nil {
+					slog.Error("conversion failed", "input", jobs[i].Input, "err", err)
+				} else {
+					fmt.Printf("Converted %s to %s (%s)\n", jobs[i].Input, jobs[i].Output, result.summary())
+				}
+				progress.finish()
+			}
+		}()
+	}
+
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	return results
+}
+
+// printBatchSummary prints the aggregated outcome of a batch and returns the
+// number of failed conversions.
+func printBatchSummary(results []batchResult, elapsed time.Duration) int {
+	var failed []batchResult
+	timedOut, upToDate, short := 0, 0, 0
+	var written int64
+	for _, r := range results {
+		written += r.Result.Size
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+		if errors.Is(r.Err, ErrTimeout) {
+			timedOut++
+		}
+		switch {
+		case r.Short:
+			short++
+		case r.Skipped:
+			upToDate++
+		}
+	}
+
+	fmt.Printf("\nConverted %d of %d files in %s (%d failed",
+		len(results)-len(failed)-upToDate-short, len(results), elapsed.Round(time.Millisecond), len(failed))
+	if timedOut > 0 {
+		fmt.Printf(", %d timed out", timedOut)
+	}
+	if upToDate > 0 {
+		fmt.Printf(", %d up to date", upToDate)
+	}
+	if short > 0 {
+		fmt.Printf(", %d too short", short)
+	}
+	fmt.Print(")")
+	if written > 0 {
+		fmt.Printf(", %s written", formatBytes(written))
+	}
+	fmt.Println()
+	for _, r := range failed {
+		status := "FAILED"
+		if errors.Is(r.Err, ErrTimeout) {
+			status = "TIMEOUT"
+		}
+		fmt.Printf("  %s %s: %v\n", status, r.Input, r.Err)
+	}
+
+	return len(failed)
+}
+
+// batchExitCode returns the exit status for a finished batch: 0 if every
+// file converted, exitPartialBatch if only some did, and the status of the
+// first error if none did.
+func batchExitCode(results []batchResult) int {
+	var first error
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			if first == nil {
+				first = r.Err
+			}
+			failed++
+		}
+	}
+	switch {
+	case failed == 0:
+		return 0
+	case failed < len(results):
+		return exitPartialBatch
+	}
+	return exitCode(first)
+}
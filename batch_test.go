@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestConvertBatchProgress(t *testing.T) {
+	for _, workers := range []int{1, 2} {
+		fake := newFakeFFmpeg(t, 8, 8)
+		dir := t.TempDir()
+		input := animatedInput(t, dir)
+		jobs := []batchJob{
+			{Input: input, Output: filepath.Join(dir, "a.mp4")},
+			{Input: input, Output: filepath.Join(dir, "b.mp4")},
+		}
+		opts := testOptions()
+		opts.Method = "direct"
+		opts.Progress = true
+
+		convertBatch(jobs, opts, workers, false, nil)
+		// Only one file at a time may draw its progress
+		calls := fake.calls()
+		for _, call := range calls {
+			if got, want := slices.Contains(call, "-progress"), workers == 1; got != want {
+				t.Errorf("with %d workers, -progress passed to ffmpeg = %v, want %v", workers, got, want)
+			}
+		}
+		if len(calls) != len(jobs) {
+			t.Errorf("with %d workers, ffmpeg ran %d times, want %d", workers, len(calls), len(jobs))
+		}
+	}
+}
+
+func TestConvertBatch(t *testing.T) {
+	newFakeFFmpeg(t, 8, 8)
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.webp")
+	second := filepath.Join(dir, "second.webp")
+	writeTestWebP(t, first, 8, 8, 100, 100, 200)
+	writeTestWebP(t, second, 8, 8, 50, 50)
+	missing := filepath.Join(dir, "missing.webp")
+	notWebP := filepath.Join(dir, "notes.webp")
+	if err := os.WriteFile(notWebP, []byte("this is not an image at all"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := testOptions()
+	opts.Concurrency = 2
+
+	inputs := []string{first, missing, second, notWebP}
+	results, err := ConvertBatch(inputs, opts)
+	if !errors.Is(err, ErrInputNotFound) || !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("error = %v, want both failures", err)
+	}
+	if len(results) != len(inputs) {
+		t.Fatalf("got %d results, want %d", len(results), len(inputs))
+	}
+	wantErr := []error{nil, ErrInputNotFound, nil, ErrCorruptInput}
+	wantFrames := []int{3, 0, 2, 0}
+	for i, r := range results {
+		if want := strings.TrimSuffix(inputs[i], ".webp") + ".mp4"; r.Input != inputs[i] || r.Output != want {
+			t.Errorf("result %d converts %s to %s, want %s to %s", i, r.Input, r.Output, inputs[i], want)
+		}
+		if wantErr[i] == nil && r.Err != nil || !errors.Is(r.Err, wantErr[i]) {
+			t.Errorf("%s: Err = %v, want %v", filepath.Base(r.Input), r.Err, wantErr[i])
+		}
+		if r.Frames != wantFrames[i] {
+			t.Errorf("%s: Frames = %d, want %d", filepath.Base(r.Input), r.Frames, wantFrames[i])
+		}
+		if _, statErr := os.Stat(r.Output); (statErr == nil) != (wantErr[i] == nil) {
+			t.Errorf("%s: output exists = %v, want %v", filepath.Base(r.Input), statErr == nil, wantErr[i] == nil)
+		}
+	}
+}
+
+func TestConvertBatchAllSucceed(t *testing.T) {
+	newFakeFFmpeg(t, 8, 8)
+	dir := t.TempDir()
+	input := animatedInput(t, dir)
+
+	results, err := ConvertBatch([]string{input}, testOptions())
+	if err != nil || len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("ConvertBatch = %v, %v, want one converted file", results, err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// inspectCache holds the results of inspectWebP between runs when -cache
+// is given, and is nil otherwise.
+var inspectCache *webpCache
+
+// webpCache stores inspected WebPInfo on disk, keyed by absolute path and
+// checked against the file's size and modification time. The file is a log
+// of JSON lines that new entries are appended to, so concurrent batch
+// workers and interrupted runs never leave it half written; later lines
+// win when it is read back.
+type webpCache struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries map[string]cacheEntry
+}
+
+// cacheVersion is bumped when WebPInfo gains a field, so entries written
+// before it are inspected again rather than read back without it.
+const cacheVersion = 2
+
+// cacheEntry is one line of the cache file.
+type cacheEntry struct {
+	Version int      `json:"v"`
+	Path    string   `json:"path"`
+	Size    int64    `json:"size"`
+	ModTime int64    `json:"mtime_ns"`
+	Info    WebPInfo `json:"info"`
+}
+
+// defaultCachePath returns where -cache keeps its file,
+// ~/.cache/webp2mp4/inspect.jsonl on Linux, or the temp directory when
+// there is no user cache directory.
+func defaultCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "webp2mp4", "inspect.jsonl")
+}
+
+// openInspectCache loads the cache at path and enables it. Stale and
+// duplicate lines are dropped by rewriting the file when there are any.
+func openInspectCache(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	entries := make(map[string]cacheEntry)
+	lines := 0
+	if file, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			lines++
+			var entry cacheEntry
+			// Skip lines cut short by a crash
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.Path != "" {
+				entries[entry.Path] = entry
+			}
+		}
+		file.Close()
+	}
+	for key, entry := range entries {
+		if stat, err := os.Stat(entry.Path); err != nil || !entry.matches(stat) {
+			delete(entries, key)
+		}
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if lines > len(entries) {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open cache: %w", err)
+	}
+	cache := &webpCache{file: file, entries: entries}
+	if lines > len(entries) {
+		for _, entry := range entries {
+			cache.append(entry)
+		}
+	}
+	slog.Debug("loaded inspection cache", "path", path, "entries", len(entries))
+	inspectCache = cache
+	return nil
+}
+
+func (e cacheEntry) matches(stat os.FileInfo) bool {
+	return e.Version == cacheVersion && e.Size == stat.Size() && e.ModTime == stat.ModTime().UnixNano()
+}
+
+func (c *webpCache) append(entry cacheEntry) {
+	line, err := json.Marshal(entry)
+	if err == nil {
+		_, err = c.file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		slog.Warn("failed to write inspection cache", "err", err)
+	}
+}
+
+// inspectCached is inspectWebP that goes through the -cache when it is
+// enabled. Files that fail to inspect aren't cached.
+func inspectCached(input string) (WebPInfo, error) {
+	if inspectCache == nil {
+		return inspectWebP(input)
+	}
+	path, err := filepath.Abs(input)
+	if err != nil {
+		return inspectWebP(input)
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return inspectWebP(input)
+	}
+
+	inspectCache.mu.Lock()
+	entry, ok := inspectCache.entries[path]
+	inspectCache.mu.Unlock()
+	if ok && entry.matches(stat) {
+		return entry.Info, nil
+	}
+
+	info, err := inspectWebP(input)
+	if err != nil {
+		return info, err
+	}
+	entry = cacheEntry{Version: cacheVersion, Path: path, Size: stat.Size(), ModTime: stat.ModTime().UnixNano(), Info: info}
+	inspectCache.mu.Lock()
+	defer inspectCache.mu.Unlock()
+	inspectCache.entries[path] = entry
+	inspectCache.append(entry)
+	return info, nil
+}
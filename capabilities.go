@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// capabilities is what the installed ffmpeg can do: the encoders and
+// muxers it was built with, and the pixel formats of the encoders asked
+// about so far. It is probed once and shared by every file in a batch and
+// every request to the server.
+type capabilities struct {
+	encoders map[string]bool
+	muxers   map[string]bool
+
+	mu      sync.Mutex
+	pixFmts map[string][]string
+}
+
+// capsProbe holds the capabilities once ffmpegCapabilities has run.
+var capsProbe struct {
+	once sync.Once
+	caps *capabilities
+	err  error
+}
+
+// ffmpegCapabilities returns the capabilities of ffmpeg, probing them with
+// ffmpeg -encoders and -muxers the first time.
+func ffmpegCapabilities() (*capabilities, error) {
+	capsProbe.once.Do(func() {
+		encoders, err := exec.Command(ffmpegPath, "-hide_banner", "-encoders").Output()
+		if err != nil {
+			capsProbe.err = fmt.Errorf("failed to list ffmpeg encoders: %w", err)
+			return
+		}
+		muxers, err := exec.Command(ffmpegPath, "-hide_banner", "-muxers").Output()
+		if err != nil {
+			capsProbe.err = fmt.Errorf("failed to list ffmpeg muxers: %w", err)
+			return
+		}
+		capsProbe.caps = parseCapabilities(string(encoders), string(muxers))
+	})
+	return capsProbe.caps, capsProbe.err
+}
+
+// parseCapabilities builds capabilities from the output of ffmpeg
+// -encoders and ffmpeg -muxers.
+func parseCapabilities(encoders, muxers string) *capabilities {
+	return &capabilities{
+		encoders: parseFFmpegList(encoders),
+		muxers:   parseFFmpegList(muxers),
+		pixFmts:  make(map[string][]string),
+	}
+}
+
+// hasEncoder reports whether ffmpeg was built with the encoder name.
+func (c *capabilities) hasEncoder(name string) bool {
+	return c.encoders[name]
+}
+
+// hasMuxer reports whether ffmpeg can write the format name.
+func (c *capabilities) hasMuxer(name string) bool {
+	return c.muxers[name]
+}
+
+// pixelFormats returns the pixel formats ffmpeg lists for encoder, or nil
+// if it doesn't list any, asking ffmpeg only the first time.
+func (c *capabilities) pixelFormats(encoder string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if formats, ok := c.pixFmts[encoder]; ok {
+		return formats, nil
+	}
+	out, err := exec.Command(ffmpegPath, "-hide_banner", "-h", "encoder="+encoder).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", encoder, err)
+	}
+	formats := parsePixelFormats(string(out))
+	c.pixFmts[encoder] = formats
+	return formats, nil
+}
+
+// parsePixelFormats extracts the list from ffmpeg -h encoder=... output.
+func parsePixelFormats(out string) []string {
+	for _, line := range strings.Split(out, "\n") {
+		if list, ok := strings.CutPrefix(strings.TrimSpace(line), "Supported pixel formats:"); ok {
+			return strings.Fields(list)
+		}
+	}
+	return nil
+}
+
+// parseFFmpegList extracts names from ffmpeg -encoders or -muxers output,
+// where each entry follows the legend as "<flags> <name> <description>".
+func parseFFmpegList(out string) map[string]bool {
+	names := make(map[string]bool)
+	inList := false
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.HasPrefix(fields[0], "--") {
+			inList = true
+			continue
+		}
+		if inList && len(fields) >= 2 {
+			names[fields[1]] = true
+		}
+	}
+	return names
+}
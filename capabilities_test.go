@@ -0,0 +1,98 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// sampleEncoders is the start of ffmpeg 6's -hide_banner -encoders output.
+const sampleEncoders = `Encoders:
+ V..... = Video
+ A..... = Audio
+ S..... = Subtitle
+ .F.... = Frame-level multithreading
+ ..S... = Slice-level multithreading
+ ...X.. = Codec is experimental
+ ....B. = Supports draw_horiz_band
+ .....D = Supports direct rendering method 1
+ ------
+ V....D apng                 APNG (Animated Portable Network Graphics) image
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ V....D h264_nvenc           NVIDIA NVENC H.264 encoder (codec h264)
+ V..... h264_vaapi           H.264/AVC (VAAPI) (codec h264)
+ A....D aac                  AAC (Advanced Audio Coding)
+`
+
+// sampleMuxers is the start of ffmpeg 6's -hide_banner -muxers output.
+const sampleMuxers = `Muxers:
+ D. = Demuxing supported
+ .E = Muxing supported
+ --
+  E mov             QuickTime / MOV
+  E mp4             MP4 (MPEG-4 Part 14)
+  E webm            WebM
+`
+
+func TestParseCapabilities(t *testing.T) {
+	caps := parseCapabilities(sampleEncoders, sampleMuxers)
+	for _, name := range []string{"apng", "libx264", "h264_nvenc", "h264_vaapi", "aac"} {
+		if !caps.hasEncoder(name) {
+			t.Errorf("hasEncoder(%q) = false, want true", name)
+		}
+	}
+	// The legend above the list isn't an encoder
+	for _, name := range []string{"libx265", "=", "Video", "Encoders:", "V....."} {
+		if caps.hasEncoder(name) {
+			t.Errorf("hasEncoder(%q) = true, want false", name)
+		}
+	}
+	for _, name := range []string{"mov", "mp4", "webm"} {
+		if !caps.hasMuxer(name) {
+			t.Errorf("hasMuxer(%q) = false, want true", name)
+		}
+	}
+	for _, name := range []string{"matroska", "Muxing", "libx264"} {
+		if caps.hasMuxer(name) {
+			t.Errorf("hasMuxer(%q) = true, want false", name)
+		}
+	}
+}
+
+func TestParseFFmpegListWithoutList(t *testing.T) {
+	if names := parseFFmpegList("ffmpeg: unrecognized option '-encoders'\n"); len(names) != 0 {
+		t.Errorf("parseFFmpegList of an error = %v, want nothing", names)
+	}
+}
+
+func TestParsePixelFormats(t *testing.T) {
+	const help = `Encoder libx264 [libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10]:
+    General capabilities: dr1 delay threads
+    Threading capabilities: other
+    Supported pixel formats: yuv420p yuvj420p yuv422p yuvj422p yuv444p yuvj444p nv12 nv16 nv21 yuv420p10le yuv422p10le yuv444p10le nv20le gray gray10le
+libx264 AVOptions:
+  -preset            <string>     E..V....... Set the encoding preset (cf. x264 --fullhelp) (default "medium")
+`
+	formats := parsePixelFormats(help)
+	if len(formats) != 15 || formats[0] != "yuv420p" || !slices.Contains(formats, "yuv444p") {
+		t.Errorf("parsePixelFormats = %q, want the 15 formats from yuv420p to gray10le", formats)
+	}
+	if formats := parsePixelFormats("Codec 'foo' is not recognized by FFmpeg.\n"); formats != nil {
+		t.Errorf("parsePixelFormats without a list = %q, want nil", formats)
+	}
+}
+
+func TestPixelFormatsCached(t *testing.T) {
+	fake := newFakeFFmpeg(t, 8, 8)
+	caps := parseCapabilities(sampleEncoders, sampleMuxers)
+	for i := 0; i < 2; i++ {
+		formats, err := caps.pixelFormats("libx264")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertArgs(t, formats, []string{"yuv420p", "yuv422p", "yuv444p"})
+		// The second answer comes from the cache, not a fake that no
+		// longer exists
+		ffmpegPath = filepath.Join(fake.dir, "missing")
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// toolCheck is the probed state of one external program.
+type toolCheck struct {
+	Name    string
+	Purpose string
+	Path    string
+	Version string
+}
+
+// checkTool looks up bin in PATH and reads its version by running it with
+// versionArg and picking out the version from the first line of output. The
+// result is reported under name.
+func checkTool(name, bin, purpose, versionArg string, version func(line string) string) toolCheck {
+	tool := toolCheck{Name: name, Purpose: purpose}
+	path, err := exec.LookPath(bin)
+	if err != nil {
+		return tool
+	}
+	tool.Path = path
+
+	out, _ := exec.Command(path, versionArg).CombinedOutput()
+	if line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n"); line != "" {
+		tool.Version = version(line)
+	}
+	return tool
+}
+
+// versionField returns the nth whitespace-separated field of line.
+func versionField(n int) func(string) string {
+	return func(line string) string {
+		fields := strings.Fields(line)
+		if n < len(fields) {
+			return fields[n]
+		}
+		return line
+	}
+}
+
+// runCheck prints which external tools and encoders are available and
+// reports whether the minimum requirements are met: ffmpeg with an H.264
+// encoder this tool can drive.
+func runCheck(w io.Writer) bool {
+	magick := "convert"
+	if bin, _, err := imageMagickCmd(); err == nil {
+		magick = filepath.Base(bin)
+	}
+
+	tools := []toolCheck{
+		// "ffmpeg version 6.1.1 Copyright ..."
+		checkTool("ffmpeg", ffmpegPath, "required", "-version", versionField(2)),
+		// "Version: ImageMagick 6.9.12-98 Q16 ..."
+		checkTool(strings.TrimSuffix(magick, ".exe"), magick, "extraction fallback", "-version", versionField(2)),
+		// "1.3.2"
+		checkTool("webpmux", "webpmux", "-extractor webpmux", "-version", versionField(0)),
+	}
+
+	fmt.Fprintln(w, "Tools:")
+	for _, tool := range tools {
+		if tool.Path == "" {
+			fmt.Fprintf(w, "  %-8s missing (%s)\n", tool.Name, tool.Purpose)
+			continue
+		}
+		fmt.Fprintf(w, "  %-8s %-12s %s\n", tool.Name, tool.Version, tool.Path)
+	}
+
+	if tools[0].Path == "" {
+		fmt.Fprintln(w, "\nFAIL: ffmpeg is not installed or not in PATH.")
+		fmt.Fprintln(w, "Install it with your package manager, e.g. 'apt install ffmpeg' or 'brew install ffmpeg'.")
+		return false
+	}
+
+	caps, err := ffmpegCapabilities()
+	if err != nil {
+		fmt.Fprintf(w, "\nFAIL: %v\n", err)
+		return false
+	}
+
+	hwNames := make([]string, 0, len(hwEncoders))
+	for name := range hwEncoders {
+		hwNames = append(hwNames, name)
+	}
+	sort.Strings(hwNames)
+
+	fmt.Fprintln(w, "\nEncoders:")
+	for _, name := range []string{"libx264", "libvpx", "libvpx-vp9"} {
+		fmt.Fprintf(w, "  %-11s %s\n", name, yesNo(caps.hasEncoder(name)))
+	}
+
+	if !caps.hasEncoder(softwareEncoder.Name) {
+		for _, enc := range fallbackEncoders {
+			if caps.hasEncoder(enc.Name) {
+				fmt.Fprintf(w, "\nOK: libx264 is missing, %s will be used instead.\n", enc.Name)
+				return true
+			}
+		}
+		for _, name := range hwNames {
+			if caps.hasEncoder(hwEncoders[name].Name) {
+				fmt.Fprintf(w, "\nOK: libx264 is missing, but -hwaccel %s is available.\n", name)
+				return true
+			}
+		}
+		fmt.Fprintln(w, "\nFAIL: ffmpeg has no usable H.264 encoder.")
+		fmt.Fprintln(w, "Install an ffmpeg build with libx264 (most distribution packages include it).")
+		return false
+	}
+
+	if tools[1].Path == "" {
+		fmt.Fprintln(w, "\nOK, but ImageMagick is missing so the extraction fallback is limited.")
+		return true
+	}
+
+	fmt.Fprintln(w, "\nOK: all requirements are met.")
+	return true
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
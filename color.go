@@ -0,0 +1,50 @@
+package main
+
+import "strings"
+
+// colorSpec holds the ffmpeg names that describe one color space.
+type colorSpec struct {
+	// Matrix is the scale filter's out_color_matrix
+	Matrix     string
+	Colorspace string
+	Primaries  string
+	TRC        string
+}
+
+// colorSpaces maps -colorspace values to their ffmpeg names.
+var colorSpaces = map[string]colorSpec{
+	"bt709":  {Matrix: "bt709", Colorspace: "bt709", Primaries: "bt709", TRC: "bt709"},
+	"bt601":  {Matrix: "bt601", Colorspace: "smpte170m", Primaries: "smpte170m", TRC: "smpte170m"},
+	"bt2020": {Matrix: "bt2020", Colorspace: "bt2020nc", Primaries: "bt2020", TRC: "bt2020-10"},
+}
+
+// colorFilters returns the scale filter that converts to the -colorspace
+// matrix and -colorrange range, or nothing when neither is set. Without it
+// swscale converts RGB with the BT.601 matrix into limited range.
+func colorFilters(opts Options) []string {
+	var params []string
+	if opts.ColorSpace != "" {
+		params = append(params, "out_color_matrix="+colorSpaces[opts.ColorSpace].Matrix)
+	}
+	if opts.ColorRange != "" {
+		params = append(params, "out_range="+opts.ColorRange)
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return []string{"scale=" + strings.Join(params, ":")}
+}
+
+// colorArgs returns the output options that tag the stream with the
+// -colorspace and -colorrange it was converted to, so players don't guess.
+func colorArgs(opts Options) []string {
+	var args []string
+	if opts.ColorSpace != "" {
+		c := colorSpaces[opts.ColorSpace]
+		args = append(args, "-colorspace", c.Colorspace, "-color_primaries", c.Primaries, "-color_trc", c.TRC)
+	}
+	if opts.ColorRange != "" {
+		args = append(args, "-color_range", opts.ColorRange)
+	}
+	return args
+}
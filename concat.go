@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// concatEntry is one image in an ffconcat list and how long it is shown.
+type concatEntry struct {
+	File     string
+	Duration time.Duration
+}
+
+// repeatEntries returns entries repeated to play the given number of times.
+func repeatEntries(entries []concatEntry, plays int) []concatEntry {
+	out := make([]concatEntry, 0, len(entries)*plays)
+	for i := 0; i < plays; i++ {
+		out = append(out, entries...)
+	}
+	return out
+}
+
+// playOrder arranges entries for -reverse or -boomerang, keeping each
+// frame's duration with it. The boomerang's return trip starts from the
+// second to last frame so the turning point isn't shown twice.
+func playOrder(entries []concatEntry, opts Options) []concatEntry {
+	if !opts.Reverse && !opts.Boomerang {
+		return entries
+	}
+
+	reversed := make([]concatEntry, len(entries))
+	for i, entry := range entries {
+		reversed[len(entries)-1-i] = entry
+	}
+	if opts.Reverse {
+		return reversed
+	}
+	if len(reversed) > 0 {
+		reversed = reversed[1:]
+	}
+	return append(append([]concatEntry{}, entries...), reversed...)
+}
+
+// stepEntries keeps every step-th entry for -framestep, showing it for as
+// long as the frames dropped after it would have been, so the playback
+// speed doesn't change.
+func stepEntries(entries []concatEntry, step int) []concatEntry {
+	if step <= 1 {
+		return entries
+	}
+	out := make([]concatEntry, 0, (len(entries)+step-1)/step)
+	for i, entry := range entries {
+		if i%step == 0 {
+			out = append(out, entry)
+		} else {
+			out[len(out)-1].Duration += entry.Duration
+		}
+	}
+	return out
+}
+
+// writeConcatList writes entries as an ffconcat file. The concat demuxer
+// ignores the duration of the final entry, so the last file is listed a
+// second time to keep it on screen for its full duration.
+func writeConcatList(path string, entries []concatEntry) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to write concat list: %w", err)
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	fmt.Fprintln(w, "ffconcat version 1.0")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "file %s\n", quoteConcatPath(entry.File))
+		fmt.Fprintf(w, "duration %s\n", strconv.FormatFloat(entry.Duration.Seconds(), 'f', 6, 64))
+	}
+	if len(entries) > 0 {
+		fmt.Fprintf(w, "file %s\n", quoteConcatPath(entries[len(entries)-1].File))
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write concat list: %w", err)
+	}
+	return file.Close()
+}
+
+// quoteConcatPath quotes a path for an ffconcat file directive.
+func quoteConcatPath(path string) string {
+	return "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+}
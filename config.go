@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultConfigPath returns the config file read when -config isn't given,
+// ~/.config/webp2mp4.yaml on Linux.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "webp2mp4.yaml")
+}
+
+// readConfig parses a config file of "flag: value" lines, a flat subset of
+// YAML. Keys are flag names without the leading dash, values may be quoted,
+// and # starts a comment.
+func readConfig(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key: value", path, lineNo)
+		}
+		key = strings.TrimPrefix(strings.TrimSpace(key), "-")
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// applyConfig loads the config file at path and uses its values for every
+// flag that wasn't given on the command line, so flags take precedence over
+// the config and the config over built-in defaults. A missing file is only
+// an error when explicit is set.
+func applyConfig(fs *flag.FlagSet, path string, explicit bool) error {
+	if path == "" {
+		return nil
+	}
+	values, err := readConfig(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for key, value := range values {
+		if key == "config" || fs.Lookup(key) == nil {
+			return fmt.Errorf("%s: unknown option %q", path, key)
+		}
+		if set[key] {
+			continue
+		}
+		if err := fs.Set(key, value); err != nil {
+			return fmt.Errorf("%s: invalid value for %s: %w", path, key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// containerSpec describes an output container format.
+type containerSpec struct {
+	// Muxer is the ffmpeg -f name
+	Muxer string
+	// FastStart is set for formats that support moving the index to the
+	// front with -movflags +faststart
+	FastStart bool
+	// H264 reports whether the container can hold H.264 video
+	H264 bool
+	// VP9 reports whether it can hold VP9, for -codec vp9
+	VP9 bool
+	// Codec is the -codec the container is written with unless one is
+	// given
+	Codec string
+}
+
+// containers maps -container names and output extensions to formats.
+var containers = map[string]containerSpec{
+	"mp4":  {Muxer: "mp4", FastStart: true, H264: true, VP9: true, Codec: "h264"},
+	"m4v":  {Muxer: "mp4", FastStart: true, H264: true, Codec: "h264"},
+	"mov":  {Muxer: "mov", FastStart: true, H264: true, Codec: "h264"},
+	"mkv":  {Muxer: "matroska", H264: true, VP9: true, Codec: "h264"},
+	"ts":   {Muxer: "mpegts", H264: true, Codec: "h264"},
+	"webm": {Muxer: "webm", VP9: true, Codec: "vp9"},
+}
+
+// resolveContainer settles what -container and -codec leave to the name of
+// output: the container, and the codec it is written with unless -codec
+// was given. An extension no container has is written as MP4, with a
+// warning. Nothing changes for the -format values that aren't video.
+func resolveContainer(output string, opts *Options) {
+	if opts.Format != "video" {
+		return
+	}
+	name := strings.ToLower(opts.Container)
+	if name == "" && output != "-" {
+		name = strings.ToLower(strings.TrimPrefix(filepath.Ext(output), "."))
+	}
+	c, ok := containers[name]
+	if !ok {
+		if name != "" && opts.Container == "" {
+			slog.Warn("the output extension isn't a known container, writing MP4 (use -container to choose one)", "output", output, "extension", name)
+			opts.Container = "mp4"
+		}
+		// An unknown -container is rejected by validate
+		c = containers["mp4"]
+	}
+	if !opts.codecSet {
+		opts.Codec = c.Codec
+	}
+}
+
+// apngContainer and webpContainer are what -format apng and -format webp
+// write, whatever the output extension.
+var (
+	apngContainer = containerSpec{Muxer: "apng"}
+	webpContainer = containerSpec{Muxer: "webp"}
+)
+
+// containerFor returns the container output is written in with opts.
+func containerFor(output string, opts Options) (containerSpec, error) {
+	switch opts.Format {
+	case "apng":
+		return apngContainer, nil
+	case "webp":
+		return webpContainer, nil
+	case "hevc-alpha":
+		// Apple players only read HEVC with alpha from MOV
+		return containers["mov"], nil
+	}
+	return outputContainer(output, opts.Container, opts.Codec)
+}
+
+// outputExt returns the extension given to outputs that aren't named with
+// -o: png for -format apng, webp for -format webp, mov for -format
+// hevc-alpha, the -container otherwise, or webm for -codec vp9 without one.
+func (o Options) outputExt() string {
+	switch o.Format {
+	case "apng":
+		return "png"
+	case "webp":
+		return "webp"
+	case "hevc-alpha":
+		return "mov"
+	}
+	if o.Container == "" && o.Codec == "vp9" {
+		return "webm"
+	}
+	return o.Container
+}
+
+// outputContainer picks the container for output: the forced one when given,
+// otherwise the one matching its extension, and checks that it can hold
+// codec. Pipes and paths without an extension default to MP4.
+func outputContainer(output, forced, codec string) (containerSpec, error) {
+	name := strings.ToLower(forced)
+	source := "-container"
+	if name == "" {
+		name = strings.ToLower(strings.TrimPrefix(filepath.Ext(output), "."))
+		source = "output extension"
+	}
+	if name == "" || output == "-" && forced == "" {
+		name = "mp4"
+	}
+
+	holds := func(c containerSpec) bool {
+		if codec == "vp9" {
+			return c.VP9
+		}
+		return c.H264
+	}
+	c, ok := containers[name]
+	if !ok {
+		names := make([]string, 0, len(containers))
+		for n, spec := range containers {
+			if holds(spec) {
+				names = append(names, n)
+			}
+		}
+		sort.Strings(names)
+		return c, fmt.Errorf("unsupported %s %q (use -container with one of: %s)", source, name, strings.Join(names, ", "))
+	}
+	if !holds(c) {
+		if codec == "vp9" {
+			return c, fmt.Errorf("the %s container can't hold VP9 video", name)
+		}
+		if c.VP9 {
+			return c, fmt.Errorf("the %s container can't hold H.264 video (use -codec vp9)", name)
+		}
+		return c, fmt.Errorf("the %s container can't hold H.264 video", name)
+	}
+	return c, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// checkCover rejects -cover for outputs that can't hold an attached
+// picture: pipes, and containers other than MP4 and MOV.
+func checkCover(output string, c containerSpec) error {
+	if output == "-" {
+		return fmt.Errorf("-cover can't be added to a video written to stdout")
+	}
+	if nonSeekable(output) {
+		return fmt.Errorf("-cover can't be added to a video written to the pipe %s", output)
+	}
+	if !c.FastStart {
+		return fmt.Errorf("-cover needs an MP4, M4V or MOV output, not %s", c.Muxer)
+	}
+	return nil
+}
+
+// embedCover adds the first frame of input to output as an attached picture,
+// which players and file browsers show as its cover art. The frame is
+// written the same way as -thumbnail, and the video is remuxed with it, not
+// re-encoded, into a temp file that then replaces output.
+func embedCover(input, output string, opts Options) error {
+	c, err := containerFor(output, opts)
+	if err != nil {
+		return err
+	}
+	stat, err := os.Stat(output)
+	if err != nil && !opts.DryRun {
+		return err
+	}
+
+	cover, err := os.CreateTemp(opts.TempDir, "webp2mp4_cover_*.png")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	cover.Close()
+	defer os.Remove(cover.Name())
+	target, err := os.CreateTemp(filepath.Dir(output), ".webp2mp4_cover_*"+filepath.Ext(output))
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	target.Close()
+	defer os.Remove(target.Name())
+
+	if opts.DryRun {
+		fmt.Printf("# first frame of %s -> %s\n", input, cover.Name())
+	} else if err := writeThumbnail(input, cover.Name(), 0, 0); err != nil {
+		return fmt.Errorf("failed to write cover: %w", err)
+	}
+
+	args := []string{"-i", ffmpegFile(output), "-i", ffmpegFile(cover.Name()),
+		"-map", "0", "-map", "1", "-c", "copy", "-c:v:1", "png", "-disposition:v:1", "attached_pic"}
+	if opts.FastStart {
+		args = append(args, "-movflags", "+faststart")
+	}
+	args = append(args, "-f", c.Muxer, "-y", ffmpegFile(target.Name()))
+	slog.Debug("embedding cover", "cmd", commandLine(ffmpegPath, args))
+	if err := runFFmpeg(args, opts, 0); err != nil {
+		return fmt.Errorf("%w to embed cover: %w", ErrEncodeFailed, err)
+	}
+	if opts.DryRun {
+		return nil
+	}
+
+	// CreateTemp makes the file private, keep the output's mode
+	if err := os.Chmod(target.Name(), stat.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Rename(target.Name(), output); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", output, err)
+	}
+	return nil
+}
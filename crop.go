@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// cropSpec is a parsed -crop region.
+type cropSpec struct {
+	Width  int
+	Height int
+	X      int
+	Y      int
+}
+
+// parseCrop parses a -crop value of the form W:H:X:Y, or W:H to crop around
+// the centre.
+func parseCrop(expr string) (cropSpec, error) {
+	parts := strings.Split(expr, ":")
+	if len(parts) != 2 && len(parts) != 4 {
+		return cropSpec{}, fmt.Errorf("invalid -crop %q: expected W:H:X:Y", expr)
+	}
+	values := []int{0, 0, -1, -1}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || i < 2 && n == 0 {
+			return cropSpec{}, fmt.Errorf("invalid -crop %q: sizes must be positive and offsets not negative", expr)
+		}
+		values[i] = n
+	}
+	return cropSpec{Width: values[0], Height: values[1], X: values[2], Y: values[3]}, nil
+}
+
+// String formats c as a -crop value.
+func (c cropSpec) String() string {
+	return fmt.Sprintf("%d:%d:%d:%d", c.Width, c.Height, c.X, c.Y)
+}
+
+// filter returns the ffmpeg crop filter for c.
+func (c cropSpec) filter() string {
+	if c.X < 0 {
+		return fmt.Sprintf("crop=%d:%d", c.Width, c.Height)
+	}
+	return fmt.Sprintf("crop=%d:%d:%d:%d", c.Width, c.Height, c.X, c.Y)
+}
+
+// fits checks that c lies within a width x height source.
+func (c cropSpec) fits(width, height int) error {
+	x, y := max(c.X, 0), max(c.Y, 0)
+	if x+c.Width > width || y+c.Height > height {
+		return fmt.Errorf("-crop %s doesn't fit within the %dx%d source", c, width, height)
+	}
+	return nil
+}
+
+// detectCrop finds the smallest region that contains every pixel of every
+// frame differing from the border, which is taken to be the colour of the
+// top-left pixel of the first frame. Transparent pixels all count as the same
+// colour, so transparent padding is trimmed too. The region is grown to even
+// dimensions and offsets for H.264's chroma subsampling.
+func detectCrop(input string) (cropSpec, error) {
+	var (
+		background color.NRGBA
+		bounds     image.Rectangle
+		found      bool
+	)
+	err := decodeAnimation(input, func(index int, img image.Image) error {
+		b := img.Bounds()
+		if index == 0 {
+			background = opaqueOrClear(img.At(b.Min.X, b.Min.Y))
+			bounds = b
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if opaqueOrClear(img.At(x, y)) == background {
+					continue
+				}
+				r := image.Rect(x, y, x+1, y+1)
+				if !found {
+					bounds, found = r, true
+				} else {
+					bounds = bounds.Union(r)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return cropSpec{}, fmt.Errorf("failed to detect crop: %w", err)
+	}
+	if !found {
+		return cropSpec{}, errors.New("failed to detect crop: every frame is blank")
+	}
+
+	info, err := inspectWebP(input)
+	if err != nil {
+		return cropSpec{}, err
+	}
+	x, y := bounds.Min.X&^1, bounds.Min.Y&^1
+	w, h := makeEven(bounds.Max.X-x), makeEven(bounds.Max.Y-y)
+	w, h = min(w, info.Width-x), min(h, info.Height-y)
+	return cropSpec{Width: w, Height: h, X: x, Y: y}, nil
+}
+
+// opaqueOrClear converts c to NRGBA, mapping every fully transparent colour
+// to the same value.
+func opaqueOrClear(c color.Color) color.NRGBA {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if n.A == 0 {
+		return color.NRGBA{}
+	}
+	return n
+}
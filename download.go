@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// downloadTimeout bounds the whole request, including the body
+	downloadTimeout = 60 * time.Second
+	// maxDownloadSize caps how much of a remote input is fetched
+	maxDownloadSize = 100 << 20
+)
+
+// isURL reports whether input is an http or https URL rather than a path.
+func isURL(input string) bool {
+	return strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://")
+}
+
+// urlFileName returns the last path element of rawURL, which names the
+// default output for a remote input.
+func urlFileName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || path.Base(u.Path) == "/" || path.Base(u.Path) == "." {
+		return "download.webp"
+	}
+	return path.Base(u.Path)
+}
+
+// downloadInput fetches rawURL into a temp file and returns its path.
+// Redirects are followed, and anything but a 200 response or a body over
+// maxDownloadSize is an error. Cancelling ctx aborts the transfer.
+func downloadInput(ctx context.Context, rawURL string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, downloadTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download %s: server returned %s", rawURL, resp.Status)
+	}
+	if resp.ContentLength > maxDownloadSize {
+		return "", fmt.Errorf("failed to download %s: %d bytes exceeds the %d MB limit", rawURL, resp.ContentLength, maxDownloadSize>>20)
+	}
+
+	file, err := os.CreateTemp("", "webp2mp4_download_*.webp")
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+	n, err := io.Copy(file, io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err == nil && n > maxDownloadSize {
+		err = fmt.Errorf("response exceeds the %d MB limit", maxDownloadSize>>20)
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+	return file.Name(), nil
+}
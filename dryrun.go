@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// printCommand writes a command line to stdout for -dry-run, quoted so it
+// can be pasted into a POSIX shell.
+func printCommand(name string, args []string) {
+	fmt.Println(commandLine(name, args))
+}
+
+// commandLine joins a command and its arguments into a line that can be
+// pasted into a POSIX shell.
+func commandLine(name string, args []string) string {
+	quoted := make([]string, len(args)+1)
+	quoted[0] = shellQuote(name)
+	for i, arg := range args {
+		quoted[i+1] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote single-quotes s if it contains anything a shell would
+// interpret.
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=+,%@", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
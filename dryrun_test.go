@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"frame_%06d.png", "frame_%06d.png"},
+		{"file:-clip.webp", "file:-clip.webp"},
+		{"", "''"},
+		{"my clip.webp", "'my clip.webp'"},
+		{"it's.webp", `'it'\''s.webp'`},
+		{"$HOME;rm -rf *", "'$HOME;rm -rf *'"},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCommandLineRunsInAShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	args := []string{"%s\n", "my clip.webp", "it's.webp", `"quoted" \back`, "$HOME `id` $(id)", "a;b|c&d", "*.webp", "", "new\nline"}
+	out, err := exec.Command("sh", "-c", commandLine("printf", args)).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(args[1:], "\n") + "\n"; string(out) != want {
+		t.Errorf("the shell saw\n%q\nwant\n%q", out, want)
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// encoderSpec describes how to drive an H.264 encoder.
+type encoderSpec struct {
+	// Name is the ffmpeg encoder name
+	Name string
+	// PixFmt is passed as -pix_fmt, empty when the filters set the format
+	PixFmt string
+	// Filters are appended to the end of the -vf chain
+	Filters []string
+	// InputArgs are global options that must precede the inputs
+	InputArgs []string
+	// Preset reports whether the encoder understands -preset
+	Preset bool
+	// TwoPass reports whether the encoder supports -2pass
+	TwoPass bool
+	// Lossless is set for encoders that ignore -b
+	Lossless bool
+	// Profile reports whether the encoder takes H.264 -profile and -level
+	Profile bool
+	// Quality is the encoder option -crf is passed as: -crf itself, or -q:v
+	// for VideoToolbox. Empty when the encoder only targets a bitrate.
+	Quality string
+	// LosslessArgs replace the rate options for -lossless, nil when the
+	// encoder can't encode losslessly
+	LosslessArgs []string
+	// Args are further encoder options, such as the alpha quality of
+	// hevc_videotoolbox
+	Args []string
+}
+
+// softwareEncoder is the default libx264 encoder.
+// With -qp 0 it picks the High 4:4:4 Predictive profile, the only lossless
+// H.264 profile, itself.
+var softwareEncoder = encoderSpec{Name: "libx264", PixFmt: "yuv420p", Preset: true, TwoPass: true, Profile: true, Quality: "-crf",
+	LosslessArgs: []string{"-qp", "0"}}
+
+// hevcEncoder is libx265, used for -codec hevc. ffmpeg's -pass doesn't
+// drive it, so it has no -2pass.
+var hevcEncoder = encoderSpec{Name: "libx265", PixFmt: "yuv420p", Preset: true, Quality: "-crf",
+	LosslessArgs: []string{"-x265-params", "lossless=1"}}
+
+// vp9Encoder is libvpx-vp9, used for -codec vp9 and WebM outputs.
+var vp9Encoder = encoderSpec{Name: "libvpx-vp9", PixFmt: "yuv420p", TwoPass: true, Quality: "-crf",
+	LosslessArgs: []string{"-lossless", "1"}}
+
+// apngEncoder encodes -format apng, keeping the alpha channel.
+var apngEncoder = encoderSpec{Name: "apng", PixFmt: "rgba", Lossless: true}
+
+// webpEncoder encodes -format webp. libwebp takes BGRA, keeping the alpha
+// channel, and converts it itself for lossy frames.
+var webpEncoder = encoderSpec{Name: "libwebp_anim", PixFmt: "bgra",
+	LosslessArgs: []string{"-lossless", "1"}}
+
+// hevcAlphaEncoders encode -format hevc-alpha, best first: VideoToolbox,
+// which Apple's own tools use and which takes BGRA, then libx265, which
+// only keeps the alpha channel when built with it (ffmpeg 7.1 and later
+// list yuva420p among its pixel formats then).
+var hevcAlphaEncoders = []encoderSpec{
+	{Name: "hevc_videotoolbox", PixFmt: "bgra", Quality: "-q:v", Args: []string{"-alpha_quality", "0.75"}},
+	{Name: "libx265", PixFmt: "yuva420p", Preset: true, Quality: "-crf"},
+}
+
+// fallbackEncoders are tried in order when ffmpeg was built without libx264.
+// mpeg4 isn't H.264, but every ffmpeg build has it and MP4 players handle it.
+var fallbackEncoders = []encoderSpec{
+	{Name: "libopenh264", PixFmt: "yuv420p", Profile: true},
+	{Name: "mpeg4", PixFmt: "yuv420p", TwoPass: true},
+}
+
+// hwEncoders maps -hwaccel values to their H.264 encoders.
+var hwEncoders = map[string]encoderSpec{
+	"nvenc": {Name: "h264_nvenc", PixFmt: "yuv420p", Preset: true, Profile: true},
+	"qsv":   {Name: "h264_qsv", PixFmt: "nv12", Preset: true, Profile: true},
+	"vaapi": {
+		Name: "h264_vaapi",
+		// VAAPI encodes from GPU surfaces, so frames are uploaded after
+		// conversion to a format the hardware accepts
+		Filters:   []string{"format=nv12", "hwupload"},
+		InputArgs: []string{"-vaapi_device", "/dev/dri/renderD128"},
+		Profile:   true,
+	},
+	"videotoolbox": {Name: "h264_videotoolbox", PixFmt: "yuv420p", Profile: true, Quality: "-q:v"},
+}
+
+// hevcHWEncoders maps the -hwaccel values that can encode -codec hevc to
+// their encoders.
+var hevcHWEncoders = map[string]encoderSpec{
+	"videotoolbox": {Name: "hevc_videotoolbox", PixFmt: "yuv420p", Quality: "-q:v"},
+}
+
+// selectEncoder resolves -format, -codec and -hwaccel to an encoder,
+// checking that ffmpeg was built with it.
+func selectEncoder(opts Options) (encoderSpec, error) {
+	switch opts.Format {
+	case "apng":
+		return apngEncoder, nil
+	case "webp":
+		caps, err := ffmpegCapabilities()
+		if err != nil {
+			return encoderSpec{}, err
+		}
+		if !caps.hasEncoder(webpEncoder.Name) {
+			return encoderSpec{}, fmt.Errorf("ffmpeg was not built with the %s encoder required by -format webp (it comes with libwebp)", webpEncoder.Name)
+		}
+		return webpEncoder, nil
+	case "hevc-alpha":
+		return selectHEVCAlphaEncoder()
+	}
+	hwaccel := opts.HWAccel
+	if opts.Codec == "vp9" {
+		return selectCodecEncoder(opts.Codec)
+	}
+	if hwaccel == "auto" {
+		return selectAutoEncoder(opts.Codec)
+	}
+	if hwaccel == "" || hwaccel == "none" {
+		return selectCodecEncoder(opts.Codec)
+	}
+
+	enc, ok := hwEncoderFor(opts.Codec, hwaccel)
+	if !ok {
+		names := make([]string, 0, len(hwEncoders))
+		for name := range hwEncoders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return encoderSpec{}, fmt.Errorf("unknown -hwaccel %q (valid: %s)", hwaccel, strings.Join(names, ", "))
+	}
+
+	caps, err := ffmpegCapabilities()
+	if err != nil {
+		return encoderSpec{}, err
+	}
+	if !caps.hasEncoder(enc.Name) {
+		return encoderSpec{}, fmt.Errorf("ffmpeg was not built with the %s encoder required by -hwaccel %s", enc.Name, hwaccel)
+	}
+
+	return enc, nil
+}
+
+// autoOrder lists the -hwaccel values -hwaccel auto tries, best first, on
+// the platforms where they exist.
+var autoOrder = []struct {
+	hwaccel string
+	goos    string
+}{
+	{"nvenc", ""},
+	{"videotoolbox", "darwin"},
+	{"vaapi", "linux"},
+}
+
+// hwEncoderFor returns the -hwaccel encoder for codec, if there is one.
+func hwEncoderFor(codec, hwaccel string) (encoderSpec, bool) {
+	if codec == "hevc" {
+		enc, ok := hevcHWEncoders[hwaccel]
+		return enc, ok
+	}
+	enc, ok := hwEncoders[hwaccel]
+	return enc, ok
+}
+
+// encoderChoice caches an encoder selection, which is the same for every
+// file in a batch.
+type encoderChoice struct {
+	once sync.Once
+	enc  encoderSpec
+	err  error
+}
+
+// autoProbe caches the -hwaccel auto choice for each -codec.
+var autoProbe = map[string]*encoderChoice{"h264": {}, "hevc": {}}
+
+// selectAutoEncoder returns the first encoder for codec in autoOrder that
+// can actually encode on this machine, falling back to the software encoder.
+// Having the encoder compiled into ffmpeg isn't enough, as the GPU or driver
+// may be missing, so each one encodes a single test frame.
+func selectAutoEncoder(codec string) (encoderSpec, error) {
+	probe := autoProbe[codec]
+	probe.once.Do(func() {
+		caps, err := ffmpegCapabilities()
+		if err == nil {
+			for _, candidate := range autoOrder {
+				enc, ok := hwEncoderFor(codec, candidate.hwaccel)
+				if !ok || candidate.goos != "" && candidate.goos != runtime.GOOS || !caps.hasEncoder(enc.Name) {
+					continue
+				}
+				if err := probeEncoder(enc); err != nil {
+					slog.Debug("hardware encoder unusable", "encoder", enc.Name, "err", err)
+					continue
+				}
+				slog.Debug("selected encoder for -hwaccel auto", "encoder", enc.Name)
+				probe.enc = enc
+				return
+			}
+		}
+		probe.enc, probe.err = selectCodecEncoder(codec)
+		if probe.err == nil {
+			slog.Debug("no hardware encoder available for -hwaccel auto", "encoder", probe.enc.Name)
+		}
+	})
+	return probe.enc, probe.err
+}
+
+// selectCodecEncoder returns the software encoder for codec: libx265 for
+// hevc, libvpx-vp9 for vp9, otherwise libx264 or its fallback.
+func selectCodecEncoder(codec string) (encoderSpec, error) {
+	enc := hevcEncoder
+	switch codec {
+	case "hevc":
+	case "vp9":
+		enc = vp9Encoder
+	default:
+		return selectSoftwareEncoder()
+	}
+	caps, err := ffmpegCapabilities()
+	if err != nil {
+		return encoderSpec{}, err
+	}
+	if !caps.hasEncoder(enc.Name) {
+		return encoderSpec{}, fmt.Errorf("%w: ffmpeg was not built with %s, which -codec %s needs", ErrEncoderMissing, enc.Name, codec)
+	}
+	return enc, nil
+}
+
+// selectHEVCAlphaEncoder returns the first of hevcAlphaEncoders that ffmpeg
+// can encode transparency with.
+func selectHEVCAlphaEncoder() (encoderSpec, error) {
+	caps, err := ffmpegCapabilities()
+	if err != nil {
+		return encoderSpec{}, err
+	}
+	for _, enc := range hevcAlphaEncoders {
+		if !caps.hasEncoder(enc.Name) {
+			continue
+		}
+		formats, err := caps.pixelFormats(enc.Name)
+		if err == nil && formats != nil && !slices.Contains(formats, enc.PixFmt) {
+			slog.Debug("encoder can't encode transparency", "encoder", enc.Name, "pix_fmts", formats)
+			continue
+		}
+		return enc, nil
+	}
+	return encoderSpec{}, fmt.Errorf("%w: -format hevc-alpha needs hevc_videotoolbox (macOS) or a libx265 built with alpha support (yuva420p, ffmpeg 7.1 or later), and this ffmpeg has neither", ErrEncoderMissing)
+}
+
+// probeEncoder encodes one generated frame with enc and discards it.
+func probeEncoder(enc encoderSpec) error {
+	args := append([]string{"-hide_banner", "-loglevel", "error"}, enc.InputArgs...)
+	args = append(args, "-f", "lavfi", "-i", "color=size=256x256:duration=0.1", "-frames:v", "1")
+	if len(enc.Filters) > 0 {
+		args = append(args, "-vf", strings.Join(enc.Filters, ","))
+	}
+	if enc.PixFmt != "" {
+		args = append(args, "-pix_fmt", enc.PixFmt)
+	}
+	args = append(args, "-c:v", enc.Name, "-f", "null", "-")
+	if out, err := exec.Command(ffmpegPath, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// softwareProbe caches the software encoder choice, so the fallback warning
+// is only logged once per run.
+var softwareProbe struct {
+	once sync.Once
+	enc  encoderSpec
+	err  error
+}
+
+// selectSoftwareEncoder returns libx264, or the first of fallbackEncoders
+// that ffmpeg has when it was built without libx264.
+func selectSoftwareEncoder() (encoderSpec, error) {
+	softwareProbe.once.Do(func() {
+		softwareProbe.enc = softwareEncoder
+		caps, err := ffmpegCapabilities()
+		if err != nil {
+			// Leave it to ffmpeg to report what is wrong
+			slog.Debug("could not check for libx264", "err", err)
+			return
+		}
+		if caps.hasEncoder(softwareEncoder.Name) {
+			return
+		}
+		for _, enc := range fallbackEncoders {
+			if caps.hasEncoder(enc.Name) {
+				slog.Warn("ffmpeg was built without libx264, using a fallback encoder", "encoder", enc.Name, "hint", "install an ffmpeg build with libx264 for better quality")
+				softwareProbe.enc = enc
+				return
+			}
+		}
+		names := []string{softwareEncoder.Name}
+		for _, enc := range fallbackEncoders {
+			names = append(names, enc.Name)
+		}
+		softwareProbe.err = fmt.Errorf("%w: ffmpeg has none of %s; install an ffmpeg build with libx264 or use -hwaccel", ErrEncoderMissing, strings.Join(names, ", "))
+	})
+	return softwareProbe.enc, softwareProbe.err
+}
+
+// encodeArgs returns the filter and codec options for enc. A -speed retiming
+// comes first in the chain, -vf-extra follows the managed filters and
+// filters required by the encoder, such as hwupload for VAAPI, go last,
+// after any watermark overlay. The chain always ends in a format filter for
+// the encoder's pixel format, so the conversion happens in the filter graph
+// whatever the source's format is, rather than being left to the encoder.
+func encodeArgs(enc encoderSpec, filters []string, opts Options) []string {
+	var chain []string
+	if opts.Speed != 1 {
+		chain = append(chain, fmt.Sprintf("setpts=PTS/%g", opts.Speed))
+	}
+	chain = append(chain, filters...)
+	if extra := strings.Trim(opts.ExtraFilters, ", "); extra != "" {
+		chain = append(chain, extra)
+	}
+	if opts.padDuration > 0 {
+		chain = append(chain, "tpad=stop_mode=clone:stop_duration="+ffmpegSeconds(opts.padDuration))
+	}
+	chain = append(chain, colorFilters(opts)...)
+	if enc.PixFmt != "" {
+		chain = append(chain, "format="+enc.pixFmt(opts))
+	}
+	var post []string
+	for _, f := range enc.Filters {
+		// Encoders fed through a format filter take -pixfmt there
+		if opts.PixFmt != "" && strings.HasPrefix(f, "format=") {
+			f = "format=" + opts.PixFmt
+		}
+		post = append(post, f)
+	}
+
+	args := []string{"-c:v", enc.Name}
+	if enc.PixFmt != "" {
+		args = append(args, "-pix_fmt", enc.pixFmt(opts))
+	}
+	args = append(args, rateArgs(enc, opts)...)
+	args = append(args, profileArgs(opts)...)
+	if opts.GOP > 0 {
+		// Keep scene cuts from adding keyframes closer together than that
+		gop := strconv.Itoa(opts.GOP)
+		args = append(args, "-g", gop, "-keyint_min", gop)
+	}
+	if opts.BFrames >= 0 {
+		args = append(args, "-bf", strconv.Itoa(opts.BFrames))
+	}
+	if opts.Refs >= 0 {
+		args = append(args, "-refs", strconv.Itoa(opts.Refs))
+	}
+	args = append(args, colorArgs(opts)...)
+	if opts.Aspect != "" {
+		args = append(args, "-aspect", opts.Aspect)
+	}
+	args = append(args, enc.Args...)
+	if opts.Watermark != "" {
+		args = append(args, "-filter_complex", watermarkGraph(chain, post, opts), "-map", "[vout]")
+	} else if chain = append(chain, post...); len(chain) > 0 {
+		args = append(args, "-vf", strings.Join(chain, ","))
+	}
+	args = append(args, audioArgs(opts)...)
+	if enc.Preset {
+		args = append(args, "-preset", "medium")
+	}
+	if opts.Tune != "" {
+		args = append(args, "-tune", opts.Tune)
+	}
+	if opts.Threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(opts.Threads))
+	}
+	return args
+}
+
+// rateArgs returns the rate control options: -b:v, or with -crf the
+// encoder's constant quality option. VideoToolbox's -q:v runs the other way,
+// from 1 to 100 with higher being better, so -crf is mapped onto that scale.
+func rateArgs(enc encoderSpec, opts Options) []string {
+	switch {
+	case enc.Lossless:
+		return nil
+	case opts.Lossless:
+		return enc.LosslessArgs
+	case enc.Name == webpEncoder.Name:
+		return []string{"-quality", strconv.Itoa(opts.WebPQuality)}
+	case opts.CRF > 0 && enc.Quality == "-q:v":
+		return []string{"-q:v", strconv.Itoa(100 - (opts.CRF-1)*99/50)}
+	case opts.CRF > 0 && enc.Name == vp9Encoder.Name:
+		// libvpx only holds a constant quality without a bitrate target
+		return []string{"-crf", strconv.Itoa(opts.CRF), "-b:v", "0"}
+	case opts.CRF > 0:
+		return []string{enc.Quality, strconv.Itoa(opts.CRF)}
+	}
+	return []string{"-b:v", opts.Bitrate}
+}
+
+// pixFmt returns the pixel format to encode with, which is the -pixfmt
+// override if given and the encoder's default otherwise.
+func (enc encoderSpec) pixFmt(opts Options) string {
+	if opts.PixFmt != "" {
+		return opts.PixFmt
+	}
+	return enc.PixFmt
+}
+
+// checkPixFmt verifies that enc accepts pixFmt. ffmpeg would otherwise only
+// warn and silently pick a different format.
+func checkPixFmt(enc encoderSpec, pixFmt string) error {
+	caps, err := ffmpegCapabilities()
+	if err != nil {
+		return nil
+	}
+	formats, err := caps.pixelFormats(enc.Name)
+	if err != nil || formats == nil {
+		// Not every encoder lists its formats, leave the check to ffmpeg
+		return nil
+	}
+	for _, f := range formats {
+		if f == pixFmt {
+			return nil
+		}
+	}
+	return fmt.Errorf("pixel format %q is not supported by %s (supported: %s)", pixFmt, enc.Name, strings.Join(formats, " "))
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestEncodeArgs(t *testing.T) {
+	const scale = "scale=10:8:flags=lanczos"
+	tests := []struct {
+		name   string
+		enc    encoderSpec
+		pixFmt string
+		crf    int
+		want   []string
+	}{
+		{"libx264", softwareEncoder, "", 0,
+			[]string{"-c:v", "libx264", "-pix_fmt", "yuv420p", "-b:v", "2M", "-vf", scale + ",format=yuv420p", "-preset", "medium"}},
+		{"libx264 yuv444p", softwareEncoder, "yuv444p", 0,
+			[]string{"-c:v", "libx264", "-pix_fmt", "yuv444p", "-b:v", "2M", "-vf", scale + ",format=yuv444p", "-preset", "medium"}},
+		{"nvenc", hwEncoders["nvenc"], "", 0,
+			[]string{"-c:v", "h264_nvenc", "-pix_fmt", "yuv420p", "-b:v", "2M", "-vf", scale + ",format=yuv420p", "-preset", "medium"}},
+		{"qsv", hwEncoders["qsv"], "", 0,
+			[]string{"-c:v", "h264_qsv", "-pix_fmt", "nv12", "-b:v", "2M", "-vf", scale + ",format=nv12", "-preset", "medium"}},
+		// VAAPI takes the format in its upload filters, not -pix_fmt
+		{"vaapi", hwEncoders["vaapi"], "", 0,
+			[]string{"-c:v", "h264_vaapi", "-b:v", "2M", "-vf", scale + ",format=nv12,hwupload"}},
+		{"vaapi p010le", hwEncoders["vaapi"], "p010le", 0,
+			[]string{"-c:v", "h264_vaapi", "-b:v", "2M", "-vf", scale + ",format=p010le,hwupload"}},
+		{"videotoolbox crf", hwEncoders["videotoolbox"], "", 23,
+			[]string{"-c:v", "h264_videotoolbox", "-pix_fmt", "yuv420p", "-q:v", "57", "-vf", scale + ",format=yuv420p"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions()
+			opts.PixFmt, opts.CRF = tt.pixFmt, tt.crf
+			assertArgs(t, encodeArgs(tt.enc, []string{scale}, opts), tt.want)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Sentinel errors returned (wrapped) by the conversion functions, so callers
+// can tell failure modes apart with errors.Is.
+var (
+	// ErrInputNotFound means the input file does not exist.
+	ErrInputNotFound = errors.New("input file does not exist")
+	// ErrOutputDirMissing means the directory the output goes in does not
+	// exist and -mkdir was not given.
+	ErrOutputDirMissing = errors.New("output directory does not exist")
+	// ErrCorruptInput means the input is empty, cut short or not a WebP.
+	ErrCorruptInput = errors.New("corrupt or truncated WebP")
+	// ErrNoFrames means no frames could be extracted from the input.
+	ErrNoFrames = errors.New("no frames extracted from WebP")
+	// ErrFFmpegMissing means ffmpeg is not installed or not in PATH.
+	ErrFFmpegMissing = errors.New("ffmpeg is not installed or not in PATH")
+	// ErrToolMissing means -strict-deps found a tool the chosen method may
+	// need, such as ImageMagick for the extraction fallback, missing.
+	ErrToolMissing = errors.New("required tool is not installed or not in PATH")
+	// ErrEncoderMissing means ffmpeg has none of the encoders that can be
+	// used.
+	ErrEncoderMissing = errors.New("no usable video encoder")
+	// ErrTimeout means the conversion took longer than -timeout.
+	ErrTimeout = errors.New("conversion timed out")
+	// ErrEncodeFailed means ffmpeg ran but failed to produce the video.
+	ErrEncodeFailed = errors.New("ffmpeg failed")
+	// ErrVerifyFailed means ffmpeg reported success but the output is
+	// missing, empty or, with -verify, not the expected video.
+	ErrVerifyFailed = errors.New("output verification failed")
+	// ErrNoSpace means the disk filled up while writing the output or the
+	// temp files.
+	ErrNoSpace = errors.New("no space left on device")
+	// ErrNotWritable means the output or temp files couldn't be written
+	// for lack of permission or because the filesystem is read-only.
+	ErrNotWritable = errors.New("permission denied or read-only filesystem")
+	// ErrUnreadableInput means ffmpeg failed with one of the errors it has
+	// for input it can't decode, which in auto mode means a direct
+	// conversion falls back to frame extraction.
+	ErrUnreadableInput = errors.New("ffmpeg can't decode the input")
+)
+
+// Exit statuses, so scripts can tell failure modes apart. Anything not
+// listed, such as invalid options, exits with exitFailure.
+const (
+	exitFailure       = 1
+	exitInputNotFound = 2
+	exitFFmpegMissing = 3
+	exitEncodeFailed  = 4
+	exitPartialBatch  = 5
+)
+
+// exitCode returns the exit status for a run that failed with err.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, ErrInputNotFound):
+		return exitInputNotFound
+	case errors.Is(err, ErrFFmpegMissing), errors.Is(err, ErrEncoderMissing), errors.Is(err, ErrToolMissing):
+		return exitFFmpegMissing
+	case errors.Is(err, ErrEncodeFailed), errors.Is(err, ErrVerifyFailed), errors.Is(err, ErrNoFrames), errors.Is(err, ErrTimeout):
+		return exitEncodeFailed
+	}
+	// Frame extraction failing with ffmpeg or ImageMagick
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitEncodeFailed
+	}
+	return exitFailure
+}
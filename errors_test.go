@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestConversionSentinels(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup prepares the fake ffmpeg and returns the input and the
+		// options to convert it with
+		setup func(t *testing.T, fake *fakeFFmpeg, dir string) (string, Options)
+		want  error
+	}{
+		{"missing input", func(t *testing.T, fake *fakeFFmpeg, dir string) (string, Options) {
+			return filepath.Join(dir, "missing.webp"), testOptions()
+		}, ErrInputNotFound},
+		{"not a WebP", func(t *testing.T, fake *fakeFFmpeg, dir string) (string, Options) {
+			input := filepath.Join(dir, "text.webp")
+			if err := os.WriteFile(input, []byte("this is not an image at all"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			return input, testOptions()
+		}, ErrCorruptInput},
+		{"no frames extracted", func(t *testing.T, fake *fakeFFmpeg, dir string) (string, Options) {
+			fake.set("frame_count", "0")
+			opts := testOptions()
+			opts.Method = "extract"
+			return animatedInput(t, dir), opts
+		}, ErrNoFrames},
+		{"encode failed", func(t *testing.T, fake *fakeFFmpeg, dir string) (string, Options) {
+			fake.failOn("-c:v", "Unrecognized option 'foo'\n")
+			opts := testOptions()
+			opts.Method = "direct"
+			return animatedInput(t, dir), opts
+		}, ErrEncodeFailed},
+		{"disk full", func(t *testing.T, fake *fakeFFmpeg, dir string) (string, Options) {
+			fake.failOn("-c:v", "av_interleaved_write_frame(): No space left on device\n")
+			opts := testOptions()
+			opts.Method = "direct"
+			return animatedInput(t, dir), opts
+		}, ErrNoSpace},
+		// -v shows ffmpeg's output as it runs, but still reads it
+		{"disk full, verbose", func(t *testing.T, fake *fakeFFmpeg, dir string) (string, Options) {
+			fake.failOn("-c:v", "av_interleaved_write_frame(): No space left on device\n")
+			opts := testOptions()
+			opts.Method = "direct"
+			opts.Quiet, opts.Verbose = false, true
+			return animatedInput(t, dir), opts
+		}, ErrNoSpace},
+		{"read-only, verbose", func(t *testing.T, fake *fakeFFmpeg, dir string) (string, Options) {
+			fake.failOn("-c:v", "out.mp4: Read-only file system\n")
+			opts := testOptions()
+			opts.Method = "direct"
+			opts.Quiet, opts.Verbose = false, true
+			return animatedInput(t, dir), opts
+		}, ErrNotWritable},
+		{"unreadable, verbose", func(t *testing.T, fake *fakeFFmpeg, dir string) (string, Options) {
+			fake.failOn("-c:v", "[webp_pipe @ 0x1] Invalid data found when processing input\n")
+			opts := testOptions()
+			opts.Method = "direct"
+			opts.Quiet, opts.Verbose = false, true
+			return animatedInput(t, dir), opts
+		}, ErrUnreadableInput},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeFFmpeg(t, 8, 8)
+			dir := t.TempDir()
+			input, opts := tt.setup(t, fake, dir)
+			_, err := convertWebPToMP4(input, filepath.Join(dir, "out.mp4"), opts)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDependenciesFFmpegMissing(t *testing.T) {
+	saved := ffmpegPath
+	ffmpegPath = filepath.Join(t.TempDir(), "ffmpeg")
+	defer func() { ffmpegPath = saved }()
+
+	if err := CheckDependencies(testOptions()); !errors.Is(err, ErrFFmpegMissing) {
+		t.Errorf("CheckDependencies without ffmpeg = %v, want ErrFFmpegMissing", err)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("%w: in.webp", ErrInputNotFound), exitInputNotFound},
+		{ErrFFmpegMissing, exitFFmpegMissing},
+		{fmt.Errorf("%w: libx264", ErrEncoderMissing), exitFFmpegMissing},
+		{fmt.Errorf("%w: exit status 1", ErrEncodeFailed), exitEncodeFailed},
+		{ErrNoFrames, exitEncodeFailed},
+		{fmt.Errorf("%w after 1s", ErrTimeout), exitEncodeFailed},
+		{fmt.Errorf("failed to extract frames: %w", &exec.ExitError{}), exitEncodeFailed},
+		{errors.New("invalid -fps"), exitFailure},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+// animatedInput writes a three-frame 8x8 animation with uneven frame times
+// into dir and returns its path.
+func animatedInput(t *testing.T, dir string) string {
+	t.Helper()
+	input := filepath.Join(dir, "anim.webp")
+	writeTestWebP(t, input, 8, 8, 100, 100, 200)
+	return input
+}
+
+func TestExitCodeConfigError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webp2mp4.conf")
+	if err := os.WriteFile(path, []byte("nosuchflag = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := applyConfig(flag.NewFlagSet("test", flag.ContinueOnError), path, true)
+	if err == nil {
+		t.Fatal("applyConfig accepted a bad config")
+	}
+	if got := exitCode(err); got != exitFailure {
+		t.Errorf("exitCode(%v) = %d, want exitFailure", err, got)
+	}
+}
+
+func TestVerboseDiskFullNotRetried(t *testing.T) {
+	fake := newFakeFFmpeg(t, 8, 8)
+	fake.failOn("-c:v", "av_interleaved_write_frame(): No space left on device\n")
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.Method = "direct"
+	opts.Quiet, opts.Verbose = false, true
+	opts.Retries = 2
+
+	_, err := convertWebPToMP4(animatedInput(t, dir), filepath.Join(dir, "out.mp4"), opts)
+	if !errors.Is(err, ErrNoSpace) {
+		t.Fatalf("error = %v, want ErrNoSpace", err)
+	}
+	if calls := fake.calls(); len(calls) != 1 {
+		t.Errorf("ffmpeg ran %d times, want once, a full disk isn't retried", len(calls))
+	}
+}
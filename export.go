@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// exportFrames writes every frame of input to dir as a numbered still for
+// -frames-out, named frame_ followed by the 1-based frame number padded to
+// digits, and returns how many were written. format is "png" or "jpg".
+// Frames from an earlier export are replaced only when overwriting.
+func exportFrames(input, dir, format string, digits int, overwrite bool) (int, error) {
+	if format != "png" && format != "jpg" {
+		return 0, fmt.Errorf("invalid -frames-format %q (use png or jpg)", format)
+	}
+	if digits < 1 {
+		return 0, fmt.Errorf("-frames-digits must be at least 1")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, fmt.Errorf("failed to create -frames-out directory: %w", err)
+	}
+	old, err := filepath.Glob(filepath.Join(globEscape(dir), "frame_*."+format))
+	if err != nil {
+		return 0, err
+	}
+	if len(old) > 0 {
+		if !overwrite {
+			return 0, fmt.Errorf("%s already contains exported frames (use -overwrite to replace them)", dir)
+		}
+		for _, frame := range old {
+			if err := os.Remove(frame); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	count := 0
+	err = decodeAnimation(input, func(index int, img image.Image) error {
+		name := fmt.Sprintf("frame_%0*d.%s", digits, index+1, format)
+		if err := writeStill(filepath.Join(dir, name), img); err != nil {
+			return fmt.Errorf("failed to write frame %d: %w", index, err)
+		}
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// globEscape escapes the glob metacharacters in path so it matches itself.
+func globEscape(path string) string {
+	return strings.NewReplacer("*", `\*`, "?", `\?`, "[", `\[`, `\`, `\\`).Replace(path)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ffmpegPath is the ffmpeg executable to run, looked up in PATH unless it
+// contains a path separator. It is set by -ffmpeg or $WEBP2MP4_FFMPEG.
+var ffmpegPath = "ffmpeg"
+
+// ffprobePath returns the ffprobe executable that ships with ffmpegPath:
+// the one next to it when -ffmpeg names a path, ffprobe from PATH otherwise.
+func ffprobePath() string {
+	if dir, name := filepath.Split(ffmpegPath); dir != "" {
+		return filepath.Join(dir, strings.Replace(name, "ffmpeg", "ffprobe", 1))
+	}
+	return "ffprobe"
+}
+
+// ffprobeDimensions asks ffprobe for the size of the first video stream in
+// input, for files that image.DecodeConfig can't read.
+func ffprobeDimensions(input string) (int, int, error) {
+	out, err := exec.Command(ffprobePath(), "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=width,height", "-of", "csv=p=0:s=x", ffmpegFile(input)).Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("ffprobe failed: %w", err)
+	}
+	var width, height int
+	if _, err := fmt.Sscanf(strings.TrimSpace(string(out)), "%dx%d", &width, &height); err != nil || width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("ffprobe reported no dimensions for %s", input)
+	}
+	return width, height, nil
+}
+
+// ffprobeFrames counts the video packets in path, one per frame, without
+// decoding them.
+func ffprobeFrames(path string) (int, error) {
+	out, err := exec.Command(ffprobePath(), "-v", "error", "-select_streams", "v:0", "-count_packets",
+		"-show_entries", "stream=nb_read_packets", "-of", "csv=p=0", ffmpegFile(path)).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe failed: %w", err)
+	}
+	frames, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe reported no frame count for %s", path)
+	}
+	return frames, nil
+}
+
+// ffmpegFile returns path as ffmpeg should be given it. Paths that ffmpeg
+// would take for an option or a protocol, such as "-clip.webp" or
+// "a:b.webp", get a file: prefix so they are opened as plain files.
+func ffmpegFile(path string) string {
+	if strings.HasPrefix(path, "-") || strings.Contains(path, ":") {
+		return "file:" + path
+	}
+	return path
+}
+
+// extraInputs returns the -i arguments for the watermark and audio inputs.
+// They come before the video so that options following the video's -i still
+// apply to the output.
+func extraInputs(opts Options) []string {
+	var args []string
+	if opts.Watermark != "" {
+		args = append(args, "-i", ffmpegFile(opts.Watermark))
+	}
+	if opts.Audio != "" {
+		if opts.AudioFit == "loop" {
+			args = append(args, "-stream_loop", "-1")
+		}
+		args = append(args, "-i", ffmpegFile(opts.Audio))
+	}
+	return args
+}
+
+// videoInput returns the index of the video among ffmpeg's inputs.
+func videoInput(opts Options) int {
+	n := 0
+	if opts.Watermark != "" {
+		n++
+	}
+	if opts.Audio != "" {
+		n++
+	}
+	return n
+}
+
+// runEncode encodes the video read by inputArgs into output using the
+// encoder options in encArgs. With two-pass encoding an analysis pass writes
+// its stats to a temp directory that is removed afterwards, even on failure.
+func runEncode(inputArgs, encArgs []string, output string, opts Options, totalFrames int) error {
+	args := extraInputs(opts)
+	args = append(append(args, inputArgs...), encArgs...)
+	if opts.LoopDuration > 0 {
+		// The last repeat is cut short to hit the length exactly
+		args = append(args, "-t", ffmpegSeconds(opts.LoopDuration))
+	}
+	if !opts.TwoPass {
+		args = append(args, outputArgs(output, opts)...)
+		slog.Debug("running command", "cmd", commandLine(ffmpegPath, args))
+		return runFFmpeg(args, opts, totalFrames)
+	}
+
+	logDir, err := os.MkdirTemp(opts.TempDir, "webp2mp4_pass_*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(logDir)
+	passlog := filepath.Join(logDir, "ffmpeg2pass")
+
+	first := append(args[:len(args):len(args)], "-pass", "1", "-passlogfile", passlog, "-an", "-f", "null", "-y", os.DevNull)
+	slog.Debug("running first pass", "cmd", commandLine(ffmpegPath, first))
+	if err := runFFmpeg(first, opts, totalFrames); err != nil {
+		return fmt.Errorf("first pass: %w", err)
+	}
+
+	second := append(args[:len(args):len(args)], "-pass", "2", "-passlogfile", passlog)
+	second = append(second, outputArgs(output, opts)...)
+	slog.Debug("running second pass", "cmd", commandLine(ffmpegPath, second))
+	return runFFmpeg(second, opts, totalFrames)
+}
+
+// runFFmpeg executes ffmpeg with args. In verbose mode its output is
+// streamed to the terminal, otherwise it is captured and its last
+// -error-lines lines are included in any error. When progress is enabled,
+// totalFrames is used to report a completion percentage. If the last
+// argument is pipe:1 the encoded video is written to streamOut. With
+// -dry-run the command is only printed.
+func runFFmpeg(args []string, opts Options, totalFrames int) error {
+	args = append(append([]string{}, opts.GlobalArgs...), args...)
+	if opts.DryRun {
+		printCommand(ffmpegPath, args)
+		return nil
+	}
+
+	streaming := len(args) > 0 && args[len(args)-1] == "pipe:1"
+
+	// Progress is reported on stdout, which is taken when streaming
+	showProgress := opts.Progress && !opts.Verbose && !streaming
+	if showProgress {
+		args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	}
+
+	cmd, cancel := deadlineCommand(opts, ffmpegPath, args...)
+	defer cancel()
+	if opts.stdin != nil {
+		cmd.Stdin = opts.stdin
+	}
+
+	if opts.Verbose {
+		cmd.Stdout = os.Stderr
+		if streaming {
+			cmd.Stdout = streamOut
+		}
+		// Kept as well to recognize the error, see classifyFailure
+		var output bytes.Buffer
+		cmd.Stderr = io.MultiWriter(os.Stderr, &output)
+		err := checkDeadline(runCommand(cmd, opts), opts)
+		if err != nil && !errors.Is(err, ErrTimeout) {
+			// The output was already shown, so it isn't repeated in err
+			return classifyFailure(err, output.String())
+		}
+		return err
+	}
+
+	var output bytes.Buffer
+	cmd.Stderr = &output
+
+	if !showProgress {
+		cmd.Stdout = &output
+		if streaming {
+			cmd.Stdout = streamOut
+		}
+		if err := runCommand(cmd, opts); err != nil {
+			if err := checkDeadline(err, opts); errors.Is(err, ErrTimeout) {
+				return err
+			}
+			return ffmpegFailure(err, output.String(), opts)
+		}
+		return nil
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := startCommand(cmd, opts); err != nil {
+		return err
+	}
+
+	reporter := newProgressReporter(os.Stderr, totalFrames)
+	reporter.consume(stdout)
+
+	if err := cmd.Wait(); err != nil {
+		reporter.abort()
+		if err := checkDeadline(err, opts); errors.Is(err, ErrTimeout) {
+			return err
+		}
+		return ffmpegFailure(err, output.String(), opts)
+	}
+	return nil
+}
+
+// ffmpegFailure adds the end of ffmpeg's output to err, the error it exited
+// with, and classifies it with classifyFailure.
+func ffmpegFailure(err error, output string, opts Options) error {
+	return classifyFailure(fmt.Errorf("%w\nOutput: %s", err, tailLines(output, opts.ErrorLines)), output)
+}
+
+// classifyFailure marks err, from an ffmpeg run that printed output, with
+// what went wrong. Running out of space or write permission is picked out
+// of the output, as ffmpeg exits the same way for those as for a broken
+// encode, and so is an input it couldn't decode.
+func classifyFailure(err error, output string) error {
+	switch {
+	case strings.Contains(output, "No space left on device"):
+		return fmt.Errorf("%w: %w", ErrNoSpace, err)
+	case strings.Contains(output, "Read-only file system"), strings.Contains(output, "Permission denied"):
+		return fmt.Errorf("%w: %w", ErrNotWritable, err)
+	}
+	return unreadableInput(err, output)
+}
+
+// unreadableSignatures are the messages ffmpeg fails with when it can't
+// decode its input, as its webp_pipe demuxer does for many animations,
+// matched ignoring case. Any other failure, such as a bad encoder option,
+// would fail the same way after extracting the frames.
+var unreadableSignatures = []string{
+	"invalid data found when processing input",
+	"could not find codec parameters",
+	"image data not found",
+	"error while decoding stream",
+	"decoding error",
+	"error submitting packet to decoder",
+	"does not contain any stream",
+	"output file is empty, nothing was encoded",
+}
+
+// unreadableInput marks err with ErrUnreadableInput if ffmpeg's output has
+// one of the unreadableSignatures.
+func unreadableInput(err error, output string) error {
+	output = strings.ToLower(output)
+	for _, signature := range unreadableSignatures {
+		if strings.Contains(output, signature) {
+			return fmt.Errorf("%w: %w", ErrUnreadableInput, err)
+		}
+	}
+	return err
+}
+
+// tailLines returns the last n lines of ffmpeg's output, where its reason
+// for failing is, noting how many were left out. n <= 0 keeps them all.
+func tailLines(output string, n int) string {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if n <= 0 || len(lines) <= n {
+		return strings.Join(lines, "\n")
+	}
+	return fmt.Sprintf("[%d earlier lines omitted, use -v for the full output]\n%s", len(lines)-n, strings.Join(lines[len(lines)-n:], "\n"))
+}
+
+// deadlineCommand returns a command that is killed once the -timeout for
+// the current file runs out. cancel must be called when it has finished.
+func deadlineCommand(opts Options, name string, args ...string) (cmd *exec.Cmd, cancel context.CancelFunc) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if !opts.deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, opts.deadline)
+	}
+	cmd = exec.CommandContext(ctx, name, args...)
+	// Don't wait on pipes held open by anything the command started
+	cmd.WaitDelay = time.Second
+	return cmd, cancel
+}
+
+// priorityWarning is printed the first time -nice can't be applied, not
+// once per command.
+var priorityWarning sync.Once
+
+// startCommand starts cmd, lowering its priority to -nice when given. The
+// priority can only be set once the process exists, a moment after it
+// started, which is nothing next to an encode.
+func startCommand(cmd *exec.Cmd, opts Options) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if opts.Nice != 0 {
+		if err := setPriority(cmd.Process.Pid, opts.Nice); err != nil {
+			// Only root may raise the priority, that is no reason to stop
+			priorityWarning.Do(func() {
+				slog.Warn("failed to set the priority for -nice", "nice", opts.Nice, "err", err)
+			})
+		}
+	}
+	return nil
+}
+
+// runCommand is cmd.Run, started with startCommand.
+func runCommand(cmd *exec.Cmd, opts Options) error {
+	if err := startCommand(cmd, opts); err != nil {
+		return err
+	}
+	return cmd.Wait()
+}
+
+// combinedOutput is cmd.CombinedOutput, started with startCommand.
+func combinedOutput(cmd *exec.Cmd, opts Options) ([]byte, error) {
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := runCommand(cmd, opts)
+	return out.Bytes(), err
+}
+
+// checkDeadline replaces the error of a command started by deadlineCommand
+// with ErrTimeout if it was killed for running past the deadline.
+func checkDeadline(err error, opts Options) error {
+	if err != nil && !opts.deadline.IsZero() && !time.Now().Before(opts.deadline) {
+		return fmt.Errorf("%w after %s", ErrTimeout, opts.Timeout)
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+)
+
+// webpPipeFailure is how ffmpeg 6's webp_pipe demuxer fails on an
+// animation it can't decode.
+const webpPipeFailure = `[webp_pipe @ 0x55d5c8a4f340] Could not find codec parameters for stream 0 (Video: webp, none): unspecified size
+Consider increasing the value for the 'analyzeduration' (0) and 'probesize' (5000000) options
+Input #0, webp_pipe, from 'anim.webp':
+  Duration: N/A, bitrate: N/A
+  Stream #0:0: Video: webp, none, 25 fps, 25 tbr, 25 tbn
+Stream mapping:
+  Stream #0:0 -> #0:0 (webp (native) -> h264 (libx264))
+[webp @ 0x55d5c8a51a00] image data not found
+[vist#0:0/webp @ 0x55d5c8a4ff00] Decoding error: Invalid data found when processing input
+Conversion failed!
+`
+
+// badOptionFailure is how ffmpeg 6 fails on an encoder option libx264
+// doesn't accept, which extracting the frames first won't change.
+const badOptionFailure = `Input #0, webp_pipe, from 'anim.webp':
+  Stream #0:0: Video: webp, yuv420p, 8x8, 25 fps, 25 tbr, 25 tbn
+[libx264 @ 0x5581e1a7c9c0] Error setting option profile to value high444.
+[vost#0:0/libx264 @ 0x5581e1a7b4c0] Error while opening encoder - maybe incorrect parameters such as bit_rate, rate, width or height
+Conversion failed!
+`
+
+func TestFFmpegFailureUnreadable(t *testing.T) {
+	// A real exit status, as ffmpeg's failures come with
+	exitErr := exec.Command("sh", "-c", "exit 1").Run()
+	if _, ok := exitErr.(*exec.ExitError); !ok {
+		t.Skipf("no exit status from sh: %v", exitErr)
+	}
+	// The verbose path marks the error without adding the output to it
+	if err := unreadableInput(exitErr, "Conversion failed!\n"); err != exitErr {
+		t.Errorf("unreadableInput without a signature = %v, want the error unchanged", err)
+	}
+	tests := []struct {
+		name       string
+		output     string
+		unreadable bool
+		// sentinel is the other error the failure should be marked with
+		sentinel error
+	}{
+		{"webp_pipe failure", webpPipeFailure, true, nil},
+		{"upper case", "INVALID DATA FOUND WHEN PROCESSING INPUT\n", true, nil},
+		{"empty output", "Output file is empty, nothing was encoded\n", true, nil},
+		{"no streams", "Output file #0 does not contain any stream\n", true, nil},
+		{"bad encoder option", badOptionFailure, false, nil},
+		{"unknown option", "Unrecognized option 'foo'.\nError splitting the argument list: Option not found\n", false, nil},
+		{"disk full", "av_interleaved_write_frame(): No space left on device\n", false, ErrNoSpace},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ffmpegFailure(exitErr, tt.output, testOptions())
+			if got := errors.Is(err, ErrUnreadableInput); got != tt.unreadable {
+				t.Errorf("marked unreadable = %v, want %v", got, tt.unreadable)
+			}
+			if tt.sentinel != nil && !errors.Is(err, tt.sentinel) {
+				t.Errorf("error = %v, want %v", err, tt.sentinel)
+			}
+			// Extraction is only worth trying when ffmpeg couldn't decode
+			if got := extractionMayHelp(fmt.Errorf("%w: %w", ErrEncodeFailed, err)); got != tt.unreadable {
+				t.Errorf("extractionMayHelp = %v, want %v", got, tt.unreadable)
+			}
+			var exit *exec.ExitError
+			if !errors.As(err, &exit) {
+				t.Error("the exit status was lost")
+			}
+		})
+	}
+}
+
+func TestExtractionMayHelpWithoutFFmpeg(t *testing.T) {
+	// The direct method refused before running ffmpeg
+	err := fmt.Errorf("%w: the direct method can't apply -icc", ErrEncodeFailed)
+	if !extractionMayHelp(err) {
+		t.Errorf("extractionMayHelp(%v) = false, want true", err)
+	}
+}
+
+func TestFFmpegFile(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"clip.webp", "clip.webp"},
+		{"/tmp/my clip.webp", "/tmp/my clip.webp"},
+		{"-clip.webp", "file:-clip.webp"},
+		{"a:b.webp", "file:a:b.webp"},
+		{"http://example.com/a.webp", "file:http://example.com/a.webp"},
+	}
+	for _, tt := range tests {
+		if got := ffmpegFile(tt.in); got != tt.want {
+			t.Errorf("ffmpegFile(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
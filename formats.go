@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// runListFormats prints the containers and H.264 encoders this tool can
+// drive, marking whether the installed ffmpeg supports each, and reports
+// whether at least one combination is usable.
+func runListFormats(w io.Writer) bool {
+	caps, err := ffmpegCapabilities()
+	if err != nil {
+		fmt.Fprintf(w, "FAIL: %v\n", err)
+		return false
+	}
+
+	names := make([]string, 0, len(containers))
+	for name, c := range containers {
+		if c.H264 || c.VP9 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	anyContainer := false
+	fmt.Fprintln(w, "Containers (-container or -o extension), with the codec they default to:")
+	for _, name := range names {
+		ok := caps.hasMuxer(containers[name].Muxer)
+		anyContainer = anyContainer || ok
+		fmt.Fprintf(w, "  %-5s %-9s %-5s %s\n", name, containers[name].Muxer, containers[name].Codec, yesNo(ok))
+	}
+
+	hwNames := make([]string, 0, len(hwEncoders))
+	for name := range hwEncoders {
+		hwNames = append(hwNames, name)
+	}
+	sort.Strings(hwNames)
+
+	anyEncoder := caps.hasEncoder(softwareEncoder.Name)
+	fmt.Fprintln(w, "\nVideo encoders:")
+	fmt.Fprintf(w, "  %-17s %-3s (default)\n", softwareEncoder.Name, yesNo(anyEncoder))
+	for _, enc := range fallbackEncoders {
+		ok := caps.hasEncoder(enc.Name)
+		anyEncoder = anyEncoder || ok
+		fmt.Fprintf(w, "  %-17s %-3s (without libx264)\n", enc.Name, yesNo(ok))
+	}
+	for _, name := range hwNames {
+		ok := caps.hasEncoder(hwEncoders[name].Name)
+		anyEncoder = anyEncoder || ok
+		fmt.Fprintf(w, "  %-17s %-3s (-hwaccel %s)\n", hwEncoders[name].Name, yesNo(ok), name)
+	}
+
+	fmt.Fprintf(w, "  %-17s %-3s (-codec hevc)\n", hevcEncoder.Name, yesNo(caps.hasEncoder(hevcEncoder.Name)))
+	fmt.Fprintf(w, "  %-17s %-3s (-codec vp9)\n", vp9Encoder.Name, yesNo(caps.hasEncoder(vp9Encoder.Name)))
+	for _, name := range hwNames {
+		if enc, ok := hevcHWEncoders[name]; ok {
+			fmt.Fprintf(w, "  %-17s %-3s (-codec hevc -hwaccel %s)\n", enc.Name, yesNo(caps.hasEncoder(enc.Name)), name)
+		}
+	}
+
+	fmt.Fprintln(w, "\nOther formats:")
+	fmt.Fprintf(w, "  %-17s %-3s (-format apng)\n", "apng", yesNo(caps.hasMuxer(apngContainer.Muxer) && caps.hasEncoder(apngEncoder.Name)))
+	fmt.Fprintf(w, "  %-17s %-3s (-format webp)\n", "webp", yesNo(caps.hasMuxer(webpContainer.Muxer) && caps.hasEncoder(webpEncoder.Name)))
+	_, alphaErr := selectHEVCAlphaEncoder()
+	fmt.Fprintf(w, "  %-17s %-3s (-format hevc-alpha)\n", "hevc-alpha", yesNo(caps.hasMuxer(containers["mov"].Muxer) && alphaErr == nil))
+
+	return anyContainer && anyEncoder
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/webp"
+)
+
+// decodeAnimation decodes every frame of an animated WebP in pure Go and
+// calls fn with the composited canvas for each one. golang.org/x/image/webp
+// only understands single-image files, so each ANMF payload is rewrapped in
+// its own RIFF container before decoding. The image passed to fn is reused
+// between calls and must not be retained.
+func decodeAnimation(input string, fn func(index int, img image.Image) error) error {
+	chunks, err := readWebPChunks(input)
+	if err != nil {
+		return err
+	}
+
+	info, err := inspectWebP(input)
+	if err != nil {
+		return err
+	}
+
+	canvas := newCompositor(info.Width, info.Height)
+	index := 0
+	for _, chunk := range chunks {
+		if chunk.ID != "ANMF" {
+			continue
+		}
+		if len(chunk.Data) < 16 {
+			return fmt.Errorf("invalid ANMF chunk for frame %d", index)
+		}
+
+		placement := parseANMF(chunk.Data)
+		frame, err := decodeFramePayload(chunk.Data[16:], placement.Rect.Dx(), placement.Rect.Dy())
+		if err != nil {
+			return fmt.Errorf("failed to decode frame %d: %w", index, err)
+		}
+
+		if err := fn(index, canvas.draw(placement, frame)); err != nil {
+			return err
+		}
+		index++
+	}
+
+	if index == 0 {
+		// Not animated, decode the file as a single image
+		file, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		img, err := webp.Decode(file)
+		if err != nil {
+			return err
+		}
+		return fn(0, img)
+	}
+
+	return nil
+}
+
+// animFrame is where an ANMF frame goes on the canvas and how.
+type animFrame struct {
+	Rect image.Rectangle
+	// Blend alpha-blends the frame over the canvas, otherwise it replaces
+	// what is under it, transparent pixels included
+	Blend bool
+	// Dispose clears the frame's rectangle to transparent once it has been
+	// shown, before the next frame is drawn
+	Dispose bool
+}
+
+// parseANMF reads the placement from the 16-byte header of an ANMF chunk.
+func parseANMF(d []byte) animFrame {
+	x, y := le24(d[0:3])*2, le24(d[3:6])*2
+	return animFrame{
+		Rect:    image.Rect(x, y, x+le24(d[6:9])+1, y+le24(d[9:12])+1),
+		Blend:   d[15]&0x02 == 0,
+		Dispose: d[15]&0x01 != 0,
+	}
+}
+
+// compositor keeps the canvas of an animation as its frames are drawn.
+// Optimized files store only what changed in each frame, relying on the
+// blending and disposal of the ones before, so frames can't be taken on
+// their own. The background colour in ANIM is only a hint, and like
+// browsers and libwebp the canvas is cleared to transparent instead.
+type compositor struct {
+	canvas  *image.NRGBA
+	dispose image.Rectangle
+}
+
+func newCompositor(width, height int) *compositor {
+	return &compositor{canvas: image.NewNRGBA(image.Rect(0, 0, width, height))}
+}
+
+// draw applies the disposal of the previous frame, then draws img as f
+// says and returns the canvas, which is reused by the next call.
+func (c *compositor) draw(f animFrame, img image.Image) *image.NRGBA {
+	if !c.dispose.Empty() {
+		draw.Draw(c.canvas, c.dispose, image.Transparent, image.Point{}, draw.Src)
+	}
+	op := draw.Src
+	if f.Blend {
+		op = draw.Over
+	}
+	draw.Draw(c.canvas, f.Rect, img, img.Bounds().Min, op)
+	c.dispose = image.Rectangle{}
+	if f.Dispose {
+		c.dispose = f.Rect
+	}
+	return c.canvas
+}
+
+// decodeFramePayload decodes the image data of an ANMF chunk, which holds an
+// optional ALPH chunk followed by a VP8 or VP8L chunk.
+func decodeFramePayload(payload []byte, width, height int) (image.Image, error) {
+	var alph, bitstream []byte
+	for pos := 0; pos+8 <= len(payload); {
+		id := string(payload[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(payload[pos+4 : pos+8]))
+		end := pos + 8 + size
+		if end > len(payload) {
+			return nil, fmt.Errorf("truncated %s chunk", id)
+		}
+		switch id {
+		case "ALPH":
+			alph = payload[pos:end]
+		case "VP8 ", "VP8L":
+			bitstream = payload[pos:end]
+		}
+		pos = end + size&1
+	}
+	if bitstream == nil {
+		return nil, fmt.Errorf("frame has no image data")
+	}
+
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	if alph != nil {
+		// Lossy frames with alpha need a VP8X header announcing it
+		vp8x := make([]byte, 18)
+		copy(vp8x, "VP8X")
+		binary.LittleEndian.PutUint32(vp8x[4:], 10)
+		vp8x[8] = 1 << 4
+		putLE24(vp8x[12:], width-1)
+		putLE24(vp8x[15:], height-1)
+		body.Write(vp8x)
+		body.Write(alph)
+		if len(alph)%2 != 0 {
+			body.WriteByte(0)
+		}
+	}
+	body.Write(bitstream)
+	if len(bitstream)%2 != 0 {
+		body.WriteByte(0)
+	}
+
+	var riff bytes.Buffer
+	riff.WriteString("RIFF")
+	binary.Write(&riff, binary.LittleEndian, uint32(body.Len()))
+	riff.Write(body.Bytes())
+
+	return webp.Decode(&riff)
+}
+
+// extractFramesGo decodes every frame in pure Go and writes them named
+// after frameName into dir, starting at 1 like ffmpeg's image2 muxer. Each
+// frame is passed through the FrameFunc first when it is set.
+func extractFramesGo(input, dir string, opts Options) error {
+	return decodeAnimation(input, func(index int, img image.Image) error {
+		img, err := applyFrameFunc(opts.FrameFunc, index, img)
+		if err != nil {
+			return err
+		}
+		return writeFrame(filepath.Join(dir, fmt.Sprintf(frameName(opts), index+1)), img, opts.FrameQuality)
+	})
+}
+
+// FrameFunc transforms a decoded frame before it is encoded. index counts
+// from 0. img is reused for the next frame, so it must be copied to be kept,
+// and every returned image must have the same size. An error aborts the
+// conversion.
+type FrameFunc func(index int, img image.Image) (image.Image, error)
+
+// applyFrameFunc runs frameFunc on frame index, if it is set.
+func applyFrameFunc(frameFunc FrameFunc, index int, img image.Image) (image.Image, error) {
+	if frameFunc == nil {
+		return img, nil
+	}
+	out, err := frameFunc(index, img)
+	if err != nil {
+		return nil, fmt.Errorf("frame %d: %w", index, err)
+	}
+	return out, nil
+}
+
+// putLE24 encodes v as a 24-bit little-endian unsigned integer.
+func putLE24(b []byte, v int) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
+
+// frameName returns the printf pattern extracted frames are named with,
+// ending in the -frame-format extension. Six digits keep the names the same
+// length, and so in order, for any realistic animation.
+func frameName(opts Options) string {
+	return "frame_%06d." + opts.frameExt()
+}
+
+// frameExt returns the extension of the extracted frames, png unless
+// -frame-format jpg asks for smaller, lossy ones.
+func (o Options) frameExt() string {
+	if o.FrameFormat == "jpg" {
+		return "jpg"
+	}
+	return "png"
+}
+
+// jpegQScale maps -frame-quality, from 1 to 100, onto the 2 (best) to 31
+// -q:v scale of ffmpeg's mjpeg encoder.
+func jpegQScale(quality int) int {
+	return 2 + (100-quality)*29/99
+}
+
+// framePattern returns the frameName pattern that frames are extracted
+// to in dir. A % in dir itself is escaped so ffmpeg and ImageMagick don't
+// read it as part of the pattern.
+func framePattern(dir string, opts Options) string {
+	return filepath.Join(strings.ReplaceAll(dir, "%", "%%"), frameName(opts))
+}
+
+// listFrames returns the extracted frames in dir in frame order. Unlike a
+// glob, it is unaffected by metacharacters in dir, and frames past the
+// width of frameName still sort after the others.
+func listFrames(dir string) ([]string, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range dirEntries {
+		if name := e.Name(); strings.HasPrefix(name, "frame_") && (strings.HasSuffix(name, ".png") || strings.HasSuffix(name, ".jpg")) {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) < len(names[j])
+		}
+		return names[i] < names[j]
+	})
+	frames := make([]string, len(names))
+	for i, name := range names {
+		frames[i] = filepath.Join(dir, name)
+	}
+	return frames, nil
+}
+
+// firstFrameNumber returns the number in the name of the first of frames,
+// which ffmpeg's image2 input must be given as -start_number. Extraction
+// numbers frames from 1 with ffmpeg but from 0 with ImageMagick, and that
+// isn't consistent across versions either.
+func firstFrameNumber(frames []string) int {
+	if len(frames) == 0 {
+		return 1
+	}
+	name := filepath.Base(frames[0])
+	digits := strings.TrimSuffix(strings.TrimPrefix(name, "frame_"), filepath.Ext(name))
+	n, err := strconv.Atoi(digits)
+	if err != nil {
+		return 1
+	}
+	return n
+}
+
+// prepareFrameDir creates the -keep-frames directory. Frames left there by an
+// earlier run would be mixed into the video, so they are removed when
+// overwriting and refused otherwise.
+func prepareFrameDir(dir string, overwrite bool) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create -keep-frames directory: %w", err)
+	}
+	old, err := listFrames(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read -keep-frames directory: %w", err)
+	}
+	if len(old) == 0 {
+		return nil
+	}
+	if !overwrite {
+		return fmt.Errorf("%s already contains extracted frames (use -overwrite to replace them)", dir)
+	}
+	for _, frame := range old {
+		if err := os.Remove(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFramePattern(t *testing.T) {
+	opts := testOptions()
+	dir := filepath.Join("tmp", "100% done")
+	if got, want := framePattern(dir, opts), filepath.Join("tmp", "100%% done", "frame_%06d.png"); got != want {
+		t.Errorf("framePattern(%q) = %q, want %q", dir, got, want)
+	}
+	opts.FrameFormat = "jpg"
+	if got, want := framePattern("frames", opts), filepath.Join("frames", "frame_%06d.jpg"); got != want {
+		t.Errorf("framePattern with -frame-format jpg = %q, want %q", got, want)
+	}
+}
+
+func TestListFramesOrder(t *testing.T) {
+	dir := t.TempDir()
+	// Past the six digits of the pattern, names grow longer rather than
+	// colliding, and still have to sort by number
+	names := []string{"frame_1000000.png", "frame_000010.png", "frame_999999.png", "frame_000002.png", "notes.txt", "frame_000001.jpg"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	frames, err := listFrames(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"frame_000001.jpg", "frame_000002.png", "frame_000010.png", "frame_999999.png", "frame_1000000.png"}
+	for i := range want {
+		want[i] = filepath.Join(dir, want[i])
+	}
+	assertArgs(t, frames, want)
+}
+
+func TestFirstFrameNumber(t *testing.T) {
+	tests := []struct {
+		frames []string
+		want   int
+	}{
+		{[]string{"/tmp/x/frame_000000.png", "/tmp/x/frame_000001.png"}, 0},
+		{[]string{"/tmp/x/frame_000001.png"}, 1},
+		{[]string{"frame_000007.jpg"}, 7},
+		{[]string{"frame_1000000.png"}, 1000000},
+		{[]string{"frame_x.png"}, 1},
+		{nil, 1},
+	}
+	for _, tt := range tests {
+		if got := firstFrameNumber(tt.frames); got != tt.want {
+			t.Errorf("firstFrameNumber(%q) = %d, want %d", tt.frames, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeAnimationCompositing(t *testing.T) {
+	var (
+		red         = color.NRGBA{R: 0xff, A: 0xff}
+		green       = color.NRGBA{G: 0xff, A: 0xff}
+		clearBlue   = color.NRGBA{B: 0xff, A: 0x80}
+		transparent = color.NRGBA{}
+		// clearBlue over red
+		purple = color.NRGBA{R: 0x7f, B: 0x80, A: 0xff}
+	)
+	input := filepath.Join(t.TempDir(), "optimized.webp")
+	// Every frame after the first only stores the corner it changes
+	writeTestFrames(t, input, 4, 4,
+		testFrame{Rect: image.Rect(0, 0, 4, 4), Color: red, Duration: 100},
+		testFrame{Rect: image.Rect(2, 2, 4, 4), Color: clearBlue, Duration: 100, Blend: true, Dispose: true},
+		testFrame{Rect: image.Rect(0, 0, 2, 2), Color: green, Duration: 100, Blend: true},
+		testFrame{Rect: image.Rect(0, 0, 2, 2), Color: clearBlue, Duration: 100},
+	)
+
+	// The top left and bottom right corners of each frame
+	want := [][2]color.NRGBA{
+		{red, red},
+		// Blended over the frame before
+		{red, purple},
+		// The blue corner was disposed of, back to transparent
+		{green, transparent},
+		// Not blended, so the corner is replaced alpha and all
+		{clearBlue, transparent},
+	}
+	frames := 0
+	err := decodeAnimation(input, func(index int, img image.Image) error {
+		frames++
+		canvas := img.(*image.NRGBA)
+		for i, p := range []image.Point{{0, 0}, {3, 3}} {
+			if got := canvas.NRGBAAt(p.X, p.Y); !closeColor(got, want[index][i]) {
+				t.Errorf("frame %d at %v = %v, want %v", index, p, got, want[index][i])
+			}
+		}
+		// The rest of the canvas is kept from frame to frame
+		if got := canvas.NRGBAAt(3, 0); got != red {
+			t.Errorf("frame %d at (3,0) = %v, want %v", index, got, red)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames != len(want) {
+		t.Errorf("decoded %d frames, want %d", frames, len(want))
+	}
+}
+
+// closeColor reports whether a and b differ by at most 1 in each channel,
+// as rounding in blending allows.
+func closeColor(a, b color.NRGBA) bool {
+	near := func(x, y uint8) bool { return int(x)-int(y) <= 1 && int(y)-int(x) <= 1 }
+	return near(a.R, b.R) && near(a.G, b.G) && near(a.B, b.B) && near(a.A, b.A)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"log/slog"
+	"math"
+	"strings"
+	"unicode/utf16"
+)
+
+// srgbToXYZ and xyzToSRGB convert between linear sRGB and the D50 XYZ that
+// ICC profiles use, adapted with Bradford as the profiles themselves are.
+var (
+	srgbToXYZ = [3][3]float64{
+		{0.4360747, 0.3850649, 0.1430804},
+		{0.2225045, 0.7168786, 0.0606169},
+		{0.0139322, 0.0971045, 0.7141733},
+	}
+	xyzToSRGB = [3][3]float64{
+		{3.1338561, -1.6168667, -0.4906146},
+		{-0.9787684, 1.9161415, 0.0334540},
+		{0.0719453, -0.2289914, 1.4052427},
+	}
+)
+
+// iccProfile is the part of an RGB matrix/TRC ICC profile -icc apply uses:
+// the curve that linearizes each channel and the matrix from linear RGB to
+// D50 XYZ.
+type iccProfile struct {
+	Description string
+	curves      [3]func(float64) float64
+	matrix      [3][3]float64
+}
+
+// readICCProfile returns the ICCP chunk of input, or nil if it has none.
+func readICCProfile(input string) ([]byte, error) {
+	chunks, err := readWebPChunks(input)
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range chunks {
+		if chunk.ID == "ICCP" {
+			return chunk.Data, nil
+		}
+	}
+	return nil, nil
+}
+
+// iccTags returns the tag table of an ICC profile by signature.
+func iccTags(data []byte) (map[string][]byte, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, fmt.Errorf("not an ICC profile")
+	}
+	count := int(binary.BigEndian.Uint32(data[128:132]))
+	if count > (len(data)-132)/12 {
+		return nil, fmt.Errorf("truncated ICC tag table")
+	}
+	tags := make(map[string][]byte, count)
+	for i := 0; i < count; i++ {
+		entry := data[132+12*i:]
+		offset, size := binary.BigEndian.Uint32(entry[4:8]), binary.BigEndian.Uint32(entry[8:12])
+		if uint64(offset)+uint64(size) > uint64(len(data)) {
+			return nil, fmt.Errorf("ICC tag %q is out of bounds", entry[0:4])
+		}
+		tags[string(entry[0:4])] = data[offset : offset+size]
+	}
+	return tags, nil
+}
+
+// profileDescription returns the name an ICC profile gives itself, from a
+// v2 desc or a v4 mluc tag, or "" if it has none.
+func profileDescription(data []byte) string {
+	tags, err := iccTags(data)
+	if err != nil {
+		return ""
+	}
+	desc := tags["desc"]
+	switch {
+	case len(desc) >= 12 && string(desc[0:4]) == "desc":
+		n := int(binary.BigEndian.Uint32(desc[8:12]))
+		if n > len(desc)-12 {
+			return ""
+		}
+		return strings.TrimRight(string(desc[12:12+n]), "\x00")
+	case len(desc) >= 28 && string(desc[0:4]) == "mluc":
+		// The first record will do, the names rarely differ
+		n, offset := binary.BigEndian.Uint32(desc[20:24]), binary.BigEndian.Uint32(desc[24:28])
+		if uint64(offset)+uint64(n) > uint64(len(desc)) {
+			return ""
+		}
+		units := make([]uint16, n/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(desc[int(offset)+2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	}
+	return ""
+}
+
+// parseICCProfile reads an RGB matrix/TRC profile, the kind cameras, image
+// editors and displays describe themselves with. Profiles made of lookup
+// tables, and grey or CMYK ones, are rejected.
+func parseICCProfile(data []byte) (*iccProfile, error) {
+	tags, err := iccTags(data)
+	if err != nil {
+		return nil, err
+	}
+	if space := string(data[16:20]); space != "RGB " {
+		return nil, fmt.Errorf("only RGB ICC profiles are supported, not %q", strings.TrimSpace(space))
+	}
+
+	p := &iccProfile{Description: profileDescription(data)}
+	for i, channel := range []string{"r", "g", "b"} {
+		xyz, ok := tags[channel+"XYZ"]
+		if !ok || len(xyz) < 20 || string(xyz[0:4]) != "XYZ " {
+			return nil, fmt.Errorf("only matrix/TRC ICC profiles are supported, this one has no %sXYZ tag", channel)
+		}
+		for row := 0; row < 3; row++ {
+			p.matrix[row][i] = s15Fixed16(xyz[8+4*row:])
+		}
+		if p.curves[i], err = parseCurve(tags[channel+"TRC"]); err != nil {
+			return nil, fmt.Errorf("%sTRC: %w", channel, err)
+		}
+	}
+	return p, nil
+}
+
+// parseCurve reads a curv or para tone curve as a function from encoded
+// to linear values, both from 0 to 1.
+func parseCurve(data []byte) (func(float64) float64, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("missing or truncated tone curve")
+	}
+	switch string(data[0:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(data[8:12]))
+		if len(data) < 12+2*n {
+			return nil, fmt.Errorf("truncated tone curve")
+		}
+		switch n {
+		case 0:
+			return func(x float64) float64 { return x }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(data[12:14])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(data[12+2*i:])) / 65535
+		}
+		return func(x float64) float64 {
+			pos := x * float64(n-1)
+			i := min(int(pos), n-2)
+			return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+		}, nil
+	case "para":
+		counts := []int{1, 3, 4, 5, 7}
+		kind := int(binary.BigEndian.Uint16(data[8:10]))
+		if kind >= len(counts) || len(data) < 12+4*counts[kind] {
+			return nil, fmt.Errorf("unsupported parametric curve type %d", kind)
+		}
+		// g, a, b, c, d, e, f as the ICC specification names them
+		var v [7]float64
+		v[1] = 1
+		for i := 0; i < counts[kind]; i++ {
+			v[i] = s15Fixed16(data[12+4*i:])
+		}
+		g, a, b, c, d, e, f := v[0], v[1], v[2], v[3], v[4], v[5], v[6]
+		switch kind {
+		case 1:
+			d = -b / a
+		case 2:
+			d, e, f = -b/a, c, c
+			c = 0
+		}
+		return func(x float64) float64 {
+			if kind == 0 {
+				return math.Pow(x, g)
+			}
+			if x < d {
+				return c*x + f
+			}
+			return math.Pow(max(a*x+b, 0), g) + e
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported tone curve type %q", data[0:4])
+}
+
+// s15Fixed16 decodes an ICC signed 15.16 fixed-point number.
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// isSRGB reports whether p has the sRGB primaries and tone curve, so
+// converting to sRGB would change nothing worth the time.
+func (p *iccProfile) isSRGB() bool {
+	for row := range p.matrix {
+		for col := range p.matrix[row] {
+			if math.Abs(p.matrix[row][col]-srgbToXYZ[row][col]) > 0.005 {
+				return false
+			}
+		}
+	}
+	for _, curve := range p.curves {
+		if math.Abs(curve(0.5)-srgbDecode(0.5)) > 0.005 {
+			return false
+		}
+	}
+	return true
+}
+
+// frameFunc returns a FrameFunc that converts frames from p to sRGB.
+// Colours outside the sRGB gamut are clipped. Each channel goes through a
+// lookup table on the way in and out, so only the matrix is per pixel.
+func (p *iccProfile) frameFunc() FrameFunc {
+	var decode [3][256]float64
+	for c, curve := range p.curves {
+		for v := range decode[c] {
+			decode[c][v] = curve(float64(v) / 255)
+		}
+	}
+	var m [3][3]float64
+	for row := range m {
+		for col := range m[row] {
+			for k := 0; k < 3; k++ {
+				m[row][col] += xyzToSRGB[row][k] * p.matrix[k][col]
+			}
+		}
+	}
+	const steps = 4096
+	var encode [steps + 1]uint8
+	for i := range encode {
+		encode[i] = uint8(math.Round(srgbEncode(float64(i)/steps) * 255))
+	}
+
+	return func(index int, img image.Image) (image.Image, error) {
+		out := cloneImage(img)
+		for i := 0; i+3 < len(out.Pix); i += 4 {
+			r, g, b := decode[0][out.Pix[i]], decode[1][out.Pix[i+1]], decode[2][out.Pix[i+2]]
+			for c := 0; c < 3; c++ {
+				v := m[c][0]*r + m[c][1]*g + m[c][2]*b
+				out.Pix[i+c] = encode[int(math.Round(min(max(v, 0), 1)*steps))]
+			}
+		}
+		return out, nil
+	}
+}
+
+// srgbDecode and srgbEncode are the sRGB transfer function and its inverse.
+func srgbDecode(x float64) float64 {
+	if x <= 0.04045 {
+		return x / 12.92
+	}
+	return math.Pow((x+0.055)/1.055, 2.4)
+}
+
+func srgbEncode(x float64) float64 {
+	if x <= 0.0031308 {
+		return x * 12.92
+	}
+	return 1.055*math.Pow(x, 1/2.4) - 0.055
+}
+
+// colorProfileFunc returns the FrameFunc for input's ICC profile under -icc,
+// chained before any FrameFunc already set: with apply, frames in a profile
+// other than sRGB are converted to sRGB. Otherwise the profile is dropped,
+// as ffmpeg does, with a warning unless -icc strip asked for that.
+func colorProfileFunc(input string, info WebPInfo, opts Options) (FrameFunc, error) {
+	if info.ICCProfile == 0 || opts.ICC == "strip" {
+		return opts.FrameFunc, nil
+	}
+	data, err := readICCProfile(input)
+	if err != nil || data == nil {
+		return opts.FrameFunc, err
+	}
+	profile, err := parseICCProfile(data)
+	if err != nil {
+		if opts.ICC == "apply" {
+			return nil, fmt.Errorf("can't apply the ICC profile of %s: %w (use -icc strip to convert without it)", input, err)
+		}
+		slog.Warn("input has an ICC profile that is ignored, colours may shift", "input", input, "profile", profileDescription(data), "err", err)
+		return opts.FrameFunc, nil
+	}
+	if profile.isSRGB() {
+		return opts.FrameFunc, nil
+	}
+	if opts.ICC != "apply" {
+		slog.Warn("input has an ICC profile that is ignored, colours may shift (use -icc apply to convert to sRGB, or -icc strip to silence this)", "input", input, "profile", profile.Description)
+		return opts.FrameFunc, nil
+	}
+
+	slog.Debug("converting frames to sRGB", "input", input, "profile", profile.Description)
+	convert, next := profile.frameFunc(), opts.FrameFunc
+	return func(index int, img image.Image) (image.Image, error) {
+		img, err := convert(index, img)
+		if err != nil || next == nil {
+			return img, err
+		}
+		return next(index, img)
+	}, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"sync"
+)
+
+// imageMagick caches which ImageMagick command is installed.
+var imageMagick struct {
+	once   sync.Once
+	bin    string
+	prefix []string
+	err    error
+}
+
+// imageMagickCmd returns the ImageMagick binary and the arguments that must
+// precede a convert-style command line. ImageMagick 7 installs a single
+// magick binary, which is preferred over the legacy convert.
+func imageMagickCmd() (string, []string, error) {
+	imageMagick.once.Do(func() {
+		if path, err := exec.LookPath("magick"); err == nil {
+			imageMagick.bin, imageMagick.prefix = path, []string{"convert"}
+			return
+		}
+		// Windows ships an unrelated convert.exe for converting filesystems
+		if runtime.GOOS != "windows" {
+			if path, err := exec.LookPath("convert"); err == nil {
+				imageMagick.bin = path
+				return
+			}
+		}
+		imageMagick.err = errors.New("ImageMagick (magick or convert) not found")
+	})
+	return imageMagick.bin, imageMagick.prefix, imageMagick.err
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// fileInfo is the metadata printed by -info.
+type fileInfo struct {
+	File        string  `json:"file"`
+	Size        int64   `json:"size_bytes"`
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	Frames      int     `json:"frames"`
+	Duration    float64 `json:"duration_seconds"`
+	Animated    bool    `json:"animated"`
+	LoopCount   int     `json:"loop_count"`
+	Alpha       bool    `json:"alpha"`
+	Compression string  `json:"compression"`
+	ICCProfile  int     `json:"icc_profile_bytes"`
+	// ICCDescription is the name the ICC profile gives itself
+	ICCDescription string `json:"icc_profile,omitempty"`
+	// Orientation is the EXIF orientation, 0 for none
+	Orientation int `json:"orientation"`
+}
+
+// describeWebP gathers the -info metadata for a file.
+func describeWebP(path string) (fileInfo, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return fileInfo{}, err
+	}
+	info, err := inspectCached(path)
+	if err != nil {
+		return fileInfo{}, err
+	}
+	description := ""
+	if info.ICCProfile > 0 {
+		profile, err := readICCProfile(path)
+		if err != nil {
+			return fileInfo{}, err
+		}
+		description = profileDescription(profile)
+	}
+	return fileInfo{
+		File:           path,
+		Size:           stat.Size(),
+		Width:          info.Width,
+		Height:         info.Height,
+		Frames:         info.Frames,
+		Duration:       info.Duration.Seconds(),
+		Animated:       info.Frames > 1,
+		LoopCount:      info.LoopCount,
+		Alpha:          info.Alpha,
+		Compression:    info.Compression,
+		ICCProfile:     info.ICCProfile,
+		ICCDescription: description,
+		Orientation:    info.Orientation,
+	}, nil
+}
+
+// runInfo prints the metadata of every input, as JSON when asJSON is set,
+// and reports whether all of them could be read. A single input is printed
+// as an object, several as an array.
+func runInfo(w io.Writer, inputs []string, asJSON bool) bool {
+	ok := true
+	var infos []fileInfo
+	for _, input := range inputs {
+		fi, err := describeWebP(input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", input, err)
+			ok = false
+			continue
+		}
+		infos = append(infos, fi)
+	}
+
+	if asJSON {
+		var v any = infos
+		if len(inputs) == 1 && len(infos) == 1 {
+			v = infos[0]
+		}
+		if err := writeJSON(w, v); err != nil {
+			return false
+		}
+		return ok
+	}
+
+	for i, fi := range infos {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, fi.File)
+		fmt.Fprintf(w, "  Size:       %d bytes\n", fi.Size)
+		fmt.Fprintf(w, "  Dimensions: %dx%d\n", fi.Width, fi.Height)
+		fmt.Fprintf(w, "  Frames:     %d\n", fi.Frames)
+		if fi.Animated {
+			fmt.Fprintf(w, "  Duration:   %.3fs\n", fi.Duration)
+			loop := "forever"
+			if fi.LoopCount > 0 {
+				loop = fmt.Sprintf("%d times", fi.LoopCount)
+			}
+			fmt.Fprintf(w, "  Plays:      %s\n", loop)
+		}
+		fmt.Fprintf(w, "  Alpha:      %s\n", yesNo(fi.Alpha))
+		fmt.Fprintf(w, "  Encoding:   %s\n", fi.Compression)
+		switch {
+		case fi.ICCProfile == 0:
+			fmt.Fprintln(w, "  ICC:        none")
+		case fi.ICCDescription != "":
+			fmt.Fprintf(w, "  ICC:        %s (%d bytes)\n", fi.ICCDescription, fi.ICCProfile)
+		default:
+			fmt.Fprintf(w, "  ICC:        %d bytes\n", fi.ICCProfile)
+		}
+		fmt.Fprintf(w, "  Orient:     %s\n", describeOrientation(fi.Orientation))
+	}
+	return ok
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// interpolateModes are the minterpolate mi_mode values accepted by
+// -interpolatemode.
+var interpolateModes = map[string]bool{"mci": true, "blend": true, "dup": true}
+
+// interpolateFilters returns a minterpolate filter that synthesizes frames up
+// to the -interpolate rate, or nothing when the source, played at -speed, is
+// already at least that smooth or its rate is unknown.
+func interpolateFilters(sourceFPS float64, opts Options) []string {
+	if opts.Interpolate <= 0 || sourceFPS <= 0 {
+		return nil
+	}
+	if rate := sourceFPS * opts.Speed; rate >= float64(opts.Interpolate) {
+		slog.Debug("skipping interpolation, source rate already meets the target", "source_fps", rate, "target_fps", opts.Interpolate)
+		return nil
+	}
+	return []string{fmt.Sprintf("minterpolate=fps=%d:mi_mode=%s", opts.Interpolate, opts.InterpolateMode)}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// inspectConcat inspects every -concat input and describes them as one
+// animation on a canvas large enough for the biggest of them. Still images
+// count as one frame shown for 1/fps, as in the encoded video.
+func inspectConcat(inputs []string, fps float64) (WebPInfo, error) {
+	var joined WebPInfo
+	for _, input := range inputs {
+		if _, err := os.Stat(input); os.IsNotExist(err) {
+			return joined, fmt.Errorf("%w: %s", ErrInputNotFound, input)
+		}
+		info, err := inspectCached(input)
+		if err != nil {
+			return joined, fmt.Errorf("invalid input %s: %w", input, err)
+		}
+		if joined.Frames > 0 && (info.Width != joined.Width || info.Height != joined.Height) {
+			slog.Debug("concat inputs differ in size, smaller ones are scaled to fit", "input", input, "width", info.Width, "height", info.Height)
+		}
+		joined.Width = max(joined.Width, info.Width)
+		joined.Height = max(joined.Height, info.Height)
+		joined.Alpha = joined.Alpha || info.Alpha
+		if info.Frames < 2 {
+			joined.Frames++
+			joined.Duration += time.Duration(float64(time.Second) / fps)
+			joined.FrameDurations = append(joined.FrameDurations, time.Duration(float64(time.Second)/fps))
+			continue
+		}
+		joined.Frames += info.Frames
+		joined.Duration += info.Duration
+		joined.FrameDurations = append(joined.FrameDurations, info.FrameDurations...)
+	}
+	return joined, nil
+}
+
+// convertConcat joins the -concat inputs into output. Their frames are
+// decoded in Go, in input order, and each one is scaled to fit the shared
+// canvas described by info and centred on it.
+func convertConcat(output string, info WebPInfo, trim trimRange, opts Options, result *Result) (err error) {
+	tempDir, cleanup, err := frameDir(opts)
+	if err != nil {
+		return err
+	}
+	defer func() { cleanup(err != nil) }()
+
+	var frames []string
+	if opts.DryRun {
+		fmt.Printf("# frames of %d inputs are decoded in Go into %s\n", len(opts.Concat), tempDir)
+		for i := 1; i <= info.Frames; i++ {
+			frames = append(frames, filepath.Join(tempDir, fmt.Sprintf(frameName(opts), i)))
+		}
+	} else {
+		extractStart := time.Now()
+		canvas := image.NewNRGBA(image.Rect(0, 0, info.Width, info.Height))
+		for _, input := range opts.Concat {
+			err := decodeFrames(input, func(img image.Image) error {
+				fitOnCanvas(canvas, img, opts.Scaler)
+				frame, err := applyFrameFunc(opts.FrameFunc, len(frames), canvas)
+				if err != nil {
+					return err
+				}
+				path := filepath.Join(tempDir, fmt.Sprintf(frameName(opts), len(frames)+1))
+				frames = append(frames, path)
+				return writeFrame(path, frame, opts.FrameQuality)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to extract frames of %s: %w", input, err)
+			}
+		}
+		if len(frames) == 0 {
+			return ErrNoFrames
+		}
+		slog.Debug("extracted frames", "inputs", len(opts.Concat), "count", len(frames))
+		result.Timings.Extract = time.Since(extractStart).Seconds()
+	}
+
+	return encodeFrameFiles(frames, tempDir, info.Width, info.Height, info.FrameDurations, output, trim, opts, result)
+}
+
+// decodeFrames calls fn with each composited frame of input, which may also
+// be a still image.
+func decodeFrames(input string, fn func(img image.Image) error) error {
+	info, err := inspectWebP(input)
+	if err != nil {
+		return err
+	}
+	if info.Frames > 1 {
+		return decodeAnimation(input, func(_ int, img image.Image) error {
+			return fn(img)
+		})
+	}
+
+	file, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	img, err := webp.Decode(file)
+	if err != nil {
+		return err
+	}
+	return fn(img)
+}
+
+// fitOnCanvas clears canvas and draws img on it, scaled to fit while keeping
+// its aspect ratio and centred. scaler is the -scaler value.
+func fitOnCanvas(canvas *image.NRGBA, img image.Image, scaler string) {
+	draw.Draw(canvas, canvas.Bounds(), image.Transparent, image.Point{}, draw.Src)
+
+	src := img.Bounds()
+	if src.Dx() == canvas.Bounds().Dx() && src.Dy() == canvas.Bounds().Dy() {
+		draw.Draw(canvas, canvas.Bounds(), img, src.Min, draw.Src)
+		return
+	}
+	w, h := fitWithin(src.Dx(), src.Dy(), canvas.Bounds().Dx(), canvas.Bounds().Dy(), false)
+	x, y := (canvas.Bounds().Dx()-w)/2, (canvas.Bounds().Dy()-h)/2
+	var interpolator draw.Interpolator = draw.CatmullRom
+	switch scaler {
+	case "neighbor":
+		interpolator = draw.NearestNeighbor
+	case "bilinear":
+		interpolator = draw.BiLinear
+	}
+	interpolator.Scale(canvas, image.Rect(x, y, x+w, y+h), img, src, draw.Src, nil)
+}
+
+// writeFrame saves an extracted frame to path, as a JPEG of the given
+// quality when path ends in .jpg and as a PNG otherwise.
+func writeFrame(path string, img image.Image, quality int) error {
+	if filepath.Ext(path) != ".jpg" {
+		return writePNG(path, img)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(file, img, &jpeg.Options{Quality: quality}); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writePNG saves img to path with fast compression, as the frame is only
+// read back by ffmpeg.
+func writePNG(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// runConcat joins inputs into one output, named after name unless -o or
+// -template is given.
+func runConcat(inputs []string, name, output, template string, jsonOutput, showTimings bool, opts Options) {
+	if len(inputs) < 2 {
+		fatal(errors.New("-concat needs at least two inputs"))
+	}
+	for _, input := range inputs {
+		if input == "-" || isURL(input) {
+			fatal(fmt.Errorf("-concat only joins local files, not %s", input))
+		}
+	}
+	opts.Concat = inputs
+	if err := opts.validate(); err != nil {
+		fatal(err)
+	}
+
+	switch {
+	case template != "":
+		output = expandTemplate(template, name, time.Now())
+		if !opts.DryRun {
+			if err := prepareOutputDir(output); err != nil {
+				fatal(err)
+			}
+		}
+	case output == "":
+		output = defaultOutput(name, opts.outputExt())
+	case output == "-":
+		// Keep stdout clean for the video stream
+		os.Stdout = os.Stderr
+	}
+
+	result, err := convertWebPToMP4(inputs[0], output, opts)
+	if err != nil {
+		fatal(err)
+	}
+	previewOutput(output, opts)
+
+	if jsonOutput {
+		if err := writeJSON(os.Stdout, result); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if !opts.DryRun && !opts.Quiet {
+		fmt.Printf("Successfully joined %d files into %s\n", len(inputs), output)
+		if showTimings {
+			fmt.Printf("Timings: %s, total %s\n", result.Timings, seconds(result.Elapsed))
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ladderRung is one rendition of -ladder: the -scale size it is encoded at
+// and its -b bitrate.
+type ladderRung struct {
+	Width   int
+	Height  int
+	Bitrate string
+}
+
+// parseLadder parses -ladder, a comma-separated list of WIDTHxHEIGHT@BITRATE
+// renditions such as 1920x1080@5M,1280x720@3M. Either side can be -1 or -2
+// to keep the aspect ratio, as with -scale.
+func parseLadder(s string) ([]ladderRung, error) {
+	var rungs []ladderRung
+	labels := make(map[string]bool)
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		size, bitrate, ok := strings.Cut(spec, "@")
+		if !ok {
+			return nil, fmt.Errorf("invalid -ladder rendition %q (use WIDTHxHEIGHT@BITRATE, e.g. 1280x720@3M)", spec)
+		}
+		w, h, ok := strings.Cut(size, "x")
+		width, errW := strconv.Atoi(w)
+		height, errH := strconv.Atoi(h)
+		if !ok || errW != nil || errH != nil || !validScaleSide(width) || !validScaleSide(height) || width < 0 && height < 0 {
+			return nil, fmt.Errorf("invalid -ladder size %q (each side must be a positive integer, or -1 or -2 for one of them)", size)
+		}
+		if !bitratePattern.MatchString(bitrate) {
+			return nil, fmt.Errorf("invalid -ladder bitrate %q (use bits per second with an optional k, M or G suffix, e.g. 3M or 800k)", bitrate)
+		}
+		rung := ladderRung{Width: width, Height: height, Bitrate: bitrate}
+		if labels[rung.label()] {
+			return nil, fmt.Errorf("-ladder has two %s renditions", rung.label())
+		}
+		labels[rung.label()] = true
+		rungs = append(rungs, rung)
+	}
+	return rungs, nil
+}
+
+// label names the rendition in its output file: 720p for a height of 720,
+// or 1280w when only the width is fixed.
+func (r ladderRung) label() string {
+	if r.Height > 0 {
+		return fmt.Sprintf("%dp", r.Height)
+	}
+	return fmt.Sprintf("%dw", r.Width)
+}
+
+// output returns the rendition's path, output with the label added before
+// the extension.
+func (r ladderRung) output(output string) string {
+	ext := filepath.Ext(output)
+	return strings.TrimSuffix(output, ext) + "_" + r.label() + ext
+}
+
+// ladderFrames are the frames extracted for the first -ladder rendition,
+// which the others are encoded from rather than extracting them again.
+type ladderFrames struct {
+	dir           string
+	frames        []string
+	durations     []time.Duration
+	width, height int
+	cleanup       func(failed bool)
+}
+
+// convertLadder converts input once per -ladder rendition, each named
+// after output with its label, and returns the results of those that
+// succeeded, stopping at the first that fails. When the method extracts
+// frames, it does so only once.
+func convertLadder(input, output string, opts Options) (results []Result, err error) {
+	shared := &ladderFrames{}
+	defer func() {
+		if shared.cleanup != nil {
+			shared.cleanup(err != nil)
+		}
+	}()
+	opts.ladderFrames = shared
+
+	for _, rung := range opts.Ladder {
+		rungOpts := opts
+		rungOpts.Ladder = nil
+		rungOpts.Scale = fmt.Sprintf("%d:%d", rung.Width, rung.Height)
+		rungOpts.Bitrate, rungOpts.bitrateSet = rung.Bitrate, true
+		result, err := convertWebPToMP4(input, rung.output(output), rungOpts)
+		results = append(results, result)
+		if err != nil {
+			return results[:len(results)-1], fmt.Errorf("%s rendition: %w", rung.label(), err)
+		}
+	}
+	return results, nil
+}
+
+// alsoOptions returns the options of the second rendition -also-webm or
+// -also-mp4 adds, and its container. The encoder settings that only apply
+// to the output's own codec are dropped.
+func alsoOptions(opts Options) (Options, string) {
+	also := opts
+	also.AlsoWebM, also.AlsoMP4 = false, false
+	also.Format = "video"
+	also.HWAccel, also.Tune, also.Profile, also.Level = "", "", "", ""
+	if opts.AlsoWebM {
+		also.Codec, also.Container = "vp9", "webm"
+	} else {
+		also.Codec, also.Container = "h264", "mp4"
+	}
+	also.codecSet = true
+	return also, also.Container
+}
+
+// convertAlso converts input into output and then into the rendition
+// -also-webm or -also-mp4 asks for, named after output with the other
+// extension. As with -ladder, frames are only extracted once.
+func convertAlso(input, output string, opts Options) (results []Result, err error) {
+	also, container := alsoOptions(opts)
+	alsoOutput := strings.TrimSuffix(output, filepath.Ext(output)) + "." + container
+	if filepath.Clean(alsoOutput) == filepath.Clean(output) {
+		return nil, fmt.Errorf("-also-%s would overwrite %s, name the output with another extension", container, output)
+	}
+
+	shared := &ladderFrames{}
+	defer func() {
+		if shared.cleanup != nil {
+			shared.cleanup(err != nil)
+		}
+	}()
+	opts.ladderFrames, also.ladderFrames = shared, shared
+
+	result, err := convertWebPToMP4(input, output, opts)
+	if err != nil {
+		return nil, err
+	}
+	results = append(results, result)
+	if result, err = convertWebPToMP4(input, alsoOutput, also); err != nil {
+		return results, fmt.Errorf("%s rendition: %w", container, err)
+	}
+	return append(results, result), nil
+}
+
+// runRenditions is the single-file conversion for -ladder, -also-webm and
+// -also-mp4: source, the local copy of input, is converted into every
+// rendition by convert and each is reported like a single conversion.
+func runRenditions(source, input, output string, jsonOutput, showTimings bool, opts Options, convert func(input, output string, opts Options) ([]Result, error)) {
+	if output == "-" {
+		fatal(errors.New("-ladder, -also-webm and -also-mp4 write one file per rendition, not stdout"))
+	}
+	results, err := convert(source, output, opts)
+	if source != input {
+		os.Remove(source)
+		for i := range results {
+			results[i].Input = input
+		}
+	}
+
+	if jsonOutput && err == nil {
+		if err := writeJSON(os.Stdout, results); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if !opts.DryRun && !opts.Quiet {
+		for _, result := range results {
+			fmt.Printf("Successfully converted %s to %s (%s)\n", input, result.Output, result.summary())
+			if showTimings {
+				fmt.Printf("Timings: %s, total %s\n", result.Timings, seconds(result.Elapsed))
+			}
+		}
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// setupLogger installs the default logger, which writes leveled diagnostics
+// to stderr. Verbose mode always logs at debug level.
+func setupLogger(level string, verbose bool) error {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	if verbose {
+		lvl = slog.LevelDebug
+	}
+
+	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: lvl,
+		// Timestamps add noise to interactive output
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// parseLogLevel parses a -loglevel value.
+func parseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid -loglevel %q (use debug, info, warn or error)", s)
+}
+
+// fatal logs err and exits with the status exitCode picks for it.
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(exitCode(err))
+}
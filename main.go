@@ -1,251 +1,1641 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
 	_ "image/png"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"math"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	_ "golang.org/x/image/webp"
 )
 
 func main() {
+	if path := os.Getenv("WEBP2MP4_FFMPEG"); path != "" {
+		ffmpegPath = path
+	}
+
 	var (
-		input   string
-		output  string
-		fps     int
-		bitrate string
-		verbose bool
-		method  string
+		input         string
+		inputArgs     []string
+		inputList     string
+		concat        bool
+		sequence      string
+		sequenceStart int
+		output        string
+		jobs          int
+		oversubscribe bool
+		skipExisting  bool
+		overwriteOld  bool
+		tui           bool
+		minFrames     int
+		minDuration   time.Duration
+		manifest      string
+		useCache      bool
+		jsonOutput    bool
+		showTimings   bool
+		check         bool
+		selfTest      bool
+		listFormats   bool
+		listMethods   bool
+		showInfo      bool
+		probeOnly     bool
+		showVersion   bool
+		logLevel      string
+		configPath    string
+		serveAddr     string
+		thumbnail     string
+		framesOut     string
+		framesFormat  string
+		framesDigits  int
+		template      string
+		thumbFrame    int
+		thumbTime     time.Duration
+		fit           scaleSpec
+		opts          Options
 	)
 
-	flag.StringVar(&input, "i", "", "Input animated WebP file (required)")
+	flag.Func("i", "Input animated WebP file, directory or glob (required, repeat for several)", func(v string) error {
+		inputArgs = append(inputArgs, v)
+		return nil
+	})
+	flag.StringVar(&inputList, "list", "", "File listing input paths, one per line, used like repeated -i")
+	flag.BoolVar(&concat, "concat", false, "Join all inputs, in order, into a single video")
+	flag.StringVar(&sequence, "sequence", "", "Encode numbered WebP files matching this pattern, e.g. 'frame_%03d.webp', as the frames of one video")
+	flag.IntVar(&sequenceStart, "start", 1, "First frame number for -sequence")
 	flag.StringVar(&output, "o", "", "Output MP4 file (optional, defaults to input name with .mp4)")
-	flag.IntVar(&fps, "fps", 30, "Frame rate for output video")
-	flag.StringVar(&bitrate, "b", "2M", "Video bitrate (e.g., 2M, 5M)")
-	flag.BoolVar(&verbose, "v", false, "Verbose output")
-	flag.StringVar(&method, "method", "auto", "Conversion method: 'auto', 'extract', or 'direct'")
-	flag.Parse()
-
-	if input == "" {
-		fmt.Fprintf(os.Stderr, "Usage: %s -i input.webp [-o output.mp4] [-fps 30] [-b 2M] [-v]\n", os.Args[0])
+	flag.StringVar(&template, "template", "", "Output path template with {dir}, {name}, {ext} and {date}, e.g. '{dir}/converted/{name}.mp4'")
+	opts.FPS = 30
+	flag.Func("fps", "Output frame rate, as an integer, a decimal or a fraction such as 24000/1001 (default 30)", opts.setFPS)
+	flag.Func("output-fps", "Same as -fps", opts.setFPS)
+	flag.BoolVar(&opts.SmoothTiming, "smooth-timing", false, "Pick an output frame rate that fits the source's uneven frame durations and duplicate frames to match, instead of -fps (implies -method extract)")
+	flag.IntVar(&opts.FrameStep, "framestep", 1, "Keep only every Nth frame, each shown N times as long, and divide the output frame rate by N, for lighter previews")
+	flag.StringVar(&opts.FrameFormat, "frame-format", "png", "Format of the intermediate frames of the extraction methods: 'png' (lossless) or 'jpg' (much smaller, lossy, no transparency)")
+	flag.IntVar(&opts.FrameQuality, "frame-quality", 90, "JPEG quality of -frame-format jpg frames, from 1 to 100")
+	flag.Func("input-fps", "Rate extracted frames are assembled at, e.g. the source's 12.5 or 100/7, before the fps filter duplicates or drops frames to reach -fps (implies -method extract)", opts.setInputFPS)
+	flag.StringVar(&opts.Bitrate, "b", "2M", "Video bitrate (e.g., 2M, 5M)")
+	flag.BoolVar(&opts.Verbose, "v", false, "Verbose output (same as -loglevel debug)")
+	flag.BoolVar(&opts.Quiet, "quiet", false, "Only print errors")
+	flag.StringVar(&logLevel, "loglevel", "info", "Diagnostic log level: 'debug', 'info', 'warn', or 'error'")
+	flag.StringVar(&opts.Method, "method", "auto", "Conversion method: 'auto', 'extract', 'go-extract', or 'direct'")
+	flag.IntVar(&opts.ErrorLines, "error-lines", 10, "Lines of ffmpeg's output to include when it fails, from the end (0 for all)")
+	flag.BoolVar(&opts.QualityMetric, "qualitymetric", false, "After encoding, compare the output with the source frames and print the average SSIM and PSNR (implies -method extract)")
+	flag.BoolVar(&opts.Verify, "verify", false, "Check the output with ffprobe after encoding, removing it if it has no video of the expected length")
+	flag.BoolVar(&opts.VerifyDuration, "verify-duration", false, "Like -verify, but the output's duration must be within one frame of the source's")
+	flag.BoolVar(&opts.NoFallback, "no-fallback", false, "With -method auto, report a failed first attempt instead of falling back to the other method")
+	flag.BoolVar(&opts.Progress, "progress", false, "Show encoding progress on stderr")
+	flag.BoolVar(&opts.Overwrite, "overwrite", false, "Overwrite existing output files")
+	flag.BoolVar(&opts.Overwrite, "f", false, "Shorthand for -overwrite")
+	flag.BoolVar(&opts.MakeDirs, "mkdir", false, "Create the output's directory and its parents if they don't exist")
+	flag.BoolVar(&opts.AlsoWebM, "also-webm", false, "Also encode a VP9 WebM next to the output, named like it, reusing the extracted frames")
+	flag.BoolVar(&opts.AlsoMP4, "also-mp4", false, "Also encode an H.264 MP4 next to a -codec vp9 or hevc output, reusing the extracted frames")
+	flag.Func("ladder", "Encode several renditions, as comma-separated WIDTHxHEIGHT@BITRATE, e.g. 1920x1080@5M,1280x720@3M, each named with its height like video_720p.mp4", func(v string) (err error) {
+		opts.Ladder, err = parseLadder(v)
+		return err
+	})
+	flag.StringVar(&opts.Scale, "scale", "", "Output size as W:H (-1/-2 keeps aspect ratio) or a percentage like 50%")
+	flag.StringVar(&opts.Aspect, "aspect", "", "Display aspect ratio to store, e.g. 16:9, without rescaling the pixels (default the natural ratio)")
+	flag.IntVar(&opts.Rotate, "rotate", 0, "Rotate the video clockwise by 90, 180 or 270 degrees")
+	flag.BoolVar(&opts.RotateFromMetadata, "rotate-from-metadata", true, "Turn the video upright according to the input's EXIF orientation, unless -rotate or -flip is given")
+	flag.StringVar(&opts.Flip, "flip", "", "Mirror the video: 'h' (horizontally) or 'v' (vertically)")
+	flag.StringVar(&opts.Scaler, "scaler", "lanczos", "Scaling algorithm: 'lanczos', 'bicubic', 'bilinear', or 'neighbor'")
+	flag.IntVar(&opts.MaxWidth, "maxwidth", 0, "Maximum output width, keeping the aspect ratio")
+	flag.IntVar(&opts.MaxHeight, "maxheight", 0, "Maximum output height, keeping the aspect ratio")
+	flag.BoolVar(&opts.NoUpscale, "resize-only-if-larger", false, "Only shrink with -scale, leaving sources smaller than the target at their own size (-maxwidth/-maxheight never upscale)")
+	flag.StringVar(&opts.Crop, "crop", "", "Crop to a W:H:X:Y region before scaling (W:H crops around the centre)")
+	flag.BoolVar(&opts.AutoCrop, "autocrop", false, "Crop away uniform or transparent borders")
+	flag.StringVar(&opts.Watermark, "watermark", "", "Image (PNG, JPEG or WebP) to overlay on the video")
+	flag.StringVar(&opts.WatermarkPos, "watermark-pos", "bottom-right", "Watermark position: 'top-left', 'top-right', 'bottom-left', 'bottom-right', or 'center'")
+	flag.Float64Var(&opts.WatermarkOpacity, "watermark-opacity", 1, "Watermark opacity from 0 to 1")
+	flag.StringVar(&opts.Audio, "audio", "", "Audio file to mux into the output as AAC")
+	flag.StringVar(&opts.AudioFit, "audiofit", "loop", "Fit -audio to the video: 'loop' repeats it, 'trim' plays it once")
+	flag.Func("fit", "Scale the animation to fit inside a WxH canvas, e.g. 512x512, centred and padded with -padcolor", func(v string) (err error) {
+		fit, err = parseFit(v)
+		return err
+	})
+	flag.BoolVar(&opts.Pad, "pad", false, "Keep the aspect ratio when -scale W:H doesn't match it, padding instead of stretching")
+	flag.StringVar(&opts.PadColor, "padcolor", "black", "Color of the -pad borders (ffmpeg color name or #RRGGBB)")
+	flag.StringVar(&opts.ExtraFilters, "vf-extra", "", "ffmpeg filters appended to the built-in scale/crop/pad chain, e.g. eq=contrast=1.1")
+	flag.Func("global-args", "Options inserted at the front of every ffmpeg command, e.g. '-threads 2 -nostdin' (space-separated, repeatable; advanced)", func(v string) error {
+		opts.GlobalArgs = append(opts.GlobalArgs, strings.Fields(v)...)
+		return nil
+	})
+	flag.BoolVar(&opts.Denoise, "denoise", false, "Smooth out compression noise before scaling (hqdn3d)")
+	flag.Float64Var(&opts.DenoiseStrength, "denoise-strength", 4, "Spatial strength of -denoise")
+	flag.BoolVar(&opts.Sharpen, "sharpen", false, "Sharpen the video after scaling (unsharp)")
+	flag.Float64Var(&opts.SharpenAmount, "sharpen-amount", 1, "Strength of -sharpen, up to 5")
+	flag.Func("ss", "Start time of the encoded range (seconds, 1500ms or hh:mm:ss.fff)", func(v string) (err error) {
+		opts.TrimStart, err = parseTimestamp(v)
+		return err
+	})
+	flag.Func("to", "End time of the encoded range (seconds, 1500ms or hh:mm:ss.fff)", func(v string) (err error) {
+		opts.TrimEnd, err = parseTimestamp(v)
+		return err
+	})
+	flag.Func("range", "Part of the animation to encode as percentages of its length, e.g. 40%:60% for the middle 20%", func(v string) (err error) {
+		opts.Range, err = parseRange(v)
+		return err
+	})
+	flag.IntVar(&opts.StartFrame, "startframe", 0, "First frame to encode (0-based)")
+	flag.IntVar(&opts.EndFrame, "endframe", -1, "Last frame to encode, inclusive (default last frame)")
+	flag.StringVar(&opts.Format, "format", "video", "Output format: 'video', 'apng' for a lossless animated PNG with transparency, 'webp' to re-encode as an animated WebP, or 'hevc-alpha' for a MOV that keeps transparency, as Apple stickers use")
+	flag.IntVar(&opts.WebPQuality, "webp-quality", 75, "Quality of -format webp from 0 to 100, higher is better and larger")
+	flag.StringVar(&opts.Container, "container", "", "Output container: 'mp4', 'mov', 'mkv', 'm4v' or 'ts' (default from the -o extension, mp4 for pipes)")
+	flag.Float64Var(&opts.Speed, "speed", 1, "Playback speed multiplier, e.g. 0.5 for half speed or 2 for double")
+	flag.BoolVar(&opts.Reverse, "reverse", false, "Play the animation backwards")
+	flag.BoolVar(&opts.Boomerang, "boomerang", false, "Play the animation forwards then backwards")
+	flag.IntVar(&opts.Interpolate, "interpolate", 0, "Synthesize frames up to this rate with minterpolate when the source is slower (CPU-heavy)")
+	flag.StringVar(&opts.InterpolateMode, "interpolatemode", "mci", "Interpolation mode: 'mci' (motion compensated), 'blend', or 'dup'")
+	flag.Func("stillduration", "Length of the clip made from a non-animated WebP (seconds, 1500ms or hh:mm:ss.fff)", func(v string) (err error) {
+		opts.StillDuration, err = parseTimestamp(v)
+		return err
+	})
+	flag.IntVar(&opts.Loop, "loop", 0, "Repeat the animation this many extra times (0 plays it once)")
+	flag.Func("loop-to-duration", "Repeat the animation until the video is this long, e.g. 10s, cutting the last repeat short", func(v string) (err error) {
+		opts.LoopDuration, err = parseTimestamp(v)
+		return err
+	})
+	flag.Func("frame-duration-cap", "Longest any single frame is shown, in milliseconds, e.g. 2000, shortening frames held longer", func(v string) error {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms <= 0 {
+			return fmt.Errorf("invalid -frame-duration-cap %q: expected a positive number of milliseconds", v)
+		}
+		opts.FrameDurationCap = time.Duration(ms) * time.Millisecond
+		return nil
+	})
+	flag.Func("min-output-duration", "Hold the last frame of shorter videos until they are this long, e.g. 1s (no looping)", func(v string) (err error) {
+		opts.MinOutputDuration, err = parseTimestamp(v)
+		return err
+	})
+	flag.Func("crf", "Constant quality from 1 (best) to 51 instead of -b; mapped to -q:v for videotoolbox", func(v string) error {
+		// 0 leaves Options.CRF unset, so it can't be asked for
+		crf, err := strconv.Atoi(v)
+		if err != nil || crf < 1 || crf > 51 {
+			return fmt.Errorf("invalid -crf %q: expected a number from 1 to 51", v)
+		}
+		opts.CRF = crf
+		return nil
+	})
+	flag.BoolVar(&opts.Lossless, "lossless", false, "Encode losslessly with libx264 or libx265, for editing or archiving (files are many times larger)")
+	flag.StringVar(&opts.Tune, "tune", "", "Encoder tuning: 'film', 'animation', 'grain', 'stillimage', 'fastdecode' or 'zerolatency' for libx264, 'animation', 'grain', 'psnr', 'ssim', 'fastdecode' or 'zerolatency' for -codec hevc")
+	flag.BoolVar(&opts.TuneSource, "tune-source", false, "Pick -tune and -b to suit lossless or lossy sources, unless given")
+	flag.IntVar(&opts.MaxFrames, "max-frames", 0, "Refuse inputs with more frames than this (default 0, no limit)")
+	flag.IntVar(&opts.BFrames, "bframes", -1, "Maximum consecutive B-frames, 0 for none, for hardware decoders that limit them (default chosen by the encoder)")
+	flag.IntVar(&opts.Refs, "refs", -1, "Number of reference frames, for hardware decoders that limit them (default chosen by the encoder)")
+	flag.IntVar(&opts.GOP, "gop", 0, "Keyframe interval in frames, 1 making every frame a keyframe (default chosen by the encoder)")
+	flag.IntVar(&opts.Threads, "threads", 0, "Encoder threads per conversion (default 0, chosen by ffmpeg from the CPU count)")
+	flag.BoolVar(&opts.FastStart, "faststart", true, "Move the MP4/MOV index to the front of the file for quicker playback start (-faststart=false skips the extra pass)")
+	flag.StringVar(&opts.Codec, "codec", "h264", "Video codec: 'h264', 'hevc' (H.265 with libx265, smaller files but slower to encode) or 'vp9' (libvpx-vp9, written as WebM unless -container or -o says otherwise)")
+	flag.StringVar(&opts.Profile, "profile", "", "H.264 profile: 'baseline', 'main', 'high', 'high10', 'high422' or 'high444' (default chosen by the encoder)")
+	flag.StringVar(&opts.Level, "level", "", "H.264 level, e.g. 3.0 or 4.1 (default chosen by the encoder)")
+	flag.StringVar(&opts.PixFmt, "pixfmt", "", "Output pixel format, e.g. yuv444p or yuv420p10le (default yuv420p, or the hardware encoder's native format)")
+	flag.StringVar(&opts.ColorSpace, "colorspace", "", "Convert to and tag this color space: 'bt709', 'bt601', or 'bt2020'")
+	flag.StringVar(&opts.ColorRange, "colorrange", "", "Output color range: 'tv' (limited) or 'pc' (full)")
+	flag.IntVar(&opts.Retries, "retries", 0, "Retry a conversion this many times if ffmpeg fails, with exponential backoff")
+	flag.BoolVar(&opts.PreserveTimes, "preserve-times", false, "Give the output the modification time of the input")
+	flag.StringVar(&opts.Extractor, "extractor", "", "Frame extraction tool: 'ffmpeg', 'imagemagick', 'webpmux' or 'go' (implies -method extract; default ffmpeg, falling back to ImageMagick)")
+	flag.BoolVar(&opts.StreamFrames, "stream-frames", false, "Decode frames in Go and pipe them to ffmpeg instead of extracting them to disk, for very long animations")
+	flag.BoolVar(&opts.KeepTempOnFailure, "keep-temp-on-failure", false, "Leave the extracted frames in their temp directory when a conversion fails, and log where it is")
+	flag.StringVar(&opts.KeepFrames, "keep-frames", "", "Extract frames into this directory and keep them (implies -method extract)")
+	flag.StringVar(&opts.ICC, "icc", "", "Embedded ICC colour profiles: 'apply' converts the frames to sRGB (decoding them in Go), 'strip' drops them without the warning given otherwise")
+	flag.BoolVar(&opts.Preview, "preview", false, "Open the output with the default player after converting a single file (only on an interactive terminal)")
+	flag.IntVar(&opts.Nice, "nice", 0, "Run ffmpeg and the other tools at this niceness, from -20 (highest priority, root only) to 19 (lowest), e.g. 10 for background batches")
+	flag.DurationVar(&opts.Timeout, "timeout", 0, "Give up on a file whose conversion takes longer than this, e.g. 2m (default: no limit)")
+	flag.StringVar(&opts.TempDir, "tempdir", "", "Directory for extracted frames (default: the system temp directory)")
+	flag.BoolVar(&opts.Cover, "cover", false, "Embed the first frame in the MP4/MOV as cover art")
+	flag.Func("metadata", "Tag to write to the output as key=value, e.g. comment=From example.com (repeatable)", func(v string) error {
+		opts.Metadata = append(opts.Metadata, v)
+		return nil
+	})
+	flag.Func("title", "Title tag for the output, short for -metadata title=...", func(v string) error {
+		opts.Metadata = append(opts.Metadata, "title="+v)
+		return nil
+	})
+	flag.BoolVar(&opts.CopyMetadata, "copy-metadata", true, "Carry the artist, copyright and description in the input's EXIF and XMP over to the output's tags")
+	flag.BoolVar(&opts.Deterministic, "deterministic", false, "Strip version tags and timestamps so identical inputs give byte-identical output (libx264 still embeds its version, so only with the same build; hardware encoders ignore +bitexact)")
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "Print the ffmpeg commands that would run without running them")
+	flag.Func("maxsize", "Pick the bitrate so the output fits in this size, e.g. 8MB (uses two-pass encoding)", func(v string) (err error) {
+		opts.MaxSize, err = parseSize(v)
+		return err
+	})
+	flag.BoolVar(&opts.TwoPass, "2pass", false, "Two-pass encoding for more accurate bitrate (roughly doubles encode time)")
+	flag.StringVar(&opts.HWAccel, "hwaccel", "", "Hardware encoder: 'nvenc', 'vaapi', 'qsv', 'videotoolbox', or 'auto' for the best available (default libx264)")
+	flag.StringVar(&thumbnail, "thumbnail", "", "Also save one frame as a PNG or JPEG still (without -o, only the still is made)")
+	flag.StringVar(&framesOut, "frames-out", "", "Export every frame to this directory as numbered images instead of making a video")
+	flag.StringVar(&framesFormat, "frames-format", "png", "Image format for -frames-out: 'png' or 'jpg'")
+	flag.IntVar(&framesDigits, "frames-digits", 6, "Digits in the -frames-out frame numbers, e.g. 6 for frame_000001.png")
+	flag.IntVar(&thumbFrame, "thumbframe", 0, "Frame to use for -thumbnail (0-based)")
+	flag.Func("thumbtime", "Use the frame shown at this time for -thumbnail (seconds, 1500ms or hh:mm:ss.fff)", func(v string) (err error) {
+		thumbTime, err = parseTimestamp(v)
+		return err
+	})
+	flag.StringVar(&ffmpegPath, "ffmpeg", ffmpegPath, "ffmpeg executable to use (default $WEBP2MP4_FFMPEG, or ffmpeg from PATH)")
+	flag.IntVar(&jobs, "j", 1, "Number of files to convert concurrently in batch mode")
+	flag.BoolVar(&oversubscribe, "oversubscribe", false, "Allow -j to exceed the number of CPUs")
+	flag.BoolVar(&skipExisting, "skip-existing", false, "In batch mode, skip files whose output is newer than the source")
+	flag.BoolVar(&overwriteOld, "overwrite-older", false, "In batch mode, reconvert files whose output is older than the source and skip the rest, like make")
+	flag.BoolVar(&tui, "tui", false, "In batch mode, list the inputs and choose which to convert, and their -fps and -b, before starting")
+	flag.IntVar(&minFrames, "min-frames", 0, "In batch mode, skip inputs with fewer frames than this")
+	flag.Func("min-duration", "In batch mode, skip inputs whose animation is shorter than this (seconds, 1500ms or hh:mm:ss.fff)", func(v string) (err error) {
+		minDuration, err = parseTimestamp(v)
+		return err
+	})
+	flag.BoolVar(&opts.StrictDeps, "strict-deps", false, "Fail at startup instead of warning when a tool the chosen method may fall back to, such as ImageMagick, is missing")
+	flag.BoolVar(&useCache, "cache", false, "Remember the dimensions, frame count and duration of inputs between runs")
+	flag.StringVar(&manifest, "manifest", "", "In batch mode, write a CSV row per file to this path as files finish")
+	flag.BoolVar(&showTimings, "timings", false, "Print how long inspecting, extracting frames and encoding took")
+	flag.BoolVar(&jsonOutput, "json", false, "Print conversion metadata as JSON instead of the success message")
+	flag.StringVar(&serveAddr, "serve", "", "Run an HTTP server on this address (e.g. :8080) that converts WebPs POSTed to /convert")
+	flag.BoolVar(&check, "check", false, "Report available tools and encoders, then exit")
+	flag.BoolVar(&selfTest, "selftest", false, "Convert a generated test animation with the other options given and verify the result, then exit")
+	flag.BoolVar(&probeOnly, "probe-only", false, "Check that every input is a valid animated WebP, without converting anything, then exit")
+	flag.BoolVar(&showInfo, "info", false, "Print the WebP's dimensions, frames, duration, loop count and alpha, then exit")
+	flag.BoolVar(&listFormats, "list-formats", false, "List the output containers and encoders available with this ffmpeg, then exit")
+	flag.BoolVar(&listMethods, "list-methods", false, "Describe each -method, what it needs and when to use it, then exit")
+	flag.BoolVar(&showVersion, "version", false, "Print version information and exit")
+	flag.StringVar(&configPath, "config", "", "Config file with default flag values (default ~/.config/webp2mp4.yaml)")
+	// The flag package would exit with 2, which means a missing input here
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		os.Exit(exitFailure)
+	}
+
+	explicitConfig := configPath != ""
+	if !explicitConfig {
+		configPath = defaultConfigPath()
+	}
+	if err := applyConfig(flag.CommandLine, configPath, explicitConfig); err != nil {
+		fatal(err)
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "b":
+			opts.bitrateSet = true
+		case "codec":
+			opts.codecSet = true
+		case "rotate", "flip":
+			opts.transformSet = true
+		}
+	})
+
+	if opts.Quiet && (opts.Verbose || strings.EqualFold(logLevel, "debug")) {
+		fmt.Fprintln(os.Stderr, "-quiet and -v can't be combined")
+		os.Exit(1)
+	}
+	if opts.Quiet {
+		logLevel = "error"
+		opts.Progress = false
+	}
+	if err := setupLogger(logLevel, opts.Verbose); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if useCache {
+		if err := openInspectCache(defaultCachePath()); err != nil {
+			slog.Warn("not using the inspection cache", "err", err)
+		}
+	}
+	if inputList != "" {
+		files, err := readInputList(inputList)
+		if err != nil {
+			fatal(err)
+		}
+		inputArgs = append(inputArgs, files...)
+	}
+	if len(inputArgs) > 0 {
+		input = inputArgs[0]
+	}
+	if fit.Width > 0 {
+		switch {
+		case opts.Scale != "":
+			fatal(errors.New("-fit and -scale can't be combined"))
+		case len(opts.Ladder) > 0:
+			fatal(errors.New("-fit and -ladder can't be combined"))
+		}
+		opts.Scale, opts.Pad = fmt.Sprintf("%d:%d", fit.Width, fit.Height), true
+		// Round an odd canvas down to even rather than past the slot
+		if opts.MaxWidth == 0 || opts.MaxWidth > fit.Width {
+			opts.MaxWidth = fit.Width
+		}
+		if opts.MaxHeight == 0 || opts.MaxHeight > fit.Height {
+			opts.MaxHeight = fit.Height
+		}
+	}
+
+	// Debug logging includes ffmpeg's own output
+	if strings.EqualFold(logLevel, "debug") {
+		opts.Verbose = true
+	}
+
+	if showVersion {
+		printVersion(os.Stdout)
+		return
+	}
+
+	if listFormats || flag.Arg(0) == "formats" {
+		if !runListFormats(os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if listMethods {
+		runListMethods(os.Stdout)
+		return
+	}
+
+	if check || flag.Arg(0) == "check" {
+		if !runCheck(os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if showInfo && input != "" {
+		inputs, _, err := expandAll(inputArgs)
+		if err != nil {
+			fatal(err)
+		}
+		if !runInfo(os.Stdout, inputs, jsonOutput) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if probeOnly && input != "" {
+		inputs, _, err := expandAll(inputArgs)
+		if err != nil {
+			fatal(err)
+		}
+		if !runProbe(os.Stdout, inputs, opts, jsonOutput) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := CheckDependencies(opts); err != nil {
+		slog.Error(err.Error(), "hint", "install it first, run with -check for details")
+		os.Exit(exitCode(err))
+	}
+
+	if flag.Arg(0) == "faststart" {
+		if err := runFastStart(flag.Args()[1:], opts); err != nil {
+			fatal(err)
+		}
+		if !opts.DryRun && !opts.Quiet {
+			fmt.Printf("Moved the index of %s to the front\n", flag.Arg(1))
+		}
+		return
+	}
+
+	if serveAddr != "" {
+		if err := opts.validate(); err != nil {
+			fatal(err)
+		}
+		if len(opts.Ladder) > 0 || opts.AlsoWebM || opts.AlsoMP4 {
+			fatal(errors.New("-ladder, -also-webm and -also-mp4 can't be used with -serve"))
+		}
+		opts.clampFPS()
+		fatal(runServer(serveAddr, jobs, opts))
+	}
+
+	if selfTest || flag.Arg(0) == "selftest" {
+		if err := opts.validate(); err != nil {
+			fatal(err)
+		}
+		opts.clampFPS()
+		if !runSelfTest(os.Stdout, opts) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if input == "" && sequence == "" {
+		fmt.Fprintf(os.Stderr, "Usage: %s -i input.webp|dir|glob [-o output.mp4] [-fps 30] [-b 2M] [-j 1] [-v]\n", os.Args[0])
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	if output == "" {
-		ext := filepath.Ext(input)
-		output = strings.TrimSuffix(input, ext) + ".mp4"
+	if err := opts.validate(); err != nil {
+		fatal(err)
+	}
+	opts.clampFPS()
+	if opts.Lossless && opts.Format == "video" {
+		slog.Warn("-lossless output is often tens of times larger than the lossy encode, and many players and browsers can't decode it")
+	}
+	if template != "" {
+		if output != "" {
+			fatal(errors.New("-o and -template can't be combined"))
+		}
+		if err := checkTemplate(template); err != nil {
+			fatal(err)
+		}
+	}
+
+	// Report a missing encoder once, up front, rather than for every file
+	if _, err := selectEncoder(opts); err != nil {
+		fatal(err)
+	}
+
+	if opts.KeepFrames != "" && opts.Method == "auto" {
+		// Only the extraction methods produce frames to keep
+		opts.Method = "extract"
+	}
+
+	if sequence != "" {
+		if input != "" {
+			fatal(errors.New("-sequence and -i can't be combined"))
+		}
+		files, err := expandSequence(sequence, sequenceStart)
+		if err != nil {
+			fatal(err)
+		}
+		runConcat(files, sequenceName(sequence), output, template, jsonOutput, showTimings, opts)
+		return
+	}
+
+	inputs, batch, err := expandAll(inputArgs)
+	if err != nil {
+		fatal(err)
+	}
+
+	if concat {
+		if thumbnail != "" {
+			fatal(errors.New("-thumbnail can't be combined with -concat"))
+		}
+		runConcat(inputs, inputs[0], output, template, jsonOutput, showTimings, opts)
+		return
+	}
+
+	if batch {
+		if thumbnail != "" {
+			fatal(errors.New("-thumbnail only works with a single input file"))
+		}
+		if framesOut != "" {
+			fatal(errors.New("-frames-out only works with a single input file"))
+		}
+		if opts.KeepFrames != "" {
+			fatal(errors.New("-keep-frames only works with a single input file"))
+		}
+		if opts.Preview {
+			fatal(errors.New("-preview only works with a single input file"))
+		}
+		if len(opts.Ladder) > 0 || opts.AlsoWebM || opts.AlsoMP4 {
+			fatal(errors.New("-ladder, -also-webm and -also-mp4 only work with a single input file"))
+		}
+		if overwriteOld {
+			// Up-to-date outputs are skipped, so only stale ones are replaced
+			skipExisting, opts.Overwrite = true, true
+		}
+		if tui {
+			if jsonOutput {
+				fatal(errors.New("-tui can't be combined with -json"))
+			}
+			if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+				fatal(errors.New("-tui needs an interactive terminal, convert without it in scripts"))
+			}
+			if inputs = selectBatch(os.Stdin, os.Stdout, inputs, &opts); len(inputs) == 0 {
+				return
+			}
+		}
+		filter := batchFilter{SkipExisting: skipExisting, MinFrames: minFrames, MinDuration: minDuration}
+		runBatch(inputs, output, template, jobs, oversubscribe, filter, manifest, jsonOutput, showTimings, opts)
+		return
+	}
+	if skipExisting {
+		fatal(errors.New("-skip-existing only works in batch mode"))
+	}
+	if minFrames > 0 || minDuration > 0 {
+		fatal(errors.New("-min-frames and -min-duration only work in batch mode"))
+	}
+	if overwriteOld {
+		fatal(errors.New("-overwrite-older only works in batch mode"))
+	}
+	if manifest != "" {
+		fatal(errors.New("-manifest only works in batch mode"))
+	}
+	if tui {
+		fatal(errors.New("-tui only works in batch mode"))
+	}
+
+	// ffmpeg's WebP demuxer needs a seekable input, so stdin and URLs are
+	// buffered to a temp file first
+	source := input
+	if input == "-" {
+		if output == "" && thumbnail == "" && framesOut == "" {
+			fatal(errors.New("-o is required when reading from stdin (use -o - to write to stdout)"))
+		}
+		path, err := bufferStdin()
+		if err != nil {
+			fatal(err)
+		}
+		source = path
+	} else if isURL(input) {
+		// Ctrl-C cancels the download instead of leaving it hanging
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		path, err := downloadInput(ctx, input)
+		stop()
+		if err != nil {
+			fatal(err)
+		}
+		source = path
+	}
+
+	if thumbnail != "" {
+		err := makeThumbnail(source, thumbnail, thumbFrame, thumbTime, opts)
+		if source != input && (err != nil || output == "") {
+			os.Remove(source)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		if output == "" {
+			if opts.DryRun || opts.Quiet {
+				return
+			}
+			fmt.Printf("Saved thumbnail of %s to %s\n", input, thumbnail)
+			return
+		}
+	}
+
+	if framesOut != "" {
+		if opts.DryRun {
+			fmt.Printf("# frames of %s -> %s\n", input, framesOut)
+			return
+		}
+		n, err := exportFrames(source, framesOut, framesFormat, framesDigits, opts.Overwrite)
+		if source != input {
+			os.Remove(source)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		if !opts.Quiet {
+			fmt.Printf("Exported %d frames of %s to %s\n", n, input, framesOut)
+		}
+		return
+	}
+
+	if template != "" {
+		output = expandTemplate(template, input, time.Now())
+		if !opts.DryRun {
+			if err := prepareOutputDir(output); err != nil {
+				fatal(err)
+			}
+		}
+	} else if output == "" {
+		name := input
+		if isURL(input) {
+			name = urlFileName(input)
+		}
+		output = defaultOutput(name, opts.outputExt())
+	}
+
+	if output == "-" {
+		// Keep stdout clean for the video stream, status output goes to stderr
+		os.Stdout = os.Stderr
+	}
+
+	if len(opts.Ladder) > 0 {
+		runRenditions(source, input, output, jsonOutput, showTimings, opts, convertLadder)
+		return
+	}
+	if opts.AlsoWebM || opts.AlsoMP4 {
+		runRenditions(source, input, output, jsonOutput, showTimings, opts, convertAlso)
+		return
+	}
+
+	result, err := convertWebPToMP4(source, output, opts)
+	if source != input {
+		os.Remove(source)
+		result.Input = input
+	}
+	if err != nil {
+		fatal(err)
+	}
+	previewOutput(output, opts)
+
+	if jsonOutput {
+		if err := writeJSON(os.Stdout, result); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	if !opts.DryRun && !opts.Quiet {
+		fmt.Printf("Successfully converted %s to %s (%s)\n", input, output, result.summary())
+		if showTimings {
+			fmt.Printf("Timings: %s, total %s\n", result.Timings, seconds(result.Elapsed))
+		}
+	}
+}
+
+// runBatch converts every input, writing outputs next to their sources, into
+// outputDir or where template says when one is given, and exits nonzero if
+// any file failed.
+func runBatch(inputs []string, outputDir, template string, jobs int, oversubscribe bool, filter batchFilter, manifestPath string, jsonOutput, showTimings bool, opts Options) {
+	if jobs < 1 {
+		fatal(errors.New("-j must be at least 1"))
+	}
+	if filter.MinFrames < 0 {
+		fatal(errors.New("-min-frames must not be negative"))
+	}
+	if cpus := runtime.NumCPU(); jobs > cpus && !oversubscribe {
+		slog.Warn("-j exceeds the number of CPUs, limiting concurrency (use -oversubscribe to override)", "jobs", jobs, "cpus", cpus)
+		jobs = cpus
+	}
+
+	if outputDir != "" {
+		if _, err := os.Stat(outputDir); os.IsNotExist(err) && opts.MakeDirs && !opts.DryRun {
+			if err := os.MkdirAll(outputDir, 0o755); err != nil {
+				fatal(fmt.Errorf("failed to create output directory: %w", err))
+			}
+		}
+		if info, err := os.Stat(outputDir); err != nil || !info.IsDir() {
+			fatal(fmt.Errorf("-o must be an existing directory when converting multiple files: %s", outputDir))
+		}
+	}
+
+	now := time.Now()
+	outputs := make([]string, len(inputs))
+	seen := make(map[string]string, len(inputs))
+	for i, in := range inputs {
+		out := defaultOutput(in, opts.outputExt())
+		if template != "" {
+			out = expandTemplate(template, in, now)
+		} else if outputDir != "" {
+			out = filepath.Join(outputDir, filepath.Base(out))
+		}
+		if first, ok := seen[filepath.Clean(out)]; ok {
+			fatal(fmt.Errorf("%s and %s would both be written to %s", first, in, out))
+		}
+		seen[filepath.Clean(out)] = in
+		if template != "" && !opts.DryRun {
+			if err := prepareOutputDir(out); err != nil {
+				fatal(err)
+			}
+		}
+		outputs[i] = out
+	}
+
+	var manifest *manifestWriter
+	if manifestPath != "" && !opts.DryRun {
+		var err error
+		if manifest, err = createManifest(manifestPath); err != nil {
+			fatal(err)
+		}
 	}
 
-	if err := convertWebPToMP4(input, output, fps, bitrate, verbose, method); err != nil {
-		log.Fatal(err)
+	report := !jsonOutput && !opts.DryRun && !opts.Quiet
+	start := time.Now()
+	opts.Concurrency = jobs
+	opts.batch = &batchRun{outputs: outputs, filter: filter, report: report, manifest: manifest}
+	// Each failure is reported with its file below
+	converted, _ := ConvertBatch(inputs, opts)
+	results := batchResults(converted)
+	if manifest != nil {
+		if err := manifest.Close(); err != nil {
+			slog.Error("failed to write manifest", "err", err)
+		}
+	}
+
+	if jsonOutput {
+		if err := writeBatchJSON(os.Stdout, results); err != nil {
+			fatal(err)
+		}
+		os.Exit(batchExitCode(results))
 	}
 
-	fmt.Printf("Successfully converted %s to %s\n", input, output)
+	if opts.DryRun || opts.Quiet {
+		for _, r := range results {
+			if r.Err != nil {
+				slog.Error("conversion failed", "input", r.Input, "err", r.Err)
+			}
+		}
+		os.Exit(batchExitCode(results))
+	}
+
+	printBatchSummary(results, time.Since(start))
+	if showTimings || opts.Verbose {
+		printBatchTimings(results)
+	}
+	os.Exit(batchExitCode(results))
+}
+
+// Result describes a completed conversion.
+type Result struct {
+	Input        string  `json:"input"`
+	Output       string  `json:"output"`
+	Width        int     `json:"width"`
+	Height       int     `json:"height"`
+	OutputWidth  int     `json:"output_width"`
+	OutputHeight int     `json:"output_height"`
+	Frames       int     `json:"frames"`
+	SourceFPS    float64 `json:"source_fps"`
+	FPS          float64 `json:"fps"`
+	Method       string  `json:"method"`
+	Compression  string  `json:"compression"`
+	Bitrate      string  `json:"bitrate"`
+	Duration     float64 `json:"duration_seconds"`
+	SSIM         float64 `json:"ssim,omitempty"`
+	PSNR         float64 `json:"psnr,omitempty"`
+	Size         int64   `json:"output_bytes,omitempty"`
+	// ClampedFrames counts the frames -frame-duration-cap shortened
+	ClampedFrames int     `json:"clamped_frames,omitempty"`
+	Elapsed       float64 `json:"elapsed_seconds"`
+	Timings       Timings `json:"timings"`
+	// Err is why ConvertBatch couldn't convert the file, nil if it could
+	Err error `json:"-"`
+	// skipped and short carry batchResult's Skipped and Short through
+	// ConvertBatch
+	skipped, short bool
 }
 
-func convertWebPToMP4(input, output string, fps int, bitrate string, verbose bool, method string) error {
-	// Check if input file exists
-	if _, err := os.Stat(input); os.IsNotExist(err) {
-		return fmt.Errorf("input file does not exist: %s", input)
+// convertOnce makes a single attempt at converting input to output.
+func convertOnce(input, output string, opts Options) (Result, error) {
+	start := time.Now()
+	resolveContainer(output, &opts)
+	result := Result{Input: input, Output: output, FPS: opts.FPS, Bitrate: opts.Bitrate}
+
+	inspectStart := time.Now()
+	var info WebPInfo
+	var err error
+	if len(opts.Concat) > 0 {
+		info, err = inspectConcat(opts.Concat, opts.FPS)
+		if err != nil {
+			return result, err
+		}
+	} else {
+		// Check if input file exists
+		if _, err := os.Stat(input); os.IsNotExist(err) {
+			return result, fmt.Errorf("%w: %s", ErrInputNotFound, input)
+		}
+		if err := validateWebPHeader(input); err != nil {
+			return result, err
+		}
+		info, err = inspectCached(input)
+		if err != nil {
+			return result, fmt.Errorf("invalid input: %w", err)
+		}
+	}
+	// Checked before anything decodes the frames
+	if opts.MaxFrames > 0 && info.Frames > opts.MaxFrames {
+		return result, fmt.Errorf("%s has %d frames, more than -max-frames %d allows", input, info.Frames, opts.MaxFrames)
+	}
+	if info.Frames < 2 && opts.LoopDuration > 0 {
+		// A still has nothing to repeat, it is simply shown that long
+		opts.StillDuration = opts.LoopDuration
+	}
+	if info.Frames < 2 && opts.StillDuration <= 0 {
+		return result, fmt.Errorf("%s is a still image (1 frame), not an animated WebP (use -stillduration to make a clip of it)", input)
+	}
+
+	if opts.FrameDurationCap > 0 {
+		info.FrameDurations, result.ClampedFrames = capDurations(info.FrameDurations, opts.FrameDurationCap)
+		if result.ClampedFrames > 0 {
+			info.Duration = 0
+			for _, d := range info.FrameDurations {
+				info.Duration += d
+			}
+			slog.Info("clamped long frames to -frame-duration-cap", "input", input, "frames", result.ClampedFrames, "cap", opts.FrameDurationCap)
+		}
+	}
+	result.Width, result.Height, result.Frames = info.Width, info.Height, info.Frames
+	result.Compression = info.Compression
+	if info.Duration > 0 {
+		result.SourceFPS = float64(info.Frames) / info.Duration.Seconds()
+	}
+
+	if opts.AutoCrop {
+		crop, err := detectCrop(input)
+		if err != nil {
+			return result, err
+		}
+		slog.Debug("detected crop", "crop", crop)
+		opts.Crop = crop.String()
+	}
+	if opts.Crop != "" {
+		crop, _ := parseCrop(opts.Crop)
+		if err := crop.fits(info.Width, info.Height); err != nil {
+			return result, err
+		}
+	}
+	if len(opts.Concat) == 0 {
+		if opts.FrameFunc, err = colorProfileFunc(input, info, opts); err != nil {
+			return result, err
+		}
+	}
+	if info.Orientation > 1 && opts.RotateFromMetadata && !opts.transformSet {
+		opts.Rotate, opts.Flip = orientationTransform(info.Orientation)
+		slog.Debug("applying EXIF orientation", "input", input, "orientation", info.Orientation, "rotate", opts.Rotate, "flip", opts.Flip)
+	}
+	if opts.CopyMetadata && !opts.Deterministic && len(opts.Concat) == 0 {
+		metadata := withSourceMetadata(input, opts)
+		if carried := len(metadata) - len(opts.Metadata); carried > 0 {
+			slog.Debug("carrying over metadata", "input", input, "tags", metadata[:carried])
+		}
+		opts.Metadata = metadata
+	}
+	result.Timings.Inspect = time.Since(inspectStart).Seconds()
+
+	trim, err := resolveTrim(info, opts)
+	if err != nil {
+		return result, err
+	}
+	if opts.LoopDuration > 0 && info.Frames > 1 {
+		if opts.Loop, err = loopsFor(info, trim, opts); err != nil {
+			return result, err
+		}
+		slog.Debug("looping to -loop-to-duration", "loops", opts.Loop, "duration", opts.LoopDuration)
+	}
+	result.Duration = outputDuration(info, trim, opts).Seconds()
+	if natural := outputDuration(info, trim, opts); natural < opts.MinOutputDuration {
+		opts.padDuration = opts.MinOutputDuration - natural
+		result.Duration = opts.MinOutputDuration.Seconds()
+		slog.Debug("holding the last frame to reach -min-output-duration", "duration", natural, "pad", opts.padDuration)
+	}
+	if opts.trimmed() {
+		result.Frames = trim.Frames()
+		slog.Debug("trimming", "first_frame", trim.StartFrame, "last_frame", trim.EndFrame-1, "start", trim.Start, "end", trim.End)
+	}
+
+	if opts.TuneSource {
+		opts = tuneForSource(info, opts)
+		result.Bitrate = opts.Bitrate
+	}
+	if opts.MaxSize > 0 {
+		duration := outputDuration(info, trim, opts) + opts.padDuration
+		if duration <= 0 {
+			return result, fmt.Errorf("-maxsize needs the animation's duration, but %s has none", input)
+		}
+		opts.Bitrate = fmt.Sprintf("%dk", targetBitrate(opts.MaxSize, duration, opts))
+		// Two passes hit the bitrate much more closely
+		opts.TwoPass = true
+		result.Bitrate = opts.Bitrate
+		slog.Debug("bitrate for -maxsize", "bitrate", opts.Bitrate, "duration", duration)
+	}
+
+	// Fail before touching the output if the encoder isn't usable
+	enc, err := selectEncoder(opts)
+	if err != nil {
+		return result, err
+	}
+	if opts.TwoPass && !enc.TwoPass {
+		if opts.MaxSize == 0 {
+			return result, fmt.Errorf("-2pass is not supported by the %s encoder", enc.Name)
+		}
+		// -maxsize only turned it on to be more accurate
+		opts.TwoPass = false
+	}
+	if opts.CRF > 0 && enc.Quality == "" {
+		return result, fmt.Errorf("-crf is not supported by the %s encoder, use -b", enc.Name)
+	}
+	if opts.Lossless && enc.LosslessArgs == nil {
+		return result, fmt.Errorf("-lossless is not supported by the %s encoder, only by libx264 and libx265", enc.Name)
+	}
+	if opts.Tune != "" && enc.Name != softwareEncoder.Name && enc.Name != hevcEncoder.Name {
+		return result, fmt.Errorf("-tune only applies to libx264 and libx265, but %s is in use", enc.Name)
+	}
+	if (opts.Profile != "" || opts.Level != "") && !enc.Profile {
+		return result, fmt.Errorf("-profile and -level need an H.264 encoder, but %s is in use", enc.Name)
+	}
+	// Encoders fed through hwupload only list their hardware surface format
+	if opts.PixFmt != "" && enc.PixFmt != "" {
+		if err := checkPixFmt(enc, opts.PixFmt); err != nil {
+			return result, err
+		}
+	}
+
+	c, err := containerFor(output, opts)
+	if err != nil {
+		return result, err
+	}
+	if opts.Cover {
+		if err := checkCover(output, c); err != nil {
+			return result, err
+		}
+	}
+
+	final, overwrite := output, opts.Overwrite
+	pipe := nonSeekable(output)
+	if pipe && output != "-" {
+		// A named pipe is written in place, opening it is the point
+		opts.Overwrite = true
+	} else if output != "-" && !opts.DryRun {
+		if err := checkOutput(output, &opts); err != nil {
+			return result, err
+		}
+		overwrite = opts.Overwrite
+		// ffmpeg writes next to the output, which is only replaced once
+		// the result is complete
+		if output, err = partialOutput(final); err != nil {
+			return result, err
+		}
+		defer os.Remove(output)
+		opts.Overwrite = true
+	}
+
+	slog.Debug("inspected input", "input", input, "width", info.Width, "height", info.Height, "frames", info.Frames, "duration", info.Duration)
+
+	if opts.StreamFrames {
+		opts.Method = "go-stream"
+	} else if opts.Extractor == "go" {
+		opts.Method = "go-extract"
+	} else if (opts.Extractor != "" || opts.QualityMetric || opts.InputFPS > 0 || opts.SmoothTiming || result.ClampedFrames > 0 || opts.ladderFrames != nil) && opts.Method == "auto" {
+		// Choosing the tool skips the direct attempt, the quality is
+		// measured against extracted frames, ffmpeg's WebP demuxer
+		// keeps the frames' own timing, and renditions share the frames
+		opts.Method = "extract"
+	} else if result.ClampedFrames > 0 && opts.Method == "direct" {
+		slog.Warn("-frame-duration-cap doesn't apply to -method direct, which keeps the file's own timing", "input", input)
+	}
+
+	if opts.DryRun {
+		if len(opts.Concat) > 0 {
+			fmt.Printf("# %d files joined -> %s\n", len(opts.Concat), output)
+		} else if info.Frames < 2 {
+			fmt.Printf("# %s -> %s (still image, %s)\n", input, output, opts.StillDuration)
+		} else {
+			fmt.Printf("# %s -> %s (method %s)\n", input, output, opts.Method)
+			if _, extractFirst := autoExtractRate(info, opts); opts.Method == "auto" && extractFirst {
+				fmt.Println("# every frame lasts the same time, so frame extraction is tried first")
+			} else if opts.Method == "auto" && !opts.NoFallback {
+				fmt.Println("# direct conversion is tried first, frame extraction is the fallback")
+			}
+		}
+	}
+
+	if opts.FrameFunc != nil && (opts.Method == "auto" || opts.Method == "extract") {
+		// Only frames decoded in Go pass through the callback
+		opts.Method = "go-extract"
 	}
 
 	// Determine conversion method
-	if method == "auto" {
-		// Try direct conversion first, fall back to extraction if it fails
-		if err := convertDirectly(input, output, fps, bitrate, verbose); err != nil {
-			if verbose {
-				fmt.Printf("Direct conversion failed, trying frame extraction method: %v\n", err)
+	switch {
+	case len(opts.Concat) > 0:
+		result.Method = "concat"
+		err = convertConcat(output, info, trim, opts, &result)
+	case info.Frames < 2:
+		result.Method = "still"
+		err = convertStill(input, output, info, opts, &result)
+	case opts.StreamFrames:
+		result.Method = "go-stream"
+		err = convertStreaming(input, output, info, trim, opts, &result)
+	case opts.Method == "auto":
+		err = convertAuto(input, output, info, trim, opts, &result)
+	case opts.Method == "extract" || opts.Method == "go-extract":
+		result.Method = opts.Method
+		err = convertViaExtraction(input, output, info, trim, opts, &result)
+	case opts.Method == "direct":
+		result.Method = "direct"
+		err = convertDirectly(input, output, info, trim, opts, &result)
+	default:
+		// validate rejects these, a new method has to be added here
+		err = fmt.Errorf("unknown -method %q", opts.Method)
+	}
+
+	if errors.Is(err, ErrEncodeFailed) {
+		err = profileHint(err, opts)
+	}
+	if err == nil && !pipe && !opts.DryRun {
+		err = verifyOutput(output, result, opts)
+	}
+	if err == nil && opts.Cover {
+		err = embedCover(input, output, opts)
+	}
+	if err == nil && !pipe && !opts.DryRun {
+		if stat, statErr := os.Stat(output); statErr == nil {
+			result.Size = stat.Size()
+		}
+	}
+	if err == nil && opts.PreserveTimes && !pipe && !opts.DryRun {
+		err = copyModTime(input, output)
+	}
+	if err == nil && output != final {
+		err = commitOutput(output, final, overwrite)
+	}
+
+	result.Elapsed = time.Since(start).Seconds()
+	if err == nil {
+		slog.Debug("converted", "input", input, "method", result.Method)
+		slog.Debug("timings", "input", input, "inspect", seconds(result.Timings.Inspect), "extract", seconds(result.Timings.Extract), "encode", seconds(result.Timings.Encode))
+	}
+	return result, err
+}
+
+// convertAuto is -method auto for animations. When inspectWebP finds every
+// frame shown for the same time, extraction goes first, assembling them at
+// that rate, as the webp_pipe demuxer the direct method relies on
+// mishandles many animations, most often by silently decoding only the
+// first frame. Animations with varying frame times go direct first, as
+// ffmpeg reads their timing from the file itself, and extraction times
+// them through a concat list. Either way the other method is the
+// fallback. Single-frame inputs never get here, convertStill reads them
+// with ffmpeg directly.
+func convertAuto(input, output string, info WebPInfo, trim trimRange, opts Options, result *Result) error {
+	rate, extractFirst := autoExtractRate(info, opts)
+	if !extractFirst {
+		return convertDirectFirst(input, output, info, trim, opts, result)
+	}
+
+	extractOpts := opts
+	extractOpts.setInputFPS(rate)
+	result.Method = "extract"
+	err := convertViaExtraction(input, output, info, trim, extractOpts, result)
+	if err == nil || opts.NoFallback || errors.Is(err, ErrTimeout) {
+		if err != nil && opts.NoFallback {
+			err = fmt.Errorf("frame extraction (fallback disabled by -no-fallback): %w", err)
+		}
+		return err
+	}
+	slog.Debug("frame extraction failed, trying direct conversion", "err", err)
+	if err := prepareRetry(output, err); err != nil {
+		return err
+	}
+	result.Method = "direct"
+	if directErr := convertDirectly(input, output, info, trim, opts, result); directErr != nil {
+		// Both reasons matter, the extraction one is often the real cause
+		return errors.Join(fmt.Errorf("frame extraction: %w", err), fmt.Errorf("direct conversion: %w", directErr))
+	}
+	return nil
+}
+
+// autoExtractRate reports whether -method auto extracts the frames first,
+// along with the rate that keeps their timing, as an exact fraction such as
+// 100/7 for 70ms frames. Every frame has to last the same time, and
+// -input-fps and -interpolate already decide the rate.
+func autoExtractRate(info WebPInfo, opts Options) (string, bool) {
+	if opts.InputFPS > 0 || opts.Interpolate > 0 || len(info.FrameDurations) != info.Frames || info.Frames < 2 {
+		return "", false
+	}
+	d := info.FrameDurations[0]
+	for _, frame := range info.FrameDurations {
+		if frame != d || frame <= 0 {
+			return "", false
+		}
+	}
+	return durationRate(d), true
+}
+
+// convertDirectFirst tries a direct conversion and falls back to frame
+// extraction if it fails, or decodes the frames in Go if ffmpeg only
+// decoded the first one. A failed ffmpeg run whose output checks out
+// anyway is kept rather than redone.
+func convertDirectFirst(input, output string, info WebPInfo, trim trimRange, opts Options, result *Result) error {
+	result.Method = "direct"
+	err := convertDirectly(input, output, info, trim, opts, result)
+	if err == nil && singleFrameOutput(output, trim, opts) {
+		// Some builds' webp_pipe only decodes the first frame
+		err = fmt.Errorf("%w: ffmpeg decoded only the first of %d frames", ErrEncodeFailed, trim.Frames())
+		if !opts.NoFallback {
+			slog.Warn("ffmpeg's WebP demuxer only decoded the first frame, decoding the frames in Go instead", "input", input)
+			// The output is the one just written by the direct attempt
+			opts.Overwrite = true
+			if opts.canStream() {
+				result.Method = "go-stream"
+				err = convertStreaming(input, output, info, trim, opts, result)
+			} else {
+				result.Method, opts.Method = "go-extract", "go-extract"
+				err = convertViaExtraction(input, output, info, trim, opts, result)
 			}
-			return convertViaExtraction(input, output, fps, bitrate, verbose)
 		}
+	}
+	if err == nil || errors.Is(err, ErrTimeout) {
+		return err
+	}
+	if opts.NoFallback {
+		return fmt.Errorf("direct conversion (fallback disabled by -no-fallback): %w", err)
+	}
+	if usableOutput(output, *result, trim, opts, err) {
+		slog.Warn("ffmpeg reported an error, but the direct conversion's frames and duration check out, keeping it", "input", input, "err", err)
 		return nil
-	} else if method == "extract" {
-		return convertViaExtraction(input, output, fps, bitrate, verbose)
-	} else {
-		return convertDirectly(input, output, fps, bitrate, verbose)
 	}
+	if !extractionMayHelp(err) {
+		slog.Debug("direct conversion failed for a reason frame extraction won't fix, not falling back", "err", err)
+		return err
+	}
+	slog.Debug("direct conversion failed, trying frame extraction method", "err", err)
+	if err := prepareRetry(output, err); err != nil {
+		return err
+	}
+	result.Method = "extract"
+	if extractErr := convertViaExtraction(input, output, info, trim, opts, result); extractErr != nil {
+		// Both reasons matter, the direct one is often the real cause
+		return errors.Join(fmt.Errorf("direct conversion: %w", err), fmt.Errorf("frame extraction: %w", extractErr))
+	}
+	return nil
 }
 
-func convertViaExtraction(input, output string, fps int, bitrate string, verbose bool) error {
-	// Create temporary directory for frames
-	tempDir, err := ioutil.TempDir("", "webp2mp4_*")
+// extractionMayHelp reports whether frame extraction may succeed where a
+// direct conversion failed with err: ffmpeg couldn't decode the input, or
+// the direct method can't do what was asked and didn't run ffmpeg at all.
+// Other ffmpeg failures, a full disk among them, are reported as they are.
+func extractionMayHelp(err error) bool {
+	var exitErr *exec.ExitError
+	return errors.Is(err, ErrUnreadableInput) || !errors.As(err, &exitErr)
+}
+
+// prepareRetry returns why there can't be a fallback after the first
+// method failed with err, if there can't. A file output is the partial one
+// from convertOnce, which the fallback simply writes over.
+func prepareRetry(output string, err error) error {
+	// Retrying would append a second stream to what was already sent
+	if output == "-" && streamOut.n > 0 {
+		return fmt.Errorf("conversion failed after writing to stdout: %w", err)
+	}
+	if nonSeekable(output) {
+		// Whatever reached the reader can't be taken back either
+		return fmt.Errorf("conversion failed while writing to the pipe %s: %w", output, err)
+	}
+	return nil
+}
+
+// usableOutput reports whether output is a complete conversion even though
+// ffmpeg exited with err, as it does for some warnings it treats as
+// errors. The output has to have more than one frame, if the source does,
+// and pass the -verify checks. Failures that aren't ffmpeg's exit status,
+// such as a full disk, never count.
+func usableOutput(output string, result Result, trim trimRange, opts Options, err error) bool {
+	if opts.DryRun || nonSeekable(output) || !retryable(err) {
+		return false
+	}
+	if trim.Frames() > 1 {
+		if frames, err := ffprobeFrames(output); err != nil || frames < 2 {
+			return false
+		}
+	}
+	opts.Verify = true
+	return verifyOutput(output, result, opts) == nil
+}
+
+// singleFrameOutput reports whether a direct conversion of several frames
+// produced a video of just one, which happens with ffmpeg builds whose
+// webp_pipe demuxer doesn't understand animations. Outputs that can't be
+// probed, such as pipes, are assumed to be fine.
+func singleFrameOutput(output string, trim trimRange, opts Options) bool {
+	if opts.DryRun || nonSeekable(output) || trim.Frames() < 2 {
+		return false
+	}
+	frames, err := ffprobeFrames(output)
 	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
+		slog.Debug("could not count the frames of the direct conversion", "err", err)
+		return false
 	}
-	defer os.RemoveAll(tempDir)
+	return frames == 1
+}
 
-	if verbose {
-		fmt.Printf("Extracting frames to: %s\n", tempDir)
+// copyModTime gives output the modification time of input. The access time
+// is set to the same value, as there is no portable way to read it.
+func copyModTime(input, output string) error {
+	info, err := os.Stat(input)
+	if err != nil {
+		return fmt.Errorf("failed to preserve times: %w", err)
+	}
+	if err := os.Chtimes(output, info.ModTime(), info.ModTime()); err != nil {
+		return fmt.Errorf("failed to preserve times: %w", err)
 	}
+	return nil
+}
 
-	// Extract frames using webpmux
-	framePattern := filepath.Join(tempDir, "frame_%03d.png")
-	extractCmd := exec.Command("webpmux", "-get", "frame", "0", input, "-o", "-")
+// frameDir returns the directory frames are extracted into: the
+// -keep-frames directory, or a new temp directory that cleanup removes,
+// unless the conversion failed and -keep-temp-on-failure is set.
+func frameDir(opts Options) (dir string, cleanup func(failed bool), err error) {
+	if opts.KeepFrames != "" {
+		if !opts.DryRun {
+			if err := prepareFrameDir(opts.KeepFrames, opts.Overwrite); err != nil {
+				return "", nil, err
+			}
+		}
+		return opts.KeepFrames, func(bool) {}, nil
+	}
 
-	// Try alternative extraction method using ffmpeg to extract frames
-	extractArgs := []string{
-		"-i", input,
-		"-vsync", "0",
-		framePattern,
+	// Create temporary directory for frames
+	dir, err = ioutil.TempDir(opts.TempDir, "webp2mp4_*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp directory: %w", err)
 	}
+	slog.Debug("created temp directory", "dir", dir)
+	return dir, func(failed bool) {
+		if failed && opts.KeepTempOnFailure {
+			slog.Warn("conversion with extracted frames failed, keeping their temp directory for inspection", "dir", dir)
+			return
+		}
+		os.RemoveAll(dir)
+	}, nil
+}
 
-	extractCmd = exec.Command("ffmpeg", extractArgs...)
-	if verbose {
-		extractCmd.Stdout = os.Stdout
-		extractCmd.Stderr = os.Stderr
-		fmt.Printf("Extracting frames: ffmpeg %s\n", strings.Join(extractArgs, " "))
+func convertViaExtraction(input, output string, info WebPInfo, trim trimRange, opts Options, result *Result) (err error) {
+	if shared := opts.ladderFrames; shared != nil && shared.frames != nil {
+		// An earlier -ladder rendition extracted them
+		return encodeFrameFiles(shared.frames, shared.dir, shared.width, shared.height, shared.durations, output, trim, opts, result)
+	}
+	tempDir, cleanup, err := frameDir(opts)
+	if err != nil {
+		return err
+	}
+	if opts.ladderFrames != nil {
+		// Kept for the other renditions until they are all done
+		opts.ladderFrames.cleanup = cleanup
+	} else {
+		defer func() { cleanup(err != nil) }()
 	}
 
-	if err := extractCmd.Run(); err != nil {
-		// If frame extraction fails, try using imagemagick as fallback
-		if verbose {
-			fmt.Println("FFmpeg extraction failed, trying ImageMagick...")
+	slog.Debug("extracting frames", "dir", tempDir)
+
+	pattern := framePattern(tempDir, opts)
+	var frames []string
+	width, height := info.Width, info.Height
+	if opts.DryRun {
+		// Nothing is extracted, so assume one file per animation frame
+		if opts.Method == "go-extract" {
+			fmt.Printf("# frames are decoded in Go into %s\n", tempDir)
+		} else if opts.Extractor == "imagemagick" || opts.Extractor == "webpmux" {
+			fmt.Printf("# frames are extracted with %s into %s\n", opts.Extractor, tempDir)
+		} else {
+			printCommand(ffmpegPath, frameExtractArgs(input, pattern, opts))
 		}
-		convertCmd := exec.Command("convert", input, "-coalesce", framePattern)
-		if err := convertCmd.Run(); err != nil {
-			return fmt.Errorf("failed to extract frames: %w", err)
+		for i := 1; i <= info.Frames; i++ {
+			frames = append(frames, fmt.Sprintf(pattern, i))
+		}
+	} else {
+		extractStart := time.Now()
+		if opts.Method == "go-extract" {
+			slog.Debug("decoding frames in Go")
+			if err := extractFramesGo(input, tempDir, opts); err != nil {
+				return fmt.Errorf("failed to extract frames: %w", err)
+			}
+		} else if err := extractFrames(input, pattern, opts); err != nil {
+			return err
+		}
+
+		// Check if we got any frames
+		frames, err = listFrames(tempDir)
+		if err != nil || len(frames) == 0 {
+			return ErrNoFrames
 		}
+
+		slog.Debug("extracted frames", "count", len(frames))
+
+		// Get dimensions from first frame
+		width, height, err = getFrameDimensions(frames[0])
+		if err != nil {
+			return fmt.Errorf("failed to get frame dimensions: %w", err)
+		}
+		if err := normalizeFrames(frames, width, height, opts.FrameQuality); err != nil {
+			return err
+		}
+		result.Timings.Extract = time.Since(extractStart).Seconds()
 	}
 
-	// Check if we got any frames
-	frames, err := filepath.Glob(filepath.Join(tempDir, "frame_*.png"))
-	if err != nil || len(frames) == 0 {
-		return fmt.Errorf("no frames extracted from WebP")
+	durations := info.FrameDurations
+	if opts.Extractor == "webpmux" && !opts.DryRun {
+		if timing, err := webpmuxDurations(input, opts); err != nil {
+			slog.Debug("could not read the frame timing with webpmux, using the parsed container's", "err", err)
+		} else {
+			durations, _ = capDurations(timing, opts.FrameDurationCap)
+		}
+	}
+	if opts.ladderFrames != nil {
+		*opts.ladderFrames = ladderFrames{dir: tempDir, frames: frames, durations: durations, width: width, height: height, cleanup: cleanup}
 	}
+	return encodeFrameFiles(frames, tempDir, width, height, durations, output, trim, opts, result)
+}
+
+// encodeFrameFiles encodes the width x height frames extracted into tempDir,
+// which are named after frameName, into output. durations are the frames'
+// own display times, which only -smooth-timing uses.
+func encodeFrameFiles(frames []string, tempDir string, width, height int, durations []time.Duration, output string, trim trimRange, opts Options, result *Result) error {
+	// Apply the requested size, adjusted to be even (required for h264)
+	filters, adjustedWidth, adjustedHeight := scaleFilters(width, height, opts)
+	result.OutputWidth, result.OutputHeight = adjustedWidth, adjustedHeight
+	geometry := filters[:len(filters):len(filters)]
+	filters = append(filters, rateFilters(opts)...)
+	filters = append(filters, interpolateFilters(result.SourceFPS, opts)...)
 
-	if verbose {
-		fmt.Printf("Extracted %d frames\n", len(frames))
+	slog.Debug("frame dimensions", "width", width, "height", height)
+	if adjustedWidth != width || adjustedHeight != height {
+		slog.Debug("output dimensions", "width", adjustedWidth, "height", adjustedHeight)
 	}
 
-	// Get dimensions from first frame
-	firstFrame := frames[0]
-	width, height, err := getPNGDimensions(firstFrame)
+	enc, err := selectEncoder(opts)
 	if err != nil {
-		return fmt.Errorf("failed to get frame dimensions: %w", err)
+		return err
 	}
 
-	// Adjust dimensions to be even (required for h264)
-	adjustedWidth := makeEven(width)
-	adjustedHeight := makeEven(height)
-
-	if verbose {
-		fmt.Printf("Frame dimensions: %dx%d\n", width, height)
-		if adjustedWidth != width || adjustedHeight != height {
-			fmt.Printf("Adjusted dimensions: %dx%d (made even for h264 compatibility)\n", adjustedWidth, adjustedHeight)
+	// Select the trimmed subset of the extracted frames
+	selected := frames
+	if opts.trimmed() {
+		if trim.EndFrame > len(frames) {
+			return fmt.Errorf("only %d frames were extracted, but the range ends at frame %d", len(frames), trim.EndFrame-1)
+		}
+		selected = frames[trim.StartFrame:trim.EndFrame]
+	}
+	// Without -input-fps the frames keep the durations the source gives
+	// them, as long as each extracted frame is one of its frames
+	timed := opts.InputFPS <= 0 && len(durations) == len(frames)
+	entries := make([]concatEntry, len(selected))
+	for i, frame := range selected {
+		entries[i] = concatEntry{File: frame, Duration: time.Duration(float64(time.Second) / opts.inputRate())}
+		if timed {
+			entries[i].Duration = durations[i+trim.StartFrame]
 		}
 	}
+	entries = playOrder(entries, opts)
+	encodeFrames := opts.playbackFrames(len(entries) * (opts.Loop + 1))
+	entries = stepEntries(entries, opts.step())
+	rate := opts.outputRate()
+	switch {
+	case opts.SmoothTiming && timed:
+		var ticks int
+		rate, entries, ticks = smoothTiming(entries, opts.Speed)
+		encodeFrames = ticks * (opts.Loop + 1)
+		result.FPS, _ = parseFrameRate(rate)
+		slog.Debug("smoothed frame timing", "fps", rate)
+	case timed:
+		var total time.Duration
+		for _, entry := range entries {
+			total += entry.Duration
+		}
+		encodeFrames = int(math.Round(opts.playback(total).Seconds()*opts.outputFPS())) * (opts.Loop + 1)
+	}
 
 	// Build ffmpeg command to create video from frames
-	args := []string{
-		"-framerate", fmt.Sprintf("%d", fps),
-		"-i", filepath.Join(tempDir, "frame_%03d.png"),
-		"-c:v", "libx264",
-		"-pix_fmt", "yuv420p",
-		"-b:v", bitrate,
+	args := append([]string{}, enc.InputArgs...)
+	if opts.Loop > 0 || opts.Reverse || opts.Boomerang || opts.step() > 1 || timed {
+		// The frames are timed, reordered, repeated or thinned out through
+		// a concat list
+		listPath := filepath.Join(tempDir, "frames.ffconcat")
+		if err := writeConcatList(listPath, repeatEntries(entries, opts.Loop+1)); err != nil {
+			return err
+		}
+		args = append(args,
+			"-f", "concat", "-safe", "0",
+			"-i", ffmpegFile(listPath),
+			"-r", rate,
+		)
+	} else {
+		args = append(args, "-framerate", opts.inputRateArg())
+		if start := firstFrameNumber(frames) + trim.StartFrame; start != 1 {
+			// image2 only probes a few numbers from 0 for the first frame
+			args = append(args, "-start_number", strconv.Itoa(start))
+		}
+		args = append(args, "-i", ffmpegFile(framePattern(tempDir, opts)))
+		if opts.Speed != 1 {
+			// Resample the retimed frames back to the output frame rate
+			args = append(args, "-r", opts.outputRate())
+		}
+		if opts.trimmed() {
+			args = append(args, "-frames:v", strconv.Itoa(encodeFrames))
+		}
 	}
 
-	// Add scaling filter if dimensions need adjustment
-	if adjustedWidth != width || adjustedHeight != height {
-		scaleFilter := fmt.Sprintf("scale=%d:%d:flags=lanczos", adjustedWidth, adjustedHeight)
-		args = append(args, "-vf", scaleFilter)
+	encodeStart := time.Now()
+	err = runEncode(args, encodeArgs(enc, filters, opts), output, opts, encodeFrames)
+	result.Timings.Encode += time.Since(encodeStart).Seconds()
+	if err != nil {
+		return fmt.Errorf("%w to create video: %w", ErrEncodeFailed, err)
+	}
+	if opts.QualityMetric {
+		if err := measureQuality(args, geometry, output, opts, result); err != nil {
+			return err
+		}
 	}
 
-	// Add output options
+	if opts.KeepFrames != "" && !opts.DryRun {
+		slog.Info("kept extracted frames", "dir", tempDir, "count", len(frames))
+	}
+	return nil
+}
+
+// extractFrames dumps every frame of input to framePattern with the
+// -extractor tool. Without one, ffmpeg is tried first, then ImageMagick,
+// which can read some files ffmpeg can't. The frame timing comes from
+// inspectWebP either way, so no tool needs to report it.
+func extractFrames(input, framePattern string, opts Options) error {
+	switch opts.Extractor {
+	case "ffmpeg":
+		if err := extractWithFFmpeg(input, framePattern, opts); err != nil {
+			return fmt.Errorf("failed to extract frames: %w", err)
+		}
+		return nil
+	case "imagemagick":
+		if err := extractWithImageMagick(input, framePattern, opts); err != nil {
+			return fmt.Errorf("failed to extract frames: %w", err)
+		}
+		return nil
+	case "webpmux":
+		if err := extractFramesWebpmux(input, filepath.Dir(framePattern), opts); err != nil {
+			return fmt.Errorf("failed to extract frames: %w", err)
+		}
+		return nil
+	}
+
+	if err := extractWithFFmpeg(input, framePattern, opts); err != nil {
+		if errors.Is(err, ErrTimeout) {
+			return err
+		}
+		// If frame extraction fails, try using imagemagick as fallback
+		slog.Debug("ffmpeg extraction failed, trying ImageMagick", "err", err)
+		if _, _, lookErr := imageMagickCmd(); lookErr != nil {
+			return fmt.Errorf("failed to extract frames: %w (%v)", err, lookErr)
+		}
+		if err := extractWithImageMagick(input, framePattern, opts); err != nil {
+			return fmt.Errorf("failed to extract frames: %w", err)
+		}
+	}
+	return nil
+}
+
+// extractWithFFmpeg dumps the frames of input to framePattern with ffmpeg.
+func extractWithFFmpeg(input, framePattern string, opts Options) error {
+	extractArgs := frameExtractArgs(input, framePattern, opts)
+
+	extractCmd, cancel := deadlineCommand(opts, ffmpegPath, extractArgs...)
+	defer cancel()
+	if opts.Verbose {
+		extractCmd.Stdout = os.Stderr
+		extractCmd.Stderr = os.Stderr
+		slog.Debug("extracting frames", "cmd", commandLine(ffmpegPath, extractArgs))
+	}
+	return checkDeadline(runCommand(extractCmd, opts), opts)
+}
+
+// extractWithImageMagick dumps the coalesced frames of input to
+// framePattern with ImageMagick.
+func extractWithImageMagick(input, framePattern string, opts Options) error {
+	bin, prefix, err := imageMagickCmd()
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(input, "-") {
+		// ImageMagick would parse it as an option
+		input = "./" + input
+	}
+	args := append(prefix, input, "-coalesce")
+	if opts.FrameFormat == "jpg" {
+		args = append(args, "-quality", strconv.Itoa(opts.FrameQuality))
+	}
+	convertCmd, cancel := deadlineCommand(opts, bin, append(args, framePattern)...)
+	defer cancel()
+	return checkDeadline(runCommand(convertCmd, opts), opts)
+}
+
+// convertStill turns a single-image WebP into a clip that shows it for
+// -stillduration.
+func convertStill(input, output string, info WebPInfo, opts Options, result *Result) error {
+	enc, err := selectEncoder(opts)
+	if err != nil {
+		return err
+	}
+
+	filters, adjustedWidth, adjustedHeight := scaleFilters(info.Width, info.Height, opts)
+	result.OutputWidth, result.OutputHeight = adjustedWidth, adjustedHeight
+
+	args := append([]string{}, enc.InputArgs...)
 	args = append(args,
-		"-preset", "medium",
-		"-movflags", "+faststart",
-		"-y", // Overwrite output file
-		output,
+		"-loop", "1",
+		"-framerate", opts.fpsArg(),
+		"-i", ffmpegFile(input),
+		"-t", ffmpegSeconds(opts.StillDuration),
 	)
 
-	// Execute ffmpeg
-	cmd := exec.Command("ffmpeg", args...)
+	totalFrames := int(opts.StillDuration.Seconds() * opts.FPS)
+	encodeStart := time.Now()
+	err = runEncode(args, encodeArgs(enc, filters, opts), output, opts, totalFrames)
+	result.Timings.Encode += time.Since(encodeStart).Seconds()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrEncodeFailed, err)
+	}
+	return nil
+}
+
+// reverseBufferWarning is the estimated decoded size above which -reverse
+// and -boomerang warn about memory use on the direct path.
+const reverseBufferWarning = 1 << 30
 
-	if verbose {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		fmt.Printf("Creating video: ffmpeg %s\n", strings.Join(args, " "))
+// reverseFilters returns the filters that play the input backwards, or
+// forwards then backwards for -boomerang. ffmpeg's reverse filter holds every
+// decoded frame in memory, so a warning is logged for large animations.
+func reverseFilters(info WebPInfo, opts Options) []string {
+	size := int64(info.Width) * int64(info.Height) * 4 * int64(info.Frames) * int64(opts.Loop+1)
+	if size > reverseBufferWarning {
+		slog.Warn("reversing buffers every frame in memory, the extraction method avoids this", "estimated_mb", size>>20)
 	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ffmpeg failed to create video: %w", err)
+	if opts.Reverse {
+		return []string{"reverse"}
 	}
+	// The first reversed frame repeats the last forward one
+	return []string{"split[fwd][rev];[rev]reverse,trim=start_frame=1[back];[fwd][back]concat=n=2:v=1:a=0"}
+}
 
-	return nil
+// frameExtractArgs returns the ffmpeg arguments that write every frame of
+// input to framePattern.
+func frameExtractArgs(input, framePattern string, opts Options) []string {
+	args := append(append([]string{}, opts.GlobalArgs...), "-i", ffmpegFile(input), "-vsync", "0")
+	if opts.FrameFormat == "jpg" {
+		args = append(args, "-q:v", strconv.Itoa(jpegQScale(opts.FrameQuality)))
+	}
+	return append(args, ffmpegFile(framePattern))
 }
 
-func convertDirectly(input, output string, fps int, bitrate string, verbose bool) error {
+func convertDirectly(input, output string, info WebPInfo, trim trimRange, opts Options, result *Result) error {
 	// Get dimensions and adjust if needed
 	width, height, err := getWebPDimensions(input)
+	if err != nil {
+		// Some animated variants only ffmpeg can read
+		slog.Debug("failed to decode WebP config, asking ffprobe", "err", err)
+		width, height, err = ffprobeDimensions(input)
+	}
 	if err != nil {
 		// If we can't get dimensions, try without pre-checking
+		slog.Debug("failed to get dimensions", "err", err)
 		width, height = 0, 0
 	}
 
+	enc, err := selectEncoder(opts)
+	if err != nil {
+		return err
+	}
+
+	// Output-side -ss/-t would cut across the repeats rather than
+	// trimming each one
+	if opts.Loop > 0 && opts.trimmed() {
+		return fmt.Errorf("-loop with trimming is only supported by the extraction method")
+	}
+	// The same goes for reversing, and -stream_loop would loop the input
+	// rather than the boomerang
+	if (opts.Reverse || opts.Boomerang) && opts.trimmed() {
+		return fmt.Errorf("-reverse and -boomerang with trimming are only supported by the extraction method")
+	}
+	if opts.Boomerang && opts.Loop > 0 {
+		return fmt.Errorf("-boomerang with -loop is only supported by the extraction method")
+	}
+
 	// Build ffmpeg command with special flags for animated WebP
-	args := []string{
+	args := append([]string{}, enc.InputArgs...)
+	if opts.Loop > 0 {
+		args = append(args, "-stream_loop", strconv.Itoa(opts.Loop))
+	}
+	args = append(args,
 		"-f", "webp_pipe",
-		"-i", input,
-		"-c:v", "libx264",
-		"-pix_fmt", "yuv420p",
-		"-r", fmt.Sprintf("%d", fps),
-		"-b:v", bitrate,
+		"-i", ffmpegFile(input),
+		"-r", opts.outputRate(),
+	)
+	if opts.trimmed() {
+		// Output-side trimming applies after -speed has retimed the frames
+		args = append(args, "-ss", ffmpegSeconds(opts.playback(trim.Start)), "-t", ffmpegSeconds(opts.playback(trim.Duration())))
 	}
 
-	// Add scaling filter if we know dimensions need adjustment
-	if width > 0 && height > 0 {
-		adjustedWidth := makeEven(width)
-		adjustedHeight := makeEven(height)
+	// Apply the requested size, adjusted to be even (required for h264)
+	filters, adjustedWidth, adjustedHeight := scaleFilters(width, height, opts)
+	result.OutputWidth, result.OutputHeight = adjustedWidth, adjustedHeight
+	filters = append(filters, interpolateFilters(result.SourceFPS, opts)...)
 
-		if verbose {
-			fmt.Printf("Original dimensions: %dx%d\n", width, height)
-			if adjustedWidth != width || adjustedHeight != height {
-				fmt.Printf("Adjusted dimensions: %dx%d (made even for h264 compatibility)\n", adjustedWidth, adjustedHeight)
-			}
-		}
+	if opts.Reverse || opts.Boomerang {
+		filters = append(reverseFilters(info, opts), filters...)
+	}
+	if step := opts.step(); step > 1 {
+		// The kept frames keep their timestamps, so each lasts until the
+		// next one
+		filters = append([]string{fmt.Sprintf("select='not(mod(n,%d))'", step)}, filters...)
+	}
 
+	if width > 0 && height > 0 {
+		slog.Debug("original dimensions", "width", width, "height", height)
 		if adjustedWidth != width || adjustedHeight != height {
-			scaleFilter := fmt.Sprintf("scale=%d:%d:flags=lanczos", adjustedWidth, adjustedHeight)
-			args = append(args, "-vf", scaleFilter)
+			slog.Debug("output dimensions", "width", adjustedWidth, "height", adjustedHeight)
 		}
-	} else {
-		// If we don't know dimensions, use a filter to ensure even dimensions
-		args = append(args, "-vf", "scale='trunc(iw/2)*2:trunc(ih/2)*2'")
 	}
 
-	// Add output options
-	args = append(args,
-		"-preset", "medium",
-		"-movflags", "+faststart",
-		"-y", // Overwrite output file
-		output,
-	)
-
-	// Execute ffmpeg
-	cmd := exec.Command("ffmpeg", args...)
-
-	if verbose {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		fmt.Printf("Running command: ffmpeg %s\n", strings.Join(args, " "))
-	} else {
-		// Capture output to check for errors
-		cmdOutput, err := cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("ffmpeg failed: %w\nOutput: %s", err, string(cmdOutput))
-		}
+	// Estimate the number of output frames for progress reporting
+	totalFrames := int(opts.playback(trim.Duration()).Seconds()*opts.outputFPS()) * (opts.Loop + 1)
+	if opts.Boomerang {
+		totalFrames *= 2
 	}
 
-	if err := cmd.Run(); err != nil && !verbose {
-		return fmt.Errorf("ffmpeg failed: %w", err)
+	encodeStart := time.Now()
+	err = runEncode(args, encodeArgs(enc, filters, opts), output, opts, totalFrames)
+	result.Timings.Encode += time.Since(encodeStart).Seconds()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrEncodeFailed, err)
 	}
 
 	return nil
@@ -266,7 +1656,9 @@ func getWebPDimensions(filename string) (int, int, error) {
 	return config.Width, config.Height, nil
 }
 
-func getPNGDimensions(filename string) (int, int, error) {
+// getFrameDimensions reads the size of an extracted PNG or JPEG frame from
+// its header.
+func getFrameDimensions(filename string) (int, int, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return 0, 0, err
@@ -288,22 +1680,29 @@ func makeEven(n int) int {
 	return n
 }
 
-func checkDependencies() error {
+// CheckDependencies checks that ffmpeg is installed and warns if
+// ImageMagick isn't. With -strict-deps, a missing ImageMagick or webpmux
+// that the chosen method may need is an error instead. main calls it
+// before any work; callers of ConvertBatch can do the same.
+func CheckDependencies(opts Options) error {
 	// Check if ffmpeg is installed
-	if _, err := exec.LookPath("ffmpeg"); err != nil {
-		return fmt.Errorf("ffmpeg is not installed or not in PATH")
+	if _, err := exec.LookPath(ffmpegPath); err != nil {
+		return ErrFFmpegMissing
 	}
+	if opts.StrictDeps {
+		if _, _, err := imageMagickCmd(); err != nil && opts.mayUseImageMagick() {
+			return fmt.Errorf("%w: ImageMagick (magick or convert), which -method %s may extract frames with (use -extractor ffmpeg or -method go-extract to do without it)", ErrToolMissing, opts.Method)
+		}
+		if _, err := exec.LookPath("webpmux"); err != nil && opts.Extractor == "webpmux" {
+			return fmt.Errorf("%w: webpmux, which -extractor webpmux needs", ErrToolMissing)
+		}
+	}
+	// Probe what ffmpeg can do while the input is read, the first encoder
+	// lookup waits for it
+	go ffmpegCapabilities()
 	// Optional: check for imagemagick (convert command) for fallback
-	if _, err := exec.LookPath("convert"); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: ImageMagick (convert) not found. Some animated WebP files might not convert properly.\n")
+	if _, _, err := imageMagickCmd(); err != nil {
+		slog.Warn("ImageMagick (magick or convert) not found, some animated WebP files might not convert properly")
 	}
 	return nil
 }
-
-func init() {
-	if err := checkDependencies(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		fmt.Fprintf(os.Stderr, "Please install ffmpeg first.\n")
-		os.Exit(1)
-	}
-}
\ No newline at end of file
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeFFmpegScript stands in for ffmpeg. Every run other than the
+// capability probes appends its arguments to calls, one per line and
+// followed by callEnd. A run whose arguments contain the text of fail_match
+// prints fail_stderr and exits 1, and with sleep set every run hangs for
+// that many seconds first. A run reading pipe:0 saves its stdin as stdin.
+// Frame extraction writes frame.png as frame_count frames numbered from
+// frame_start, anything else writes its last argument.
+const fakeFFmpegScript = `#!/bin/sh
+dir='%DIR%'
+case "$1" in -hide_banner)
+	case "$2" in
+	-encoders) printf 'Encoders:\n V..... = Video\n ------\n V....D libx264  H.264\n V....D libx265  H.265\n V....D libvpx-vp9  VP9\n V....D h264_vaapi  VAAPI\n V....D h264_nvenc  NVENC\n';;
+	-muxers) printf 'File formats:\n  E = Muxing supported\n --\n  E mp4  MP4\n  E mov  MOV\n  E matroska  Matroska\n  E webm  WebM\n';;
+	-h) printf 'Encoder %s:\n    Supported pixel formats: yuv420p yuv422p yuv444p\n' "$3";;
+	esac
+	exit 0;;
+esac
+for a; do printf '%s\n' "$a"; done >> "$dir/calls"
+echo '` + callEnd + `' >> "$dir/calls"
+if [ -f "$dir/sleep" ]; then sleep "$(cat "$dir/sleep")"; fi
+case " $* " in *" pipe:0 "*) cat > "$dir/stdin";; esac
+if [ -f "$dir/fail_match" ] && printf '%s\n' "$@" | grep -qF -- "$(cat "$dir/fail_match")"; then
+	cat "$dir/fail_stderr" >&2
+	exit 1
+fi
+for last; do :; done
+last=${last#file:}
+case "$last" in
+*%0*)
+	n=$(cat "$dir/frame_count" 2>/dev/null || echo 3)
+	i=$(cat "$dir/frame_start" 2>/dev/null || echo 1)
+	end=$((i + n))
+	while [ "$i" -lt "$end" ]; do cp "$dir/frame.png" "$(printf "$last" "$i")"; i=$((i + 1)); done;;
+pipe:1) echo video;;
+*) echo video > "$last";;
+esac
+`
+
+// fakeFFprobeScript answers the frame count and size queries with
+// probe_frames and probe_size, and describes anything else as video.
+const fakeFFprobeScript = `#!/bin/sh
+dir='%DIR%'
+case "$*" in
+*-count_packets*) cat "$dir/probe_frames" 2>/dev/null || echo 3;;
+*stream=width,height*) cat "$dir/probe_size" 2>/dev/null || echo 8x8;;
+*) printf 'codec_type=video\nduration=0.4\n';;
+esac
+`
+
+// callEnd separates the runs logged by the fake ffmpeg.
+const callEnd = "--end--"
+
+// fakeFFmpeg is a fake ffmpeg and ffprobe in a test's temp directory.
+type fakeFFmpeg struct {
+	t   *testing.T
+	dir string
+}
+
+// newFakeFFmpeg points ffmpegPath at a fake ffmpeg for the rest of t.
+// Extracted frames are width x height.
+func newFakeFFmpeg(t *testing.T, width, height int) *fakeFFmpeg {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+	f := &fakeFFmpeg{t: t, dir: t.TempDir()}
+	for name, script := range map[string]string{"ffmpeg": fakeFFmpegScript, "ffprobe": fakeFFprobeScript} {
+		script = strings.ReplaceAll(script, "%DIR%", f.dir)
+		if err := os.WriteFile(filepath.Join(f.dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestPNG(t, filepath.Join(f.dir, "frame.png"), width, height)
+
+	saved := ffmpegPath
+	ffmpegPath = filepath.Join(f.dir, "ffmpeg")
+	t.Cleanup(func() { ffmpegPath = saved })
+	return f
+}
+
+// set writes name, one of the files the fake scripts read, as value.
+func (f *fakeFFmpeg) set(name, value string) {
+	f.t.Helper()
+	if err := os.WriteFile(filepath.Join(f.dir, name), []byte(value), 0o644); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// failOn makes the runs whose arguments contain match fail with stderr.
+func (f *fakeFFmpeg) failOn(match, stderr string) {
+	f.set("fail_match", match)
+	f.set("fail_stderr", stderr)
+}
+
+// calls returns the arguments of every run so far.
+func (f *fakeFFmpeg) calls() [][]string {
+	f.t.Helper()
+	data, err := os.ReadFile(filepath.Join(f.dir, "calls"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	var calls [][]string
+	var args []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == callEnd {
+			calls = append(calls, args)
+			args = nil
+			continue
+		}
+		args = append(args, line)
+	}
+	return calls
+}
+
+// testOptions returns the options the flags default to.
+func testOptions() Options {
+	return Options{
+		FPS:                30,
+		Bitrate:            "2M",
+		Method:             "auto",
+		Format:             "video",
+		Codec:              "h264",
+		Scaler:             "lanczos",
+		Speed:              1,
+		FrameStep:          1,
+		FrameFormat:        "png",
+		FrameQuality:       90,
+		EndFrame:           -1,
+		BFrames:            -1,
+		Refs:               -1,
+		ErrorLines:         10,
+		FastStart:          true,
+		PadColor:           "black",
+		WatermarkPos:       "bottom-right",
+		WatermarkOpacity:   1,
+		AudioFit:           "loop",
+		WebPQuality:        75,
+		InterpolateMode:    "mci",
+		DenoiseStrength:    4,
+		SharpenAmount:      1,
+		RotateFromMetadata: true,
+		Overwrite:          true,
+		Quiet:              true,
+	}
+}
+
+// writeTestWebP writes an animated width x height WebP to path with one
+// solid lossless frame per duration, in milliseconds.
+func writeTestWebP(t *testing.T, path string, width, height int, durations ...int) {
+	t.Helper()
+	frames := make([]testFrame, len(durations))
+	for i, ms := range durations {
+		frames[i] = testFrame{Rect: image.Rect(0, 0, width, height), Color: color.NRGBA{R: uint8(40 * i), G: 0x80, B: 0xc0, A: 0xff}, Duration: ms}
+	}
+	writeTestFrames(t, path, width, height, frames...)
+}
+
+// testFrame is a solid frame of an animation written by writeTestFrames.
+// Rect must start at even coordinates.
+type testFrame struct {
+	Rect           image.Rectangle
+	Color          color.NRGBA
+	Duration       int
+	Blend, Dispose bool
+}
+
+// writeTestFrames writes an animated width x height WebP of frames to path.
+func writeTestFrames(t *testing.T, path string, width, height int, frames ...testFrame) {
+	t.Helper()
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02 // animation
+	putLE24(vp8x[4:], width-1)
+	putLE24(vp8x[7:], height-1)
+	writeChunk(&body, "VP8X", vp8x)
+	writeChunk(&body, "ANIM", make([]byte, 6))
+	for _, f := range frames {
+		header := make([]byte, 16)
+		putLE24(header[0:], f.Rect.Min.X/2)
+		putLE24(header[3:], f.Rect.Min.Y/2)
+		putLE24(header[6:], f.Rect.Dx()-1)
+		putLE24(header[9:], f.Rect.Dy()-1)
+		putLE24(header[12:], f.Duration)
+		if !f.Blend {
+			header[15] |= 0x02
+		}
+		if f.Dispose {
+			header[15] |= 0x01
+		}
+		var payload bytes.Buffer
+		writeChunk(&payload, "VP8L", solidVP8L(f.Rect.Dx(), f.Rect.Dy(), f.Color))
+		writeChunk(&body, "ANMF", append(header, payload.Bytes()...))
+	}
+	var riff bytes.Buffer
+	riff.WriteString("RIFF")
+	binary.Write(&riff, binary.LittleEndian, uint32(body.Len()))
+	riff.Write(body.Bytes())
+	if err := os.WriteFile(path, riff.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestPNG writes a width x height grey PNG to path.
+func writeTestPNG(t *testing.T, path string, width, height int) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// inspectTest returns the info and untrimmed range of input.
+func inspectTest(t *testing.T, input string, opts Options) (WebPInfo, trimRange) {
+	t.Helper()
+	info, err := inspectWebP(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trim, err := resolveTrim(info, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info, trim
+}
+
+// assertArgs fails t unless got is want.
+func assertArgs(t *testing.T, got, want []string) {
+	t.Helper()
+	if strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+		t.Errorf("arguments differ\n got: %q\nwant: %q", got, want)
+	}
+}
+
+func TestRunFFmpegQuietSuccess(t *testing.T) {
+	fake := newFakeFFmpeg(t, 8, 8)
+	output := filepath.Join(t.TempDir(), "out.mp4")
+
+	if err := runFFmpeg([]string{"-i", "in.webp", output}, testOptions(), 0); err != nil {
+		t.Fatalf("runFFmpeg: %v", err)
+	}
+	// A command can only run once, a second run would have failed
+	if calls := fake.calls(); len(calls) != 1 {
+		t.Fatalf("ffmpeg ran %d times, want once", len(calls))
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Errorf("output not written: %v", err)
+	}
+}
+
+func TestRunFFmpegQuietFailure(t *testing.T) {
+	fake := newFakeFFmpeg(t, 8, 8)
+	lines := make([]string, 15)
+	for i := range lines {
+		lines[i] = "line " + strconv.Itoa(i+1)
+	}
+	fake.failOn("in.webp", strings.Join(lines, "\n")+"\n")
+	opts := testOptions()
+	opts.ErrorLines = 3
+
+	err := runFFmpeg([]string{"-i", "in.webp", "out.mp4"}, opts, 0)
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("runFFmpeg = %v, want ffmpeg's exit status", err)
+	}
+	if calls := fake.calls(); len(calls) != 1 {
+		t.Fatalf("ffmpeg ran %d times, want once", len(calls))
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "line 13\nline 14\nline 15") || strings.Contains(msg, "line 12\n") {
+		t.Errorf("error should end with the last 3 lines of output, got %q", msg)
+	}
+	if !strings.Contains(msg, "[12 earlier lines omitted") {
+		t.Errorf("error should say how many lines were left out, got %q", msg)
+	}
+}
+
+func TestRunFFmpegTimeout(t *testing.T) {
+	newFakeFFmpeg(t, 8, 8)
+	opts := testOptions()
+	opts.Timeout = time.Nanosecond
+	opts.deadline = time.Now().Add(-time.Second)
+
+	if err := runFFmpeg([]string{"-i", "in.webp", "out.mp4"}, opts, 0); !errors.Is(err, ErrTimeout) {
+		t.Errorf("runFFmpeg past the deadline = %v, want ErrTimeout", err)
+	}
+}
+
+// encodeTail is the end of every default libx264 encode with the filter
+// chain vf, writing output.
+func encodeTail(vf, output string) []string {
+	return []string{"-r", "30", "-c:v", "libx264", "-pix_fmt", "yuv420p", "-b:v", "2M",
+		"-vf", vf, "-preset", "medium", "-movflags", "+faststart", "-f", "mp4", "-y", output}
+}
+
+func TestConvertArgs(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		scale         string
+		maxWidth      int
+		pad           bool
+		vf            string
+	}{
+		{"even size", 8, 8, "", 0, false, "format=yuv420p"},
+		{"odd size", 9, 7, "", 0, false, "scale=10:8:flags=lanczos,format=yuv420p"},
+		{"scale", 9, 7, "320:-2", 0, false, "scale=320:250:flags=lanczos,format=yuv420p"},
+		{"max width", 40, 20, "", 16, false, "scale=16:8:flags=lanczos,format=yuv420p"},
+		{"scale and pad", 40, 20, "32:32", 0, true, "scale=32:16:flags=lanczos,pad=32:32:(ow-iw)/2:(oh-ih)/2:color=black,format=yuv420p"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "in.webp")
+			output := filepath.Join(dir, "out.mp4")
+			writeTestWebP(t, input, tt.width, tt.height, 100, 100, 200)
+			opts := testOptions()
+			opts.Scale, opts.MaxWidth, opts.Pad = tt.scale, tt.maxWidth, tt.pad
+			info, trim := inspectTest(t, input, opts)
+
+			t.Run("direct", func(t *testing.T) {
+				fake := newFakeFFmpeg(t, tt.width, tt.height)
+				if err := convertDirectly(input, output, info, trim, opts, &Result{}); err != nil {
+					t.Fatal(err)
+				}
+				calls := fake.calls()
+				if len(calls) != 1 {
+					t.Fatalf("ffmpeg ran %d times, want once", len(calls))
+				}
+				assertArgs(t, calls[0], append([]string{"-f", "webp_pipe", "-i", input}, encodeTail(tt.vf, output)...))
+			})
+			t.Run("extract", func(t *testing.T) {
+				fake := newFakeFFmpeg(t, tt.width, tt.height)
+				if err := convertViaExtraction(input, output, info, trim, opts, &Result{}); err != nil {
+					t.Fatal(err)
+				}
+				calls := fake.calls()
+				if len(calls) != 2 {
+					t.Fatalf("ffmpeg ran %d times, want twice", len(calls))
+				}
+				pattern := calls[0][len(calls[0])-1]
+				frames := filepath.Dir(pattern)
+				assertArgs(t, calls[0], []string{"-i", input, "-vsync", "0", filepath.Join(frames, "frame_%06d.png")})
+				assertArgs(t, calls[1], append([]string{"-f", "concat", "-safe", "0", "-i", filepath.Join(frames, "frames.ffconcat")}, encodeTail(tt.vf, output)...))
+			})
+		})
+	}
+}
+
+func TestConvertDirectlyUnknownSize(t *testing.T) {
+	fake := newFakeFFmpeg(t, 8, 8)
+	fake.set("probe_size", "")
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.webp")
+	output := filepath.Join(dir, "out.mp4")
+	if err := os.WriteFile(input, []byte("RIFFxxxxWEBPjunk"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a size the filter rounds down to even dimensions itself
+	if err := convertDirectly(input, output, WebPInfo{Frames: 3}, trimRange{EndFrame: 3}, testOptions(), &Result{}); err != nil {
+		t.Fatal(err)
+	}
+	calls := fake.calls()
+	if len(calls) != 1 {
+		t.Fatalf("ffmpeg ran %d times, want once", len(calls))
+	}
+	assertArgs(t, calls[0], append([]string{"-f", "webp_pipe", "-i", input}, encodeTail("scale='trunc(iw/2)*2:trunc(ih/2)*2',format=yuv420p", output)...))
+}
+
+func TestConvertAutoFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		stderr   string
+		fallback bool
+	}{
+		{"unreadable input", "[webp_pipe @ 0x1] Invalid data found when processing input\n", true},
+		{"bad encoder option", "[libx264 @ 0x1] Unrecognized option 'foo'\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeFFmpeg(t, 8, 8)
+			fake.failOn("webp_pipe", tt.stderr)
+			dir := t.TempDir()
+			output := filepath.Join(dir, "out.mp4")
+			input := animatedInput(t, dir)
+			opts := testOptions()
+			info, trim := inspectTest(t, input, opts)
+
+			// Uneven frame times make auto try the direct conversion first
+			result := &Result{}
+			err := convertAuto(input, output, info, trim, opts, result)
+			calls := fake.calls()
+			if len(calls) == 0 || calls[0][1] != "webp_pipe" {
+				t.Fatalf("first run = %q, want the direct conversion", calls)
+			}
+			if !tt.fallback {
+				if err == nil || len(calls) != 1 {
+					t.Fatalf("convertAuto = %v after %d runs, want the direct conversion's error alone", err, len(calls))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("convertAuto: %v", err)
+			}
+			if result.Method != "extract" || len(calls) != 3 {
+				t.Fatalf("method %q after %d runs, want extract after 3", result.Method, len(calls))
+			}
+			assertArgs(t, calls[1][:3], []string{"-i", input, "-vsync"})
+		})
+	}
+}
+
+func TestConvertTrickyPaths(t *testing.T) {
+	fake := newFakeFFmpeg(t, 8, 8)
+	dir := filepath.Join(t.TempDir(), `it's 100% "$HOME" *`)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "-clip:1;rm.webp")
+	output := filepath.Join(dir, "out put.mp4")
+	writeTestWebP(t, input, 8, 8, 100, 100, 200)
+	opts := testOptions()
+	opts.InputFPS = 10
+	opts.KeepFrames = filepath.Join(dir, "frames 50%")
+	info, trim := inspectTest(t, input, opts)
+
+	if err := convertViaExtraction(input, output, info, trim, opts, &Result{}); err != nil {
+		t.Fatal(err)
+	}
+	// Each path reaches ffmpeg as one argument, with the % of the directory
+	// escaped in the frame pattern and a file: prefix where ffmpeg would
+	// read the path as an option or a protocol
+	calls := fake.calls()
+	if len(calls) != 2 {
+		t.Fatalf("ffmpeg ran %d times, want twice", len(calls))
+	}
+	pattern := filepath.Join(strings.ReplaceAll(opts.KeepFrames, "%", "%%"), "frame_%06d.png")
+	assertArgs(t, calls[0], []string{"-i", "file:" + input, "-vsync", "0", pattern})
+	assertArgs(t, calls[1][:4], []string{"-framerate", "10", "-i", pattern})
+	if last := calls[1][len(calls[1])-1]; last != output {
+		t.Errorf("output argument = %q, want %q", last, output)
+	}
+	frames, err := listFrames(opts.KeepFrames)
+	if err != nil || len(frames) != 3 {
+		t.Errorf("listFrames = %d frames, %v, want the 3 extracted", len(frames), err)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Errorf("output not written: %v", err)
+	}
+}
+
+func TestConvertViaExtractionManyFrames(t *testing.T) {
+	const n = 1200
+	fake := newFakeFFmpeg(t, 8, 8)
+	fake.set("frame_count", strconv.Itoa(n))
+	dir := t.TempDir()
+	input := filepath.Join(dir, "long.webp")
+	output := filepath.Join(dir, "out.mp4")
+	durations := make([]int, n)
+	for i := range durations {
+		durations[i] = 40
+	}
+	writeTestWebP(t, input, 8, 8, durations...)
+	opts := testOptions()
+	opts.InputFPS = 25
+	opts.KeepFrames = filepath.Join(dir, "frames")
+	info, trim := inspectTest(t, input, opts)
+
+	if err := convertViaExtraction(input, output, info, trim, opts, &Result{}); err != nil {
+		t.Fatal(err)
+	}
+	frames, err := listFrames(opts.KeepFrames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != n {
+		t.Fatalf("extracted %d frames, want %d", len(frames), n)
+	}
+	for i, frame := range frames {
+		if want := filepath.Join(opts.KeepFrames, fmt.Sprintf("frame_%06d.png", i+1)); frame != want {
+			t.Fatalf("frame %d is %s, want %s", i, frame, want)
+		}
+	}
+	// The encode reads the frames back with the pattern they were written to
+	calls := fake.calls()
+	pattern := filepath.Join(opts.KeepFrames, "frame_%06d.png")
+	assertArgs(t, calls[0][len(calls[0])-1:], []string{pattern})
+	assertArgs(t, calls[1][:4], []string{"-framerate", "25", "-i", pattern})
+}
+
+func TestConvertViaExtractionStartNumber(t *testing.T) {
+	tests := []struct {
+		start string
+		want  []string
+	}{
+		// image2 starts at 1 by itself
+		{"1", nil},
+		{"0", []string{"-start_number", "0"}},
+	}
+	for _, tt := range tests {
+		t.Run("from "+tt.start, func(t *testing.T) {
+			fake := newFakeFFmpeg(t, 8, 8)
+			fake.set("frame_start", tt.start)
+			dir := t.TempDir()
+			input := animatedInput(t, dir)
+			opts := testOptions()
+			opts.InputFPS = 10
+			info, trim := inspectTest(t, input, opts)
+
+			if err := convertViaExtraction(input, filepath.Join(dir, "out.mp4"), info, trim, opts, &Result{}); err != nil {
+				t.Fatal(err)
+			}
+			calls := fake.calls()
+			if len(calls) != 2 {
+				t.Fatalf("ffmpeg ran %d times, want twice", len(calls))
+			}
+			pattern := calls[0][len(calls[0])-1]
+			want := append(append([]string{"-framerate", "10"}, tt.want...), "-i", pattern)
+			assertArgs(t, calls[1][:len(want)], want)
+		})
+	}
+}
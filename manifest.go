@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// manifestHeader names the -manifest columns. Width and height are those of
+// the output.
+var manifestHeader = []string{"input", "output", "status", "width", "height", "duration_seconds", "fps", "elapsed_seconds", "error"}
+
+// manifestWriter records a CSV row for every file of a batch as it
+// finishes. Rows are flushed one at a time, so a batch that is interrupted
+// still leaves a record of the files it got through.
+type manifestWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	csv  *csv.Writer
+}
+
+// createManifest creates the -manifest file and writes the header.
+func createManifest(path string) (*manifestWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manifest: %w", err)
+	}
+	m := &manifestWriter{file: file, csv: csv.NewWriter(file)}
+	if err := m.writeRow(manifestHeader); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// add records the outcome of one file.
+func (m *manifestWriter) add(r batchResult) {
+	errText := ""
+	if r.Err != nil {
+		// ffmpeg output spans several lines
+		errText = strings.Join(strings.Fields(r.Err.Error()), " ")
+	}
+	row := []string{
+		r.Input,
+		r.Output,
+		resultStatus(r),
+		strconv.Itoa(r.Result.OutputWidth),
+		strconv.Itoa(r.Result.OutputHeight),
+		strconv.FormatFloat(r.Result.Duration, 'f', 3, 64),
+		formatRate(r.Result.FPS),
+		strconv.FormatFloat(r.Elapsed.Seconds(), 'f', 3, 64),
+		errText,
+	}
+	if err := m.writeRow(row); err != nil {
+		slog.Error("failed to write manifest", "input", r.Input, "err", err)
+	}
+}
+
+func (m *manifestWriter) writeRow(row []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.csv.Write(row); err != nil {
+		return err
+	}
+	m.csv.Flush()
+	return m.csv.Error()
+}
+
+// Close closes the manifest file.
+func (m *manifestWriter) Close() error {
+	return m.file.Close()
+}
+
+// resultStatus describes the outcome of a batch file for the manifest: ok,
+// skipped, timeout or failed.
+func resultStatus(r batchResult) string {
+	switch {
+	case r.Skipped:
+		return "skipped"
+	case r.Err == nil:
+		return "ok"
+	case errors.Is(r.Err, ErrTimeout):
+		return "timeout"
+	}
+	return "failed"
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"strings"
+)
+
+// exifEntry is a tag in the first IFD of an EXIF chunk.
+type exifEntry struct {
+	Tag, Type uint16
+	Count     uint32
+	// Value is the four-byte value field, which holds the value itself
+	// when it fits and its offset in the TIFF data otherwise
+	Value []byte
+}
+
+// exifIFD0 returns the byte order, the TIFF data and the entries of the
+// first IFD of an EXIF chunk, with no entries if it can't be read. Some
+// writers keep the "Exif\0\0" prefix JPEG uses, which is skipped.
+func exifIFD0(data []byte) (binary.ByteOrder, []byte, []exifEntry) {
+	data = bytes.TrimPrefix(data, []byte("Exif\x00\x00"))
+	if len(data) < 8 {
+		return nil, nil, nil
+	}
+	var order binary.ByteOrder
+	switch string(data[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, nil, nil
+	}
+	if order.Uint16(data[2:4]) != 42 {
+		return nil, nil, nil
+	}
+	ifd := int(order.Uint32(data[4:8]))
+	if ifd < 8 || ifd+2 > len(data) {
+		return nil, nil, nil
+	}
+	count := int(order.Uint16(data[ifd:]))
+	var entries []exifEntry
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(data) {
+			break
+		}
+		entries = append(entries, exifEntry{
+			Tag:   order.Uint16(data[entry:]),
+			Type:  order.Uint16(data[entry+2:]),
+			Count: order.Uint32(data[entry+4:]),
+			Value: data[entry+8 : entry+12],
+		})
+	}
+	return order, data, entries
+}
+
+// exifString returns the text of an ASCII entry, or "" if it isn't one or
+// points outside the data.
+func exifString(order binary.ByteOrder, data []byte, e exifEntry) string {
+	const asciiType = 2
+	if e.Type != asciiType {
+		return ""
+	}
+	value := e.Value
+	if e.Count > 4 {
+		offset := order.Uint32(e.Value)
+		if uint64(offset)+uint64(e.Count) > uint64(len(data)) {
+			return ""
+		}
+		value = data[offset : offset+e.Count]
+	} else {
+		value = value[:e.Count]
+	}
+	return strings.TrimSpace(strings.TrimRight(string(value), "\x00"))
+}
+
+// sourceTagKeys are the output tags carried over from the input's metadata,
+// in the order they are written.
+var sourceTagKeys = []string{"artist", "copyright", "description"}
+
+// exifSourceTags maps the EXIF tags -copy-metadata reads to output tags.
+var exifSourceTags = map[uint16]string{
+	0x013b: "artist",
+	0x8298: "copyright",
+	0x010e: "description",
+}
+
+// xmpSourceTags maps the Dublin Core properties -copy-metadata reads from
+// XMP to output tags.
+var xmpSourceTags = map[string]string{
+	"creator":     "artist",
+	"rights":      "copyright",
+	"description": "description",
+}
+
+const dublinCoreNamespace = "http://purl.org/dc/elements/1.1/"
+
+// exifTags returns the output tags found in an EXIF chunk.
+func exifTags(data []byte) map[string]string {
+	tags := make(map[string]string)
+	order, tiff, entries := exifIFD0(data)
+	for _, e := range entries {
+		if key, ok := exifSourceTags[e.Tag]; ok {
+			if v := exifString(order, tiff, e); v != "" {
+				tags[key] = v
+			}
+		}
+	}
+	return tags
+}
+
+// xmpTags returns the output tags found in an XMP packet. Properties that
+// hold a list or a set of translations give their first entry.
+func xmpTags(data []byte) map[string]string {
+	tags := make(map[string]string)
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var key string
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return tags
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space == dublinCoreNamespace && xmpSourceTags[t.Name.Local] != "" {
+				key = xmpSourceTags[t.Name.Local]
+			}
+		case xml.EndElement:
+			if t.Name.Space == dublinCoreNamespace {
+				key = ""
+			}
+		case xml.CharData:
+			if v := strings.TrimSpace(string(t)); key != "" && v != "" && tags[key] == "" {
+				tags[key] = v
+			}
+		}
+	}
+}
+
+// sourceMetadata returns the artist, copyright and description recorded in
+// the EXIF and XMP chunks of input as key=value tags, preferring EXIF when
+// both have one.
+func sourceMetadata(input string) ([]string, error) {
+	chunks, err := readWebPChunks(input)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]string)
+	for _, chunk := range chunks {
+		var tags map[string]string
+		switch chunk.ID {
+		case "EXIF":
+			tags = exifTags(chunk.Data)
+		case "XMP ":
+			tags = xmpTags(chunk.Data)
+		}
+		for key, v := range tags {
+			if found[key] == "" || chunk.ID == "EXIF" {
+				found[key] = v
+			}
+		}
+	}
+	var metadata []string
+	for _, key := range sourceTagKeys {
+		if v := found[key]; v != "" {
+			metadata = append(metadata, key+"="+v)
+		}
+	}
+	return metadata, nil
+}
+
+// withSourceMetadata returns the -metadata tags for the output of input:
+// the ones sourceMetadata finds, except those -metadata or -title set
+// explicitly, followed by the explicit ones.
+func withSourceMetadata(input string, opts Options) []string {
+	tags, err := sourceMetadata(input)
+	if err != nil || len(tags) == 0 {
+		return opts.Metadata
+	}
+	explicit := make(map[string]bool)
+	for _, tag := range opts.Metadata {
+		key, _, _ := strings.Cut(tag, "=")
+		explicit[strings.ToLower(key)] = true
+	}
+	var metadata []string
+	for _, tag := range tags {
+		if key, _, _ := strings.Cut(tag, "="); !explicit[key] {
+			metadata = append(metadata, tag)
+		}
+	}
+	return append(metadata, opts.Metadata...)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// conversionMethod describes one -method for -list-methods.
+type conversionMethod struct {
+	Name  string
+	Needs string
+	About string
+	// Prefer says when to pick the method over auto
+	Prefer string
+}
+
+// conversionMethods are the -method values.
+var conversionMethods = []conversionMethod{
+	{"auto", "ffmpeg", "extract first when every frame lasts the same time, direct first otherwise; the other is the fallback", "the default, right for most files"},
+	{"direct", "ffmpeg with the webp_pipe demuxer", "ffmpeg reads the WebP itself, keeping each frame's own timing", "fastest, with no temp files, when ffmpeg decodes the file fine"},
+	{"extract", "ffmpeg, with ImageMagick as the extraction fallback", "frames are saved as PNGs in a temp folder and assembled with their own durations", "files ffmpeg can't decode directly, -reverse/-boomerang with trimming, -keep-frames"},
+	{"go-extract", "nothing beyond ffmpeg for the encode", "same as extract, but the frames are decoded in Go", "when neither ffmpeg's WebP decoder nor ImageMagick handles the file"},
+}
+
+// runListMethods prints what each -method does and what it needs, noting
+// which of the tools it relies on are missing here.
+func runListMethods(w io.Writer) {
+	_, ffmpegErr := exec.LookPath(ffmpegPath)
+	_, _, magickErr := imageMagickCmd()
+
+	for i, m := range conversionMethods {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s\n  %s\n  needs:  %s\n  prefer: %s\n", m.Name, m.About, m.Needs, m.Prefer)
+		switch {
+		case ffmpegErr != nil:
+			fmt.Fprintln(w, "  unavailable: ffmpeg is not installed or not in PATH")
+		case m.Name == "extract" && magickErr != nil:
+			fmt.Fprintln(w, "  note:   ImageMagick is missing, so there is no fallback if ffmpeg can't extract the frames")
+		case m.Name == "auto" && magickErr != nil:
+			fmt.Fprintln(w, "  note:   ImageMagick is missing, so the extract fallback only has ffmpeg")
+		}
+	}
+	fmt.Fprintln(w, "\n-stream-frames decodes in Go like go-extract, but pipes the frames to ffmpeg instead of writing them to disk.")
+}
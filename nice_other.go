@@ -0,0 +1,21 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// niceWarning is printed the first time -nice is ignored, not once per
+// command.
+var niceWarning sync.Once
+
+// setPriority would set the niceness of the process pid for -nice, but
+// this platform has no niceness, so it only warns.
+func setPriority(pid, nice int) error {
+	niceWarning.Do(func() {
+		slog.Warn("-nice is not supported on this platform, ignoring it")
+	})
+	return nil
+}
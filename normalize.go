@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"log/slog"
+	"math"
+	"os"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// normalizeFrames makes every extracted frame width x height, the size of
+// the first one. Some malformed animations decode to frames of differing
+// sizes, which ffmpeg's image2 input rejects, so each odd frame is scaled
+// to fit, keeping its aspect ratio, and centred on a transparent canvas.
+func normalizeFrames(frames []string, width, height, quality int) error {
+	odd, err := mismatchedFrames(frames, width, height)
+	if err != nil || len(odd) == 0 {
+		return err
+	}
+	slog.Warn("some frames differ in size from the first, fitting them to it", "size", fmt.Sprintf("%dx%d", width, height), "count", len(odd), "frames", odd)
+	for _, i := range odd {
+		if err := fitFrame(frames[i], width, height, quality); err != nil {
+			return fmt.Errorf("failed to resize frame %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// mismatchedFrames returns the 0-based indexes of the frames that aren't
+// width x height.
+func mismatchedFrames(frames []string, width, height int) ([]int, error) {
+	var odd []int
+	for i, frame := range frames {
+		w, h, err := getFrameDimensions(frame)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get dimensions of frame %d: %w", i, err)
+		}
+		if w != width || h != height {
+			odd = append(odd, i)
+		}
+	}
+	return odd, nil
+}
+
+// fitFrame rewrites the frame at path as a width x height image, with the
+// original scaled to fit and centred. JPEG frames are written back at
+// quality.
+func fitFrame(path string, width, height, quality int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	bounds := img.Bounds()
+	scale := math.Min(float64(width)/float64(bounds.Dx()), float64(height)/float64(bounds.Dy()))
+	w := max(1, int(math.Round(float64(bounds.Dx())*scale)))
+	h := max(1, int(math.Round(float64(bounds.Dy())*scale)))
+	x, y := (width-w)/2, (height-h)/2
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(canvas, image.Rect(x, y, x+w, y+h), img, bounds, xdraw.Src, nil)
+	return writeFrame(path, canvas, quality)
+}
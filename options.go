@@ -0,0 +1,781 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"regexp"
+	"time"
+)
+
+// Options controls how a WebP file is converted.
+type Options struct {
+	FPS      float64
+	Bitrate  string
+	Verbose  bool
+	Method   string
+	Progress bool
+	// ErrorLines is how many lines of ffmpeg's output a failure includes,
+	// 0 meaning all of them
+	ErrorLines int
+	// QualityMetric compares the output with the extracted frames after
+	// encoding, reporting the average SSIM and PSNR
+	QualityMetric bool
+	// Verify probes the output with ffprobe after encoding, checking for a
+	// video stream of the expected duration
+	Verify bool
+	// VerifyDuration probes the output like Verify, but fails unless its
+	// duration is within a frame of the one the source's frames add up to
+	VerifyDuration bool
+	// NoFallback makes -method auto report a failed first attempt instead
+	// of retrying with the other method
+	NoFallback bool
+	// Quiet suppresses everything but errors
+	Quiet bool
+	// Overwrite allows replacing an existing output file
+	Overwrite bool
+	// MakeDirs creates missing parent directories of the output
+	MakeDirs bool
+	// HWAccel selects a hardware H.264 encoder, empty for libx264
+	HWAccel string
+	// Scale is an ffmpeg-style "W:H" size or a percentage like "50%"
+	Scale string
+	// Ladder lists renditions to encode instead of a single output, each
+	// with its own Scale and Bitrate
+	Ladder []ladderRung
+	// AlsoWebM and AlsoMP4 encode a second rendition next to the output, a
+	// VP9 WebM or an H.264 MP4
+	AlsoWebM bool
+	AlsoMP4  bool
+	// Aspect is a display aspect ratio, such as 16:9, stored in the output
+	// without rescaling, empty for the natural one
+	Aspect string
+	// Rotate turns the video clockwise by a multiple of 90 degrees
+	Rotate int
+	// RotateFromMetadata sets Rotate and Flip from the input's EXIF
+	// orientation, unless either was given
+	RotateFromMetadata bool
+	// Flip mirrors the video horizontally ("h") or vertically ("v")
+	Flip string
+	// Scaler is the scale filter's resampling algorithm
+	Scaler string
+	// MaxWidth and MaxHeight bound the output size, 0 for no limit
+	MaxWidth  int
+	MaxHeight int
+	// NoUpscale keeps a -scale size from exceeding the source, as the
+	// -maxwidth and -maxheight bounds never do
+	NoUpscale bool
+	// Crop is a W:H:X:Y region cut out before scaling. AutoCrop detects it
+	// from the borders of the animation instead
+	Crop     string
+	AutoCrop bool
+	// Watermark is an image overlaid at WatermarkPos with
+	// WatermarkOpacity, from 0 to 1
+	Watermark        string
+	WatermarkPos     string
+	WatermarkOpacity float64
+	// Audio is a sound file muxed into the output. AudioFit is "loop" to
+	// repeat it for the length of the video or "trim" to play it once
+	Audio    string
+	AudioFit string
+	// Pad letterboxes the source inside a W:H -scale instead of stretching
+	// it, filling the borders with PadColor
+	Pad      bool
+	PadColor string
+	// ExtraFilters is an ffmpeg filter chain appended to the managed
+	// filters, for effects without a flag of their own
+	ExtraFilters string
+	// StrictDeps makes a missing ImageMagick or webpmux that the method
+	// may need fatal at startup rather than a warning
+	StrictDeps bool
+	// GlobalArgs are inserted at the front of the ffmpeg command lines
+	// that encode and extract frames, ahead of any input
+	GlobalArgs []string
+	// Denoise smooths the source with hqdn3d at DenoiseStrength before
+	// scaling, and Sharpen applies unsharp at SharpenAmount after it
+	Denoise         bool
+	DenoiseStrength float64
+	Sharpen         bool
+	SharpenAmount   float64
+	// TrimStart and TrimEnd limit the encoded part of the animation by
+	// time, a zero TrimEnd meaning the end
+	TrimStart time.Duration
+	TrimEnd   time.Duration
+	// Range limits it to a share of the animation, such as the middle 20%
+	Range percentRange
+	// MaxFrames rejects inputs with more frames than this, 0 meaning no
+	// limit
+	MaxFrames int
+	// StartFrame and EndFrame limit it by 0-based frame index. EndFrame
+	// is inclusive and -1 means the last frame.
+	StartFrame int
+	EndFrame   int
+	// PixFmt overrides the encoder's pixel format, yuv420p for libx264
+	PixFmt string
+	// GOP is the keyframe interval in frames, 0 leaving it to the encoder
+	GOP int
+	// BFrames caps the consecutive B-frames and Refs the reference frames,
+	// -1 leaving them to the encoder
+	BFrames int
+	Refs    int
+	// Threads limits the encoder's threads, 0 leaving it to ffmpeg
+	Threads int
+	// CRF encodes at a constant quality, from 1 (best) to 51, instead of
+	// the -b bitrate. 0 leaves it to the bitrate.
+	CRF int
+	// Lossless encodes without any loss, ignoring the bitrate
+	Lossless bool
+	// Tune is passed to libx264 as -tune. TuneSource picks it and the
+	// bitrate from the compression of the source, see tuneForSource.
+	Tune       string
+	TuneSource bool
+	// Profile and Level cap the H.264 profile and level, left to the
+	// encoder when empty
+	Profile string
+	Level   string
+	// FrameFunc, when set, is called on every decoded frame of an animation
+	// and its result is encoded instead. Frames are then always decoded in
+	// Go, as with -method go-extract.
+	FrameFunc FrameFunc
+	// Concat lists WebP files that are joined, in order, into one output.
+	// The input passed alongside it is then only used for messages.
+	Concat []string
+	// FastStart moves the MP4/MOV index to the front of output files, so
+	// playback can start before the whole file has downloaded. It costs
+	// ffmpeg a second pass over the file. Pipes are always fragmented.
+	FastStart bool
+	// Codec is the video codec, "h264", "hevc" or "vp9"
+	Codec string
+	// Format is "video", "apng" to write an animated PNG instead, or "webp"
+	// to re-encode as an animated WebP at WebPQuality, from 0 to 100
+	Format      string
+	WebPQuality int
+	// Container forces the output container instead of inferring it from
+	// the output extension
+	Container string
+	// Retries is how many times a conversion is retried after ffmpeg fails
+	Retries int
+	// PreserveTimes copies the input's modification time to the output
+	PreserveTimes bool
+	// DryRun prints the ffmpeg commands instead of running them
+	DryRun bool
+	// ColorSpace and ColorRange set the YUV matrix and range the video is
+	// converted to and tagged with, empty to leave them to ffmpeg
+	ColorSpace string
+	ColorRange string
+	// MaxSize is a target output size in bytes that the bitrate is chosen
+	// to meet, 0 to use Bitrate
+	MaxSize int64
+	// Extractor forces the frame extraction tool: "ffmpeg", "imagemagick",
+	// "webpmux" or "go". Empty tries ffmpeg, then ImageMagick.
+	Extractor string
+	// StreamFrames decodes the frames in Go and pipes them to ffmpeg rather
+	// than extracting them to disk, bounding memory and disk use
+	StreamFrames bool
+	// KeepFrames is a directory to extract frames into and leave them in,
+	// empty to extract into a temp directory that is removed
+	KeepFrames string
+	// KeepTempOnFailure leaves the temp frame directory of a failed
+	// conversion in place
+	KeepTempOnFailure bool
+	// Timeout bounds how long converting one file may take, 0 for no limit
+	Timeout time.Duration
+	// ICC is what happens to an embedded ICC profile: "apply" converts the
+	// frames to sRGB, "strip" drops it quietly and "" drops it with a
+	// warning
+	ICC string
+	// Preview opens the output with the default player once it is written
+	Preview bool
+	// Nice is the niceness the external commands run at, 0 to leave it
+	Nice int
+	// Concurrency is how many files ConvertBatch converts at once, one
+	// when unset
+	Concurrency int
+	// deadline is when the current file's Timeout runs out
+	deadline time.Time
+	// stdin feeds ffmpeg's standard input, for -stream-frames
+	stdin io.Reader
+	// fpsRate is FPS as given to -fps, such as 24000/1001, so ffmpeg gets
+	// the exact rate rather than a rounded decimal
+	fpsRate string
+	// bitrateSet records that Bitrate was given explicitly, so
+	// -tune-source leaves it alone
+	bitrateSet bool
+	// ladderFrames shares the extracted frames between the renditions of
+	// -ladder
+	ladderFrames *ladderFrames
+	// batch is what batch mode adds to ConvertBatch, nil for a plain call
+	batch *batchRun
+	// codecSet records that -codec was given, rather than left to the
+	// output's container
+	codecSet bool
+	// transformSet records that -rotate or -flip was given, which then
+	// wins over the EXIF orientation
+	transformSet bool
+	// padDuration is how long the last frame is held past its own time to
+	// reach MinOutputDuration
+	padDuration time.Duration
+	// TempDir is where extracted frames and two-pass logs are written,
+	// empty for the system temp directory
+	TempDir string
+	// Cover embeds the first frame in the output as an attached picture
+	Cover bool
+	// Metadata are key=value tags written to the output, such as
+	// title=Cat or comment=...
+	Metadata []string
+	// CopyMetadata carries the artist, copyright and description of the
+	// input's EXIF and XMP over to the output, unless Deterministic is set
+	CopyMetadata bool
+	// Deterministic makes identical inputs produce byte-identical outputs
+	Deterministic bool
+	// TwoPass runs a libx264 analysis pass before the real encode
+	TwoPass bool
+	// InputFPS is the rate decoded or extracted frames are assembled at,
+	// which FPS then duplicates or drops frames to match. 0 assembles them
+	// at FPS.
+	InputFPS float64
+	// inputFPSRate is InputFPS as given to -input-fps
+	inputFPSRate string
+	// SmoothTiming assembles extracted frames at their own durations, on
+	// an output rate chosen to fit them rather than FPS
+	SmoothTiming bool
+	// FrameFormat is the format of the frames written by the extraction
+	// methods, png or jpg, at FrameQuality from 1 to 100 for jpg
+	FrameFormat  string
+	FrameQuality int
+	// FrameStep keeps every Nth frame, each shown N times as long, for
+	// lighter previews. 1 keeps them all.
+	FrameStep int
+	// Speed multiplies the playback rate, 1 for the original timing
+	Speed float64
+	// Interpolate is the frame rate minterpolate synthesizes frames up to,
+	// 0 to disable, using the InterpolateMode motion estimation
+	Interpolate     int
+	InterpolateMode string
+	// Reverse plays the animation backwards, Boomerang forwards then
+	// backwards
+	Reverse   bool
+	Boomerang bool
+	// StillDuration is how long a single-image input is shown, 0 to
+	// reject such inputs
+	StillDuration time.Duration
+	// Loop is the number of extra times the animation is repeated
+	Loop int
+	// LoopDuration repeats the animation as often as it takes to run this
+	// long, cutting the last repeat short, instead of a fixed Loop count
+	LoopDuration time.Duration
+	// FrameDurationCap shortens frames shown longer than this, 0 to keep
+	// their own durations
+	FrameDurationCap time.Duration
+	// MinOutputDuration holds the last frame of shorter videos until they
+	// run this long, 0 to leave them as they are
+	MinOutputDuration time.Duration
+}
+
+// validate checks option values that would otherwise only fail inside
+// ffmpeg.
+func (o Options) validate() error {
+	// The codec -container implies is checked like a given one
+	resolveContainer("", &o)
+	switch o.Method {
+	case "auto", "extract", "go-extract", "direct":
+	default:
+		return fmt.Errorf("invalid -method %q (use auto, extract, go-extract or direct)", o.Method)
+	}
+	if o.FPS <= 0 || math.IsInf(o.FPS, 0) || math.IsNaN(o.FPS) {
+		return fmt.Errorf("-fps must be a positive number, got %g", o.FPS)
+	}
+	if o.InputFPS < 0 || math.IsInf(o.InputFPS, 0) || math.IsNaN(o.InputFPS) {
+		return fmt.Errorf("-input-fps must be a positive number, got %g", o.InputFPS)
+	}
+	if o.InputFPS > 0 {
+		switch {
+		case o.Method == "direct":
+			return fmt.Errorf("-input-fps sets the rate extracted frames are assembled at, so it needs an extraction method, not -method direct")
+		case len(o.Concat) > 0:
+			return fmt.Errorf("-input-fps and -concat can't be combined, -concat keeps each file's own timing")
+		case o.Interpolate > 0:
+			return fmt.Errorf("-input-fps and -interpolate can't be combined, minterpolate sets the output rate itself")
+		}
+	}
+	if o.SmoothTiming {
+		switch {
+		case o.Method == "direct":
+			return fmt.Errorf("-smooth-timing retimes extracted frames, so it needs an extraction method, not -method direct")
+		case o.StreamFrames:
+			return fmt.Errorf("-smooth-timing and -stream-frames can't be combined, streamed frames are sent at a fixed rate")
+		case o.InputFPS > 0:
+			return fmt.Errorf("-smooth-timing and -input-fps can't be combined, -input-fps gives every frame the same duration")
+		case o.Interpolate > 0:
+			return fmt.Errorf("-smooth-timing and -interpolate can't be combined, minterpolate sets the output rate itself")
+		}
+	}
+	if o.Nice < -20 || o.Nice > 19 {
+		return fmt.Errorf("-nice must be between -20 and 19, got %d", o.Nice)
+	}
+	if o.FrameFormat != "png" && o.FrameFormat != "jpg" {
+		return fmt.Errorf("invalid -frame-format %q (use png or jpg)", o.FrameFormat)
+	}
+	if o.FrameQuality < 1 || o.FrameQuality > 100 {
+		return fmt.Errorf("-frame-quality must be between 1 and 100, got %d", o.FrameQuality)
+	}
+	if o.FrameStep < 1 {
+		return fmt.Errorf("-framestep must be at least 1, got %d", o.FrameStep)
+	}
+	if o.FrameStep > 1 && len(o.Concat) > 0 {
+		return fmt.Errorf("-framestep and -concat can't be combined")
+	}
+	if !bitratePattern.MatchString(o.Bitrate) {
+		return fmt.Errorf("invalid -b %q (use bits per second with an optional k, M or G suffix, e.g. 2M, 500k or 2000000)", o.Bitrate)
+	}
+	switch o.Format {
+	case "video":
+	case "hevc-alpha":
+		if err := o.checkHEVCAlpha(); err != nil {
+			return err
+		}
+	case "apng", "webp":
+		if err := o.checkImageFormat(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid -format %q (use video, apng, webp or hevc-alpha)", o.Format)
+	}
+	if o.WebPQuality < 0 || o.WebPQuality > 100 {
+		return fmt.Errorf("-webp-quality must be between 0 and 100, got %d", o.WebPQuality)
+	}
+	if o.Codec != "h264" && o.Codec != "hevc" && o.Codec != "vp9" {
+		return fmt.Errorf("invalid -codec %q (use h264, hevc or vp9)", o.Codec)
+	}
+	if o.Codec == "vp9" && o.HWAccel != "" && o.HWAccel != "none" {
+		return fmt.Errorf("-codec vp9 is only encoded with libvpx-vp9, not -hwaccel %s", o.HWAccel)
+	}
+	if o.Codec == "hevc" && o.HWAccel != "" && o.HWAccel != "none" && o.HWAccel != "auto" {
+		if _, ok := hevcHWEncoders[o.HWAccel]; !ok {
+			return fmt.Errorf("-codec hevc is only supported with libx265 or -hwaccel videotoolbox, not -hwaccel %s", o.HWAccel)
+		}
+	}
+	for _, tag := range o.Metadata {
+		if !metadataPattern.MatchString(tag) {
+			return fmt.Errorf("invalid -metadata %q (use key=value, e.g. title=My video)", tag)
+		}
+	}
+	if o.Aspect != "" && !aspectPattern.MatchString(o.Aspect) {
+		return fmt.Errorf("invalid -aspect %q (use W:H such as 16:9, or a ratio such as 1.78)", o.Aspect)
+	}
+	if o.CRF < 0 || o.CRF > 51 {
+		return fmt.Errorf("-crf must be between 1 and 51, or 0 to use -b")
+	}
+	if o.CRF > 0 && (o.bitrateSet || o.TwoPass || o.MaxSize > 0) {
+		return fmt.Errorf("-crf sets a constant quality, so it can't be combined with -b, -2pass or -maxsize")
+	}
+	if o.Lossless {
+		switch {
+		case o.CRF > 0 || o.bitrateSet || o.TwoPass || o.MaxSize > 0:
+			return fmt.Errorf("-lossless has no bitrate or quality to set, so it can't be combined with -crf, -b, -2pass or -maxsize")
+		case o.Profile != "" || o.Level != "":
+			return fmt.Errorf("-lossless picks the profile itself, so it can't be combined with -profile or -level")
+		}
+	}
+	if err := o.checkProfile(); err != nil {
+		return err
+	}
+	if err := checkTune(o.Tune, o.Codec); err != nil {
+		return err
+	}
+	if len(o.Ladder) > 0 {
+		switch {
+		case o.Scale != "" || o.MaxWidth > 0 || o.MaxHeight > 0:
+			return fmt.Errorf("-ladder sets the size of each rendition, so it can't be combined with -scale, -maxwidth or -maxheight")
+		case o.bitrateSet || o.CRF > 0 || o.Lossless || o.MaxSize > 0:
+			return fmt.Errorf("-ladder sets the bitrate of each rendition, so it can't be combined with -b, -crf, -lossless or -maxsize")
+		case !o.isVideo():
+			return fmt.Errorf("-ladder makes videos, not -format %s", o.Format)
+		case len(o.Concat) > 0:
+			return fmt.Errorf("-ladder can't be combined with -concat")
+		}
+	}
+	if o.AlsoWebM || o.AlsoMP4 {
+		switch {
+		case o.AlsoWebM && o.AlsoMP4:
+			return fmt.Errorf("-also-webm and -also-mp4 can't be combined")
+		case len(o.Ladder) > 0:
+			return fmt.Errorf("-also-webm and -also-mp4 can't be combined with -ladder")
+		case !o.isVideo():
+			return fmt.Errorf("-also-webm and -also-mp4 add a video, not -format %s", o.Format)
+		case len(o.Concat) > 0:
+			return fmt.Errorf("-also-webm and -also-mp4 can't be combined with -concat")
+		case o.AlsoWebM && o.Codec == "vp9":
+			return fmt.Errorf("-also-webm adds a VP9 WebM, but -codec vp9 already makes one")
+		case o.AlsoMP4 && o.Codec == "h264" && o.Format == "video":
+			return fmt.Errorf("-also-mp4 adds an H.264 MP4, but the output already is one")
+		}
+	}
+	if o.Scale != "" {
+		if _, err := parseScale(o.Scale); err != nil {
+			return err
+		}
+	}
+	if o.Crop != "" {
+		if o.AutoCrop {
+			return fmt.Errorf("-crop and -autocrop can't be combined")
+		}
+		if _, err := parseCrop(o.Crop); err != nil {
+			return err
+		}
+	}
+	if o.Watermark != "" {
+		if _, ok := watermarkPositions[o.WatermarkPos]; !ok {
+			return fmt.Errorf("invalid -watermark-pos %q (use top-left, top-right, bottom-left, bottom-right or center)", o.WatermarkPos)
+		}
+		if o.WatermarkOpacity <= 0 || o.WatermarkOpacity > 1 {
+			return fmt.Errorf("-watermark-opacity must be between 0 and 1")
+		}
+		if err := checkWatermark(o.Watermark); err != nil {
+			return err
+		}
+	}
+	if o.Audio != "" {
+		if err := checkAudio(o); err != nil {
+			return err
+		}
+	}
+	if o.Rotate%90 != 0 {
+		return fmt.Errorf("invalid -rotate %d (use 90, 180 or 270)", o.Rotate)
+	}
+	if o.Flip != "" && o.Flip != "h" && o.Flip != "v" {
+		return fmt.Errorf("invalid -flip %q (use h or v)", o.Flip)
+	}
+	if o.Denoise && o.DenoiseStrength <= 0 {
+		return fmt.Errorf("invalid -denoise-strength %g (must be positive)", o.DenoiseStrength)
+	}
+	if o.Sharpen && (o.SharpenAmount <= 0 || o.SharpenAmount > 5) {
+		return fmt.Errorf("invalid -sharpen-amount %g (use a value above 0, up to 5)", o.SharpenAmount)
+	}
+	if !scalers[o.Scaler] {
+		return fmt.Errorf("invalid -scaler %q (use lanczos, bicubic, bilinear or neighbor)", o.Scaler)
+	}
+	if o.MaxWidth < 0 || o.MaxHeight < 0 {
+		return fmt.Errorf("-maxwidth and -maxheight must not be negative")
+	}
+	if o.MaxFrames < 0 {
+		return fmt.Errorf("-max-frames must not be negative")
+	}
+	if o.StartFrame < 0 || o.EndFrame < -1 {
+		return fmt.Errorf("-startframe and -endframe must not be negative")
+	}
+	if o.Speed <= 0 || math.IsInf(o.Speed, 0) || math.IsNaN(o.Speed) {
+		return fmt.Errorf("-speed must be a positive number")
+	}
+	if o.Interpolate < 0 {
+		return fmt.Errorf("-interpolate must not be negative")
+	}
+	if !interpolateModes[o.InterpolateMode] {
+		return fmt.Errorf("invalid -interpolatemode %q (use mci, blend or dup)", o.InterpolateMode)
+	}
+	if o.Reverse && o.Boomerang {
+		return fmt.Errorf("-reverse and -boomerang can't be combined")
+	}
+	if o.Timeout < 0 {
+		return fmt.Errorf("-timeout must not be negative")
+	}
+	if o.GOP < 0 {
+		return fmt.Errorf("-gop must not be negative")
+	}
+	if o.BFrames < -1 {
+		return fmt.Errorf("-bframes must not be negative")
+	}
+	if o.Refs < -1 {
+		return fmt.Errorf("-refs must not be negative")
+	}
+	if o.ErrorLines < 0 {
+		return fmt.Errorf("-error-lines must not be negative")
+	}
+	if o.Threads < 0 {
+		return fmt.Errorf("-threads must not be negative")
+	}
+	if o.Retries < 0 {
+		return fmt.Errorf("-retries must not be negative")
+	}
+	if o.Loop < 0 {
+		return fmt.Errorf("-loop must not be negative")
+	}
+	if o.LoopDuration > 0 && o.Loop > 0 {
+		return fmt.Errorf("-loop and -loop-to-duration can't be combined")
+	}
+	if o.LoopDuration > 0 && o.StillDuration > 0 {
+		return fmt.Errorf("-loop-to-duration and -stillduration can't be combined")
+	}
+	if o.MinOutputDuration > 0 && o.LoopDuration > 0 {
+		return fmt.Errorf("-min-output-duration and -loop-to-duration can't be combined")
+	}
+	if o.Container != "" {
+		if _, err := outputContainer("", o.Container, o.Codec); err != nil {
+			return err
+		}
+	}
+	if _, ok := colorSpaces[o.ColorSpace]; o.ColorSpace != "" && !ok {
+		return fmt.Errorf("invalid -colorspace %q (use bt709, bt601 or bt2020)", o.ColorSpace)
+	}
+	if o.ColorRange != "" && o.ColorRange != "tv" && o.ColorRange != "pc" {
+		return fmt.Errorf("invalid -colorrange %q (use tv or pc)", o.ColorRange)
+	}
+	if o.TwoPass && o.HWAccel != "" && o.HWAccel != "auto" {
+		return fmt.Errorf("-2pass is only supported with software encoders (libx264, libvpx-vp9), not -hwaccel")
+	}
+	if (o.TrimStart > 0 || o.TrimEnd > 0) && (o.StartFrame > 0 || o.EndFrame >= 0) {
+		return fmt.Errorf("-ss/-to and -startframe/-endframe can't be combined")
+	}
+	if o.Range.End > 0 && (o.TrimStart > 0 || o.TrimEnd > 0 || o.StartFrame > 0 || o.EndFrame >= 0) {
+		return fmt.Errorf("-range can't be combined with -ss/-to or -startframe/-endframe")
+	}
+	if len(o.Concat) > 0 {
+		if o.Method == "direct" {
+			return fmt.Errorf("-concat decodes the frames itself, so it can't use -method direct")
+		}
+		if o.AutoCrop {
+			return fmt.Errorf("-concat and -autocrop can't be combined")
+		}
+	}
+	if o.ICC != "" && o.ICC != "apply" && o.ICC != "strip" {
+		return fmt.Errorf("invalid -icc %q (use apply or strip)", o.ICC)
+	}
+	if o.ICC == "apply" {
+		switch {
+		case o.Method == "direct":
+			return fmt.Errorf("-icc apply needs the frames decoded in Go, not -method direct")
+		case o.Extractor != "" && o.Extractor != "go":
+			return fmt.Errorf("-icc apply needs the frames decoded in Go, not -extractor %s", o.Extractor)
+		case len(o.Concat) > 0:
+			return fmt.Errorf("-icc apply can't be combined with -concat")
+		}
+	}
+	if o.FrameFunc != nil && o.Method == "direct" {
+		return fmt.Errorf("a FrameFunc needs the frames decoded in Go, not -method direct")
+	}
+	if o.NoFallback && o.Method != "auto" {
+		return fmt.Errorf("-no-fallback only applies to -method auto, -method %s never falls back", o.Method)
+	}
+	switch o.Extractor {
+	case "", "ffmpeg", "imagemagick", "webpmux", "go":
+	default:
+		return fmt.Errorf("invalid -extractor %q (use ffmpeg, imagemagick, webpmux or go)", o.Extractor)
+	}
+	if o.Extractor != "" && o.Method == "direct" {
+		return fmt.Errorf("-extractor needs an extraction method, not -method direct")
+	}
+	if o.Extractor != "" && o.Extractor != "go" && (o.Method == "go-extract" || o.FrameFunc != nil) {
+		return fmt.Errorf("-extractor %s can't be combined with decoding in Go (-method go-extract or a FrameFunc)", o.Extractor)
+	}
+	if o.StreamFrames {
+		switch {
+		case o.Method == "direct" || o.Method == "extract":
+			return fmt.Errorf("-stream-frames decodes the frames in Go, so it can't be combined with -method %s", o.Method)
+		case o.Extractor != "" && o.Extractor != "go":
+			return fmt.Errorf("-stream-frames decodes the frames in Go, so it can't use -extractor %s", o.Extractor)
+		case o.Reverse || o.Boomerang:
+			return fmt.Errorf("-reverse and -boomerang need every frame at once, which -stream-frames avoids")
+		case o.TwoPass:
+			return fmt.Errorf("-2pass reads the frames twice, which -stream-frames can't")
+		case o.KeepFrames != "" || o.QualityMetric:
+			return fmt.Errorf("-keep-frames and -qualitymetric need frames on disk, which -stream-frames doesn't write")
+		case len(o.Concat) > 0:
+			return fmt.Errorf("-stream-frames and -concat can't be combined")
+		}
+	}
+	if o.QualityMetric {
+		switch {
+		case o.Method == "direct":
+			return fmt.Errorf("-qualitymetric compares against extracted frames, so it needs an extraction method, not -method direct")
+		case o.Speed != 1 || o.Interpolate > 0:
+			return fmt.Errorf("-qualitymetric can't line the frames up with -speed or -interpolate")
+		case len(o.Concat) > 0:
+			return fmt.Errorf("-qualitymetric and -concat can't be combined")
+		}
+	}
+	if o.KeepFrames != "" && o.Method == "direct" {
+		return fmt.Errorf("-keep-frames needs an extraction method, not -method direct")
+	}
+	if o.TempDir != "" {
+		if err := checkTempDir(o.TempDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// metadataPattern matches a -metadata key=value tag. The value may be empty,
+// which removes the tag.
+var metadataPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]+=`)
+
+// aspectPattern matches the -aspect values ffmpeg accepts: W:H, or a single
+// ratio. Both sides must be positive.
+var aspectPattern = regexp.MustCompile(`^([1-9][0-9]*:[1-9][0-9]*|[0-9]*[1-9][0-9]*(\.[0-9]+)?|0?\.[0-9]*[1-9][0-9]*)$`)
+
+// bitratePattern matches the -b values passed on to ffmpeg.
+var bitratePattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[kKmMgG]?$`)
+
+// maxFPS is the highest -fps used. Few displays refresh faster, so more
+// frames only make encoding slower and the file bigger.
+const maxFPS = 240
+
+// clampFPS caps FPS at maxFPS, with a warning.
+func (o *Options) clampFPS() {
+	if o.FPS > maxFPS {
+		slog.Warn("-fps is higher than any display shows, capping it", "fps", o.FPS, "max", maxFPS)
+		o.FPS, o.fpsRate = maxFPS, ""
+	}
+}
+
+// isVideo reports whether the output is a video, H.264 or otherwise, rather
+// than an animated image.
+func (o Options) isVideo() bool {
+	return o.Format == "video" || o.Format == "hevc-alpha"
+}
+
+// checkHEVCAlpha rejects options that don't apply to -format hevc-alpha,
+// which picks its encoder and container itself.
+func (o Options) checkHEVCAlpha() error {
+	switch {
+	case o.Container != "" && o.Container != "mov":
+		return fmt.Errorf("-format hevc-alpha is always written as MOV, not -container %s", o.Container)
+	case o.HWAccel != "" && o.HWAccel != "none":
+		return fmt.Errorf("-hwaccel doesn't apply to -format hevc-alpha, which uses hevc_videotoolbox when ffmpeg has it")
+	case o.Codec != "h264" && o.Codec != "hevc":
+		return fmt.Errorf("-codec doesn't apply to -format hevc-alpha, which is always HEVC")
+	case o.Tune != "":
+		return fmt.Errorf("-tune doesn't apply to -format hevc-alpha, whose hevc_videotoolbox encoder has no tunings")
+	case o.Lossless:
+		return fmt.Errorf("-lossless doesn't apply to -format hevc-alpha")
+	}
+	return nil
+}
+
+// checkImageFormat rejects options that only apply to H.264 video, for
+// -format apng and webp.
+func (o Options) checkImageFormat() error {
+	switch {
+	case o.Container != "":
+		return fmt.Errorf("-container doesn't apply to -format %s", o.Format)
+	case o.HWAccel != "" && o.HWAccel != "none":
+		return fmt.Errorf("-hwaccel doesn't apply to -format %s", o.Format)
+	case (o.TwoPass || o.MaxSize > 0) && o.Format == "apng":
+		return fmt.Errorf("-2pass and -maxsize don't apply to -format apng, which is lossless")
+	case o.TwoPass || o.MaxSize > 0:
+		return fmt.Errorf("-2pass and -maxsize don't apply to -format webp, which has no bitrate, use -webp-quality")
+	case o.Audio != "":
+		return fmt.Errorf("-format %s can't hold -audio", o.Format)
+	case o.ColorSpace != "" || o.ColorRange != "":
+		return fmt.Errorf("-colorspace and -colorrange don't apply to -format %s, which stays RGB", o.Format)
+	case o.Profile != "" || o.Level != "":
+		return fmt.Errorf("-profile and -level don't apply to -format %s", o.Format)
+	case o.Tune != "":
+		return fmt.Errorf("-tune doesn't apply to -format %s", o.Format)
+	case o.Codec != "h264":
+		return fmt.Errorf("-codec doesn't apply to -format %s", o.Format)
+	case o.GOP > 0:
+		return fmt.Errorf("-gop doesn't apply to -format %s, which has no keyframes", o.Format)
+	case o.BFrames >= 0 || o.Refs >= 0:
+		return fmt.Errorf("-bframes and -refs don't apply to -format %s", o.Format)
+	case (o.CRF > 0 || o.Lossless) && o.Format == "apng":
+		return fmt.Errorf("-crf and -lossless don't apply to -format apng, which is always lossless")
+	case o.CRF > 0:
+		return fmt.Errorf("-crf doesn't apply to -format webp, use -webp-quality")
+	case o.Aspect != "":
+		return fmt.Errorf("-aspect doesn't apply to -format %s, which has square pixels", o.Format)
+	case o.Cover:
+		return fmt.Errorf("-cover needs a video container, not -format %s", o.Format)
+	}
+	return nil
+}
+
+// checkTempDir makes sure dir is a directory that temp files can be created
+// in.
+func checkTempDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("invalid -tempdir: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("invalid -tempdir: %s is not a directory", dir)
+	}
+	probe, err := os.MkdirTemp(dir, "webp2mp4_check_*")
+	if err != nil {
+		return fmt.Errorf("invalid -tempdir: %s is not writable", dir)
+	}
+	return os.Remove(probe)
+}
+
+// playback returns how long a stretch of the source lasts in the output
+// once -speed is applied.
+func (o Options) playback(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / o.Speed)
+}
+
+// playbackFrames returns the number of output frames that n source frames
+// become once they are assembled at inputRate, -speed is applied and
+// -framestep has thinned them out.
+func (o Options) playbackFrames(n int) int {
+	return int(math.Round(float64(n) / o.Speed * o.outputFPS() / o.inputRate()))
+}
+
+// step returns -framestep, 1 when every frame is kept.
+func (o Options) step() int {
+	return max(o.FrameStep, 1)
+}
+
+// outputFPS returns the output frame rate, -fps divided by -framestep so
+// the kept frames aren't duplicated back up to it.
+func (o Options) outputFPS() float64 {
+	return o.FPS / float64(o.step())
+}
+
+// outputRate returns outputFPS as an exact ffmpeg rate, such as 30 or 30/4.
+func (o Options) outputRate() string {
+	return divideRate(o.fpsArg(), o.step())
+}
+
+// inputRate returns the frame rate extracted frames are assembled at,
+// -input-fps or, without it, -fps.
+func (o Options) inputRate() float64 {
+	if o.InputFPS > 0 {
+		return o.InputFPS
+	}
+	return o.FPS
+}
+
+// inputRateArg returns inputRate as passed to ffmpeg, keeping the exact
+// -fps when it is the rate.
+func (o Options) inputRateArg() string {
+	if o.inputFPSRate != "" {
+		return o.inputFPSRate
+	}
+	if o.InputFPS > 0 {
+		return formatRate(o.InputFPS)
+	}
+	return o.fpsArg()
+}
+
+// rateFilters resamples frames assembled at -input-fps to the -fps output
+// rate, duplicating or dropping frames as needed.
+func rateFilters(o Options) []string {
+	if o.InputFPS <= 0 || o.InputFPS == o.FPS {
+		return nil
+	}
+	return []string{"fps=" + o.outputRate()}
+}
+
+// mayUseImageMagick reports whether a conversion with these options can end
+// up extracting frames with ImageMagick: when it is the -extractor, or as
+// the fallback of ffmpeg's extraction under -method auto or extract.
+func (o Options) mayUseImageMagick() bool {
+	switch {
+	case o.Extractor == "imagemagick":
+		return true
+	case o.Extractor != "" || o.StreamFrames || o.ICC == "apply":
+		return false
+	}
+	return o.Method == "auto" || o.Method == "extract"
+}
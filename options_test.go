@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestValidateMethod(t *testing.T) {
+	tests := []struct {
+		method string
+		ok     bool
+	}{
+		{"auto", true},
+		{"extract", true},
+		{"go-extract", true},
+		{"direct", true},
+		{"extrat", false},
+		{"Direct", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		opts := testOptions()
+		opts.Method = tt.method
+		err := opts.validate()
+		if (err == nil) != tt.ok {
+			t.Errorf("-method %q: validate() = %v, want ok %v", tt.method, err, tt.ok)
+		}
+		if err != nil && !strings.Contains(err.Error(), "invalid -method") {
+			t.Errorf("-method %q: error %q doesn't name -method", tt.method, err)
+		}
+	}
+}
+
+func TestConvertUnknownMethod(t *testing.T) {
+	fake := newFakeFFmpeg(t, 8, 8)
+	dir := t.TempDir()
+	opts := testOptions()
+	// Past validate, as from a caller that skipped it
+	opts.Method = "extrat"
+
+	_, err := convertOnce(animatedInput(t, dir), filepath.Join(dir, "out.mp4"), opts)
+	if err == nil || !strings.Contains(err.Error(), `unknown -method "extrat"`) {
+		t.Errorf("convertOnce = %v, want the unknown method", err)
+	}
+	if calls := fake.calls(); len(calls) != 0 {
+		t.Errorf("ffmpeg ran %d times, want none", len(calls))
+	}
+}
+
+func TestValidateFPS(t *testing.T) {
+	tests := []struct {
+		fps  float64
+		ok   bool
+		want float64 // after clampFPS
+	}{
+		{0, false, 0},
+		{-1, false, 0},
+		{math.NaN(), false, 0},
+		{math.Inf(1), false, 0},
+		{math.Inf(-1), false, 0},
+		{0.5, true, 0.5},
+		{30, true, 30},
+		{maxFPS, true, maxFPS},
+		{241, true, maxFPS},
+		{1000, true, maxFPS},
+	}
+	for _, tt := range tests {
+		opts := testOptions()
+		opts.FPS = tt.fps
+		if err := opts.validate(); (err == nil) != tt.ok {
+			t.Errorf("-fps %g: validate() = %v, want ok %v", tt.fps, err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		opts.clampFPS()
+		if opts.FPS != tt.want {
+			t.Errorf("-fps %g: clamped to %g, want %g", tt.fps, opts.FPS, tt.want)
+		}
+	}
+}
+
+func TestClampFPSDropsExactRate(t *testing.T) {
+	opts := testOptions()
+	if err := opts.setFPS("480000/1001"); err != nil {
+		t.Fatal(err)
+	}
+	opts.clampFPS()
+	// The fraction given to -fps would otherwise still reach ffmpeg
+	if opts.FPS != maxFPS || opts.fpsArg() != strconv.Itoa(maxFPS) {
+		t.Errorf("clamped to %g, passed as %s, want %d", opts.FPS, opts.fpsArg(), maxFPS)
+	}
+}
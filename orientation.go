@@ -0,0 +1,66 @@
+package main
+
+import "fmt"
+
+// exifOrientationTag is the TIFF tag holding the EXIF orientation.
+const exifOrientationTag = 0x0112
+
+// exifOrientation returns the orientation, 1 to 8, recorded in the data of
+// an EXIF chunk, or 0 if there is none or it can't be read.
+func exifOrientation(data []byte) int {
+	order, _, entries := exifIFD0(data)
+	for _, e := range entries {
+		// A SHORT value sits in the first two bytes of the value field
+		if e.Tag == exifOrientationTag && e.Type == 3 {
+			if v := int(order.Uint16(e.Value)); v >= 1 && v <= 8 {
+				return v
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// orientationTransform returns the -rotate and -flip that turn an image
+// stored with the EXIF orientation upright. The flip applies to the rotated
+// image, as with the flags.
+func orientationTransform(orientation int) (rotate int, flip string) {
+	switch orientation {
+	case 2:
+		return 0, "h"
+	case 3:
+		return 180, ""
+	case 4:
+		return 0, "v"
+	case 5:
+		return 90, "h"
+	case 6:
+		return 90, ""
+	case 7:
+		return 90, "v"
+	case 8:
+		return 270, ""
+	}
+	return 0, ""
+}
+
+// describeOrientation names an EXIF orientation for -info.
+func describeOrientation(orientation int) string {
+	switch orientation {
+	case 0:
+		return "none"
+	case 1:
+		return "upright"
+	}
+	rotate, flip := orientationTransform(orientation)
+	var fix string
+	switch {
+	case rotate == 0:
+		fix = "flipped"
+	case flip == "":
+		fix = fmt.Sprintf("rotated %d° clockwise", rotate)
+	default:
+		fix = fmt.Sprintf("rotated %d° clockwise and flipped", rotate)
+	}
+	return fmt.Sprintf("%d (displayed %s)", orientation, fix)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// streamOut receives the encoded video when writing to stdout. It wraps the
+// original stdout, which main redirects to stderr for status messages.
+var streamOut = &countingWriter{w: os.Stdout}
+
+// countingWriter records how many bytes have been written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// bufferStdin copies stdin to a temp file and returns its path.
+func bufferStdin() (string, error) {
+	file, err := os.CreateTemp("", "webp2mp4_stdin_*.webp")
+	if err != nil {
+		return "", fmt.Errorf("failed to buffer stdin: %w", err)
+	}
+	if _, err := io.Copy(file, os.Stdin); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to buffer stdin: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to buffer stdin: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// outputArgs returns the muxer options and destination for output. The
+// container's +faststart needs to seek back to the start of the file, so
+// writing MP4 or MOV to stdout or a named pipe produces a fragmented file
+// instead, whatever -faststart says.
+func outputArgs(output string, opts Options) []string {
+	c, _ := containerFor(output, opts)
+	var args []string
+	switch {
+	case c.FastStart && nonSeekable(output):
+		args = append(args, "-movflags", "+frag_keyframe+empty_moov")
+	case c.FastStart && opts.FastStart:
+		args = append(args, "-movflags", "+faststart")
+	}
+	args = append(args, hevcTag(c, opts)...)
+	args = append(args, deterministicArgs(opts)...)
+	args = append(args, metadataArgs(opts)...)
+	if output == "-" {
+		return append(args, "-f", c.Muxer, "pipe:1")
+	}
+
+	switch c {
+	case apngContainer:
+		// Loop forever, like the WebP source
+		args = append(args, "-plays", "0")
+	case webpContainer:
+		args = append(args, "-loop", "0")
+	}
+	return append(args, "-f", c.Muxer, overwriteFlag(opts.Overwrite), ffmpegFile(output))
+}
+
+// hevcTag returns the -tag:v option for -codec hevc and -format hevc-alpha
+// in MP4 and MOV. QuickTime and iOS only play HEVC tagged hvc1, not ffmpeg's
+// default hev1.
+func hevcTag(c containerSpec, opts Options) []string {
+	if c.FastStart && (opts.Codec == "hevc" || opts.Format == "hevc-alpha") {
+		return []string{"-tag:v", "hvc1"}
+	}
+	return nil
+}
+
+// deterministicArgs returns the options for -deterministic, which drop the
+// metadata that differs between runs: ffmpeg and encoder version tags,
+// creation times and anything copied from the input.
+func deterministicArgs(opts Options) []string {
+	if !opts.Deterministic {
+		return nil
+	}
+	return []string{
+		"-map_metadata", "-1",
+		"-fflags", "+bitexact",
+		"-flags:v", "+bitexact",
+		"-flags:a", "+bitexact",
+		"-metadata", "creation_time=1970-01-01T00:00:00Z",
+	}
+}
+
+// metadataArgs returns a -metadata option for each -metadata and -title tag.
+// They come after deterministicArgs, so an explicit tag wins over the ones
+// -deterministic sets.
+func metadataArgs(opts Options) []string {
+	var args []string
+	for _, tag := range opts.Metadata {
+		args = append(args, "-metadata", tag)
+	}
+	return args
+}
+
+// promptMu serializes overwrite prompts between batch workers.
+var promptMu sync.Mutex
+
+// checkOutput refuses to continue when output already exists unless
+// overwriting was requested. On an interactive terminal the user is asked
+// instead, and a yes is recorded in opts.Overwrite.
+func checkOutput(output string, opts *Options) error {
+	if err := checkOutputDir(output, opts.MakeDirs); err != nil {
+		return err
+	}
+	if _, err := os.Stat(output); err != nil || opts.Overwrite {
+		return nil
+	}
+
+	if isTerminal(os.Stdin) && isTerminal(os.Stderr) {
+		promptMu.Lock()
+		defer promptMu.Unlock()
+
+		fmt.Fprintf(os.Stderr, "%s already exists. Overwrite? [y/N] ", output)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer == "y" || answer == "yes" {
+			opts.Overwrite = true
+			return nil
+		}
+	}
+
+	return fmt.Errorf("output file already exists: %s (use -overwrite to replace it)", output)
+}
+
+// nonSeekable reports whether output is stdout or an existing file that
+// isn't a regular one, such as a named pipe or /dev/stdout. ffmpeg can only
+// write these from start to end, and nothing can be read back from them.
+func nonSeekable(output string) bool {
+	if output == "-" {
+		return true
+	}
+	stat, err := os.Stat(output)
+	return err == nil && !stat.Mode().IsRegular()
+}
+
+// partialOutput returns a free name next to output for ffmpeg to write to
+// until commitOutput moves the finished file into place, so output never
+// holds a half-written video, even if the conversion is killed.
+func partialOutput(output string) (string, error) {
+	file, err := os.CreateTemp(filepath.Dir(output), ".webp2mp4_partial_*"+filepath.Ext(output))
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", writeError(err))
+	}
+	file.Close()
+	// ffmpeg creates it again, with the usual permissions rather than
+	// the private ones of CreateTemp
+	os.Remove(file.Name())
+	return file.Name(), nil
+}
+
+// commitOutput renames partial to output, which appears complete or not at
+// all. A file at output, including one that appeared after checkOutput, is
+// only replaced when overwrite is set, and keeps its mode. Renames across
+// filesystems fall back to copying.
+func commitOutput(partial, output string, overwrite bool) error {
+	if stat, err := os.Stat(output); err == nil {
+		if !overwrite {
+			return fmt.Errorf("output file already exists: %s (use -overwrite to replace it)", output)
+		}
+		if err := os.Chmod(partial, stat.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	err := os.Rename(partial, output)
+	if errors.Is(err, syscall.EXDEV) {
+		err = moveFile(partial, output)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to move the output into place: %w", writeError(err))
+	}
+	return nil
+}
+
+// moveFile copies src to dst and removes src, for renames os.Rename can't do.
+func moveFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// checkOutputDir makes sure the directory output goes in exists, creating
+// it and its parents when create is set. ffmpeg's own error for a missing
+// directory only says the file can't be opened.
+func checkOutputDir(output string, create bool) error {
+	dir := filepath.Dir(output)
+	info, err := os.Stat(dir)
+	switch {
+	case err == nil && info.IsDir():
+		return checkWritable(dir)
+	case err == nil:
+		return fmt.Errorf("%w: %s is not a directory", ErrOutputDirMissing, dir)
+	case !os.IsNotExist(err):
+		return err
+	case !create:
+		return fmt.Errorf("%w: %s (use -mkdir to create it)", ErrOutputDirMissing, dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", writeError(err))
+	}
+	slog.Debug("created output directory", "dir", dir)
+	return nil
+}
+
+// checkWritable creates and removes a file in dir, so a read-only or full
+// filesystem is reported before ffmpeg runs rather than partway through.
+func checkWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".webp2mp4_write_*")
+	if err != nil {
+		return fmt.Errorf("can't write to %s: %w", dir, writeError(err))
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// writeError wraps err, from writing a file, in ErrNoSpace or
+// ErrNotWritable when it is one of those.
+func writeError(err error) error {
+	switch {
+	case errors.Is(err, syscall.ENOSPC):
+		return fmt.Errorf("%w: %w", ErrNoSpace, err)
+	case errors.Is(err, fs.ErrPermission), errors.Is(err, syscall.EROFS):
+		return fmt.Errorf("%w: %w", ErrNotWritable, err)
+	}
+	return err
+}
+
+// overwriteFlag returns the ffmpeg flag matching the overwrite decision. -n
+// makes ffmpeg fail rather than prompt if the output appears in the meantime.
+func overwriteFlag(overwrite bool) string {
+	if overwrite {
+		return "-y"
+	}
+	return "-n"
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestOutputArgsSeekable(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "old.mp4")
+	if err := os.WriteFile(existing, []byte("video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, output := range []string{filepath.Join(dir, "new.mp4"), existing} {
+		assertArgs(t, outputArgs(output, testOptions()), []string{"-movflags", "+faststart", "-f", "mp4", "-y", output})
+	}
+}
+
+func TestOutputArgsPipe(t *testing.T) {
+	// The moov atom can't be moved to the front of a pipe, so the MP4 is
+	// fragmented instead
+	assertArgs(t, outputArgs("-", testOptions()), []string{"-movflags", "+frag_keyframe+empty_moov", "-f", "mp4", "pipe:1"})
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	assertArgs(t, outputArgs(os.DevNull, testOptions()), []string{"-movflags", "+frag_keyframe+empty_moov", "-f", "mp4", "-y", os.DevNull})
+}
+
+func TestDeterministicArgs(t *testing.T) {
+	fake := newFakeFFmpeg(t, 8, 8)
+	dir := t.TempDir()
+	input := animatedInput(t, dir)
+	output := filepath.Join(dir, "out.mp4")
+	opts := testOptions()
+	opts.Method = "direct"
+	opts.Deterministic = true
+	opts.Metadata = []string{"title=Cat"}
+
+	if _, err := convertWebPToMP4(input, output, opts); err != nil {
+		t.Fatal(err)
+	}
+	calls := fake.calls()
+	if len(calls) == 0 {
+		t.Fatal("ffmpeg never ran")
+	}
+	encode := calls[0]
+	// The explicit tag comes after the fixed ones, so it would win
+	want := []string{"-movflags", "+faststart",
+		"-map_metadata", "-1", "-fflags", "+bitexact", "-flags:v", "+bitexact", "-flags:a", "+bitexact",
+		"-metadata", "creation_time=1970-01-01T00:00:00Z", "-metadata", "title=Cat", "-f", "mp4", "-y"}
+	if len(encode) < len(want)+1 {
+		t.Fatalf("encode arguments %q are too short", encode)
+	}
+	assertArgs(t, encode[len(encode)-len(want)-1:len(encode)-1], want)
+
+	opts.Deterministic = false
+	if args := deterministicArgs(opts); args != nil {
+		t.Errorf("deterministicArgs without -deterministic = %q, want none", args)
+	}
+}
+
+func TestDeterministicRealFFmpeg(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg isn't installed")
+	}
+	if testing.Short() {
+		t.Skip("encodes with the real ffmpeg")
+	}
+	saved := ffmpegPath
+	ffmpegPath = "ffmpeg"
+	defer func() { ffmpegPath = saved }()
+
+	input := animatedInput(t, t.TempDir())
+	opts := testOptions()
+	// Decoding in Go doesn't depend on the build's WebP demuxer
+	opts.Method = "go-extract"
+	opts.Deterministic = true
+
+	var outputs [2][]byte
+	for i := range outputs {
+		// Same name, so nothing but the run itself differs
+		output := filepath.Join(t.TempDir(), "out.mp4")
+		if _, err := convertWebPToMP4(input, output, opts); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs[i] = data
+		if i == 0 {
+			// A second apart, so a real creation time would differ
+			time.Sleep(time.Second)
+		}
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Errorf("two -deterministic runs differ (%d and %d bytes)", len(outputs[0]), len(outputs[1]))
+	}
+}
+
+func TestCheckOutputDir(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		output  string
+		create  bool
+		want    error
+		created string
+	}{
+		{"existing", filepath.Join(root, "out.mp4"), false, nil, ""},
+		{"-mkdir creates parents", filepath.Join(root, "a", "b", "out.mp4"), true, nil, filepath.Join(root, "a", "b")},
+		{"missing", filepath.Join(root, "c", "d", "out.mp4"), false, ErrOutputDirMissing, ""},
+		{"parent is a file", filepath.Join(file, "out.mp4"), true, ErrOutputDirMissing, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkOutputDir(tt.output, tt.create)
+			if tt.want == nil && err != nil || !errors.Is(err, tt.want) {
+				t.Fatalf("checkOutputDir(%s, %v) = %v, want %v", tt.output, tt.create, err, tt.want)
+			}
+			if tt.created != "" {
+				if info, err := os.Stat(tt.created); err != nil || !info.IsDir() {
+					t.Errorf("%s wasn't created: %v", tt.created, err)
+				}
+			}
+			if tt.want != nil {
+				if _, err := os.Stat(filepath.Dir(tt.output)); err == nil && !tt.create {
+					t.Errorf("%s was created without -mkdir", filepath.Dir(tt.output))
+				}
+			}
+		})
+	}
+}
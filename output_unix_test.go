@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestOutputArgsFIFO(t *testing.T) {
+	fifo := filepath.Join(t.TempDir(), "out.mp4")
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Skip("can't create a named pipe:", err)
+	}
+	if !nonSeekable(fifo) {
+		t.Error("nonSeekable(named pipe) = false, want true")
+	}
+	assertArgs(t, outputArgs(fifo, testOptions()), []string{"-movflags", "+frag_keyframe+empty_moov", "-f", "mp4", "-y", fifo})
+}
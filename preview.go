@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// previewOutput opens output with the platform's default player for
+// -preview. Nothing is opened for stdout, dry runs, -quiet or when not run
+// from an interactive terminal, such as in CI.
+func previewOutput(output string, opts Options) {
+	if !opts.Preview || nonSeekable(output) || opts.DryRun || opts.Quiet {
+		return
+	}
+	if os.Getenv("CI") != "" || !isTerminal(os.Stdin) || !isTerminal(os.Stderr) {
+		slog.Debug("not opening a preview outside an interactive terminal")
+		return
+	}
+	name, args := openCommand(runtime.GOOS, output)
+	cmd := exec.Command(name, args...)
+	if err := cmd.Start(); err != nil {
+		slog.Warn("failed to open the output", "output", output, "err", err)
+		return
+	}
+	// The player outlives us
+	cmd.Process.Release()
+}
+
+// openCommand returns the command that opens path with the default
+// application on goos.
+func openCommand(goos, path string) (string, []string) {
+	switch goos {
+	case "darwin":
+		return "open", []string{path}
+	case "windows":
+		// start is built into cmd, and takes a quoted first argument as
+		// the window title
+		return "cmd", []string{"/c", "start", "", path}
+	}
+	return "xdg-open", []string{path}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// probeResult is the -probe-only verdict on one input.
+type probeResult struct {
+	File   string `json:"file"`
+	Valid  bool   `json:"valid"`
+	Frames int    `json:"frames,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// probeInput runs the checks a conversion starts with on input, short of
+// decoding the frames or starting ffmpeg.
+func probeInput(input string, opts Options) (WebPInfo, error) {
+	if _, err := os.Stat(input); os.IsNotExist(err) {
+		return WebPInfo{}, fmt.Errorf("%w: %s", ErrInputNotFound, input)
+	}
+	if err := validateWebPHeader(input); err != nil {
+		return WebPInfo{}, err
+	}
+	info, err := inspectCached(input)
+	if err != nil {
+		return WebPInfo{}, fmt.Errorf("invalid input: %w", err)
+	}
+	if opts.MaxFrames > 0 && info.Frames > opts.MaxFrames {
+		return info, fmt.Errorf("%d frames, more than -max-frames %d allows", info.Frames, opts.MaxFrames)
+	}
+	if info.Frames < 2 && opts.StillDuration <= 0 && opts.LoopDuration <= 0 {
+		return info, fmt.Errorf("still image (1 frame), not an animated WebP (use -stillduration to make a clip of it)")
+	}
+	return info, nil
+}
+
+// runProbe checks every input with probeInput, printing a line per file
+// and a summary, or a JSON array when asJSON is set. It reports whether
+// every input would convert.
+func runProbe(w io.Writer, inputs []string, opts Options, asJSON bool) bool {
+	results := make([]probeResult, len(inputs))
+	invalid := 0
+	for i, input := range inputs {
+		info, err := probeInput(input, opts)
+		results[i] = probeResult{File: input, Valid: err == nil, Frames: info.Frames}
+		if err != nil {
+			results[i].Error = err.Error()
+			invalid++
+		}
+	}
+
+	if asJSON {
+		return writeJSON(w, results) == nil && invalid == 0
+	}
+	for _, r := range results {
+		if r.Valid {
+			fmt.Fprintf(w, "OK      %s (%d frames)\n", r.File, r.Frames)
+		} else {
+			fmt.Fprintf(w, "INVALID %s: %s\n", r.File, r.Error)
+		}
+	}
+	fmt.Fprintf(w, "\n%d of %d files are valid\n", len(inputs)-invalid, len(inputs))
+	return invalid == 0
+}
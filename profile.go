@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// h264Profiles are the -profile values, with the pixel formats each one can
+// encode. The high444 profile takes any format libx264 does.
+var h264Profiles = map[string][]string{
+	"baseline": {"yuv420p", "yuvj420p", "nv12"},
+	"main":     {"yuv420p", "yuvj420p", "nv12"},
+	"high":     {"yuv420p", "yuvj420p", "nv12"},
+	"high10":   {"yuv420p", "yuvj420p", "nv12", "yuv420p10le"},
+	"high422":  {"yuv420p", "yuvj420p", "nv12", "yuv420p10le", "yuv422p", "yuvj422p", "yuv422p10le"},
+	"high444":  nil,
+}
+
+// levelPattern matches the H.264 levels accepted by -level, such as 3, 3.0,
+// 4.1 or 1b.
+var levelPattern = regexp.MustCompile(`^([1-6](\.[0-9])?|1b)$`)
+
+// checkProfile validates -profile and -level, and that the profile can
+// encode -pixfmt.
+func (o Options) checkProfile() error {
+	if o.Profile != "" {
+		formats, ok := h264Profiles[o.Profile]
+		if !ok {
+			return fmt.Errorf("invalid -profile %q (use baseline, main, high, high10, high422 or high444)", o.Profile)
+		}
+		if o.PixFmt != "" && formats != nil && !slices.Contains(formats, o.PixFmt) {
+			return fmt.Errorf("the %s profile can't encode -pixfmt %s (use %s, or -profile high444)", o.Profile, o.PixFmt, strings.Join(formats, ", "))
+		}
+	}
+	if o.Level != "" && !levelPattern.MatchString(o.Level) {
+		return fmt.Errorf("invalid -level %q (use an H.264 level such as 3.0, 4.1 or 5.1)", o.Level)
+	}
+	return nil
+}
+
+// profileArgs returns the -profile:v and -level encoder options.
+func profileArgs(opts Options) []string {
+	var args []string
+	if opts.Profile != "" {
+		args = append(args, "-profile:v", opts.Profile)
+	}
+	if opts.Level != "" {
+		args = append(args, "-level", opts.Level)
+	}
+	return args
+}
+
+// profileHint adds the -profile and -level in use to a failed encode, as
+// the encoder's own message for a combination it can't do is often terse.
+func profileHint(err error, opts Options) error {
+	if opts.Profile == "" && opts.Level == "" {
+		return err
+	}
+	var set []string
+	if opts.Profile != "" {
+		set = append(set, "-profile "+opts.Profile)
+	}
+	if opts.Level != "" {
+		set = append(set, "-level "+opts.Level)
+	}
+	return fmt.Errorf("%w\n(encoding with %s; check that the encoder supports it and that the size, fps and bitrate fit the level)", err, strings.Join(set, " "))
+}
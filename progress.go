@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressReporter renders ffmpeg's -progress output as a percentage. On a
+// terminal the line is redrawn in place, otherwise a new line is printed for
+// every 10% step so logs stay readable.
+type progressReporter struct {
+	w       io.Writer
+	total   int
+	tty     bool
+	lastPct int
+	drawn   bool
+}
+
+func newProgressReporter(f *os.File, totalFrames int) *progressReporter {
+	return &progressReporter{
+		w:       f,
+		total:   totalFrames,
+		tty:     isTerminal(f),
+		lastPct: -1,
+	}
+}
+
+// consume reads key=value progress blocks until r is closed.
+func (p *progressReporter) consume(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	frame := 0
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "frame":
+			frame, _ = strconv.Atoi(strings.TrimSpace(value))
+		case "progress":
+			p.report(frame, value == "end")
+		}
+	}
+}
+
+func (p *progressReporter) report(frame int, done bool) {
+	// Without a known total only the frame count can be shown
+	if p.total <= 0 {
+		if p.tty {
+			fmt.Fprintf(p.w, "\rEncoding: frame %d", frame)
+			p.drawn = true
+			if done {
+				p.finishLine()
+			}
+		} else if done {
+			fmt.Fprintf(p.w, "Encoding: done (%d frames)\n", frame)
+		}
+		return
+	}
+
+	pct := frame * 100 / p.total
+	if pct > 100 || done {
+		pct = 100
+	}
+
+	if p.tty {
+		if pct != p.lastPct {
+			fmt.Fprintf(p.w, "\rEncoding: %3d%%", pct)
+			p.drawn = true
+		}
+		if done {
+			p.finishLine()
+		}
+	} else if pct/10 > p.lastPct/10 || (done && pct != p.lastPct) {
+		fmt.Fprintf(p.w, "Encoding: %d%%\n", pct)
+	}
+	p.lastPct = pct
+}
+
+// abort terminates a partially drawn progress line so errors start cleanly.
+func (p *progressReporter) abort() {
+	p.finishLine()
+}
+
+func (p *progressReporter) finishLine() {
+	if p.drawn {
+		fmt.Fprintln(p.w)
+		p.drawn = false
+	}
+}
+
+// isTerminal reports whether f is attached to a character device.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// batchProgress counts finished files across a batch and estimates the time
+// left from the average time per file so far. With several workers the
+// average is taken over wall-clock time, so it already accounts for files
+// converting in parallel.
+type batchProgress struct {
+	mu    sync.Mutex
+	w     io.Writer
+	total int
+	done  int
+	start time.Time
+}
+
+func newBatchProgress(w io.Writer, total int) *batchProgress {
+	return &batchProgress{w: w, total: total, start: time.Now()}
+}
+
+// finish records one more finished file and prints the overall count.
+func (b *batchProgress) finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done++
+	if b.done == b.total {
+		fmt.Fprintf(b.w, "Batch: file %d of %d\n", b.done, b.total)
+		return
+	}
+	perFile := time.Since(b.start) / time.Duration(b.done)
+	eta := perFile * time.Duration(b.total-b.done)
+	fmt.Fprintf(b.w, "Batch: file %d of %d, ETA %s\n", b.done, b.total, eta.Round(time.Second))
+}
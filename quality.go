@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxPSNR is reported for outputs identical to the source, where ffmpeg's
+// PSNR is infinite.
+const maxPSNR = 100
+
+var (
+	// ssimPattern finds the overall score in the ssim filter's summary,
+	// "SSIM Y:0.995 (23.0) U:... V:... All:0.994 (22.4)"
+	ssimPattern = regexp.MustCompile(`SSIM .*All:([0-9.]+|inf)`)
+	// psnrPattern finds it in the psnr filter's, "PSNR y:... average:41.2 min:..."
+	psnrPattern = regexp.MustCompile(`PSNR .*average:([0-9.]+|inf)`)
+)
+
+// measureQuality compares output with the extracted frames it was encoded
+// from, which are read with the same inputArgs as the encode, and stores the
+// average SSIM and PSNR in result. The frames go through the same crop and
+// scale filters first so each is compared with what it became.
+func measureQuality(inputArgs, filters []string, output string, opts Options, result *Result) error {
+	if nonSeekable(output) {
+		slog.Warn("-qualitymetric can't read back a video written to a pipe, skipping it")
+		return nil
+	}
+
+	ref := append(append([]string{}, filters...), "format=yuv420p", "split[ref1][ref2]")
+	graph := fmt.Sprintf("[1:v]%s;[0:v]format=yuv420p,split[enc1][enc2];[enc1][ref1]ssim=shortest=1;[enc2][ref2]psnr=shortest=1", strings.Join(ref, ","))
+
+	args := []string{"-hide_banner", "-i", ffmpegFile(output)}
+	args = append(args, inputArgs...)
+	args = append(args, "-filter_complex", graph, "-f", "null", "-")
+	if opts.DryRun {
+		printCommand(ffmpegPath, args)
+		return nil
+	}
+	slog.Debug("measuring quality", "cmd", commandLine(ffmpegPath, args))
+
+	cmd, cancel := deadlineCommand(opts, ffmpegPath, args...)
+	defer cancel()
+	out, err := combinedOutput(cmd, opts)
+	if err := checkDeadline(err, opts); err != nil {
+		return fmt.Errorf("failed to compare the output with its frames: %w\nOutput: %s", err, tailLines(string(out), opts.ErrorLines))
+	}
+
+	ssim, err := metricValue(ssimPattern, out)
+	if err != nil {
+		return fmt.Errorf("failed to read SSIM: %w", err)
+	}
+	psnr, err := metricValue(psnrPattern, out)
+	if err != nil {
+		return fmt.Errorf("failed to read PSNR: %w", err)
+	}
+	result.SSIM, result.PSNR = ssim, math.Min(psnr, maxPSNR)
+	slog.Info("output quality", "output", output, "ssim", fmt.Sprintf("%.4f", result.SSIM), "psnr_db", fmt.Sprintf("%.2f", result.PSNR))
+	return nil
+}
+
+// metricValue returns the number pattern captures from the last matching
+// line of ffmpeg's output.
+func metricValue(pattern *regexp.Regexp, out []byte) (float64, error) {
+	matches := pattern.FindAllSubmatch(out, -1)
+	if len(matches) == 0 {
+		return 0, fmt.Errorf("ffmpeg printed no summary")
+	}
+	return strconv.ParseFloat(string(matches[len(matches)-1][1]), 64)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseFrameRate reads a frame rate given as an integer, a decimal such as
+// 29.97 or a fraction such as 24000/1001, and returns its value.
+func parseFrameRate(s string) (float64, error) {
+	var rate float64
+	if num, den, ok := strings.Cut(s, "/"); ok {
+		n, err1 := strconv.ParseFloat(num, 64)
+		d, err2 := strconv.ParseFloat(den, 64)
+		if err1 != nil || err2 != nil || d == 0 {
+			return 0, fmt.Errorf("invalid frame rate %q (use e.g. 30, 29.97 or 30000/1001)", s)
+		}
+		rate = n / d
+	} else {
+		var err error
+		if rate, err = strconv.ParseFloat(s, 64); err != nil {
+			return 0, fmt.Errorf("invalid frame rate %q (use e.g. 30, 29.97 or 30000/1001)", s)
+		}
+	}
+	if rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return 0, fmt.Errorf("frame rate must be a positive number, got %s", s)
+	}
+	return rate, nil
+}
+
+// setFPS sets the output frame rate from s, keeping s itself so ffmpeg gets
+// exactly the rate that was asked for.
+func (o *Options) setFPS(s string) error {
+	rate, err := parseFrameRate(s)
+	if err != nil {
+		return err
+	}
+	o.FPS, o.fpsRate = rate, s
+	return nil
+}
+
+// setInputFPS sets the assembly rate from s, as setFPS does the output rate.
+func (o *Options) setInputFPS(s string) error {
+	rate, err := parseFrameRate(s)
+	if err != nil {
+		return err
+	}
+	o.InputFPS, o.inputFPSRate = rate, s
+	return nil
+}
+
+// durationRate returns the rate of frames lasting d each as a reduced
+// fraction, or an integer when it is whole.
+func durationRate(d time.Duration) string {
+	divisor := int64(gcdDuration(time.Second, d))
+	num, den := int64(time.Second)/divisor, int64(d)/divisor
+	if den == 1 {
+		return strconv.FormatInt(num, 10)
+	}
+	return fmt.Sprintf("%d/%d", num, den)
+}
+
+// fpsArg returns the output frame rate as passed to ffmpeg: as given to
+// -fps, or the shortest decimal for FPS when it was set some other way.
+func (o Options) fpsArg() string {
+	if o.fpsRate != "" {
+		return o.fpsRate
+	}
+	return formatRate(o.FPS)
+}
+
+// formatRate formats rate as the shortest decimal that reads back the same.
+func formatRate(rate float64) string {
+	return strconv.FormatFloat(rate, 'f', -1, 64)
+}
+
+// divideRate returns the ffmpeg frame rate rate divided by n, as a fraction
+// when rate is an integer or a fraction so nothing is rounded.
+func divideRate(rate string, n int) string {
+	if n == 1 {
+		return rate
+	}
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		den = "1"
+	}
+	if d, err := strconv.Atoi(den); err == nil {
+		if _, err := strconv.Atoi(num); err == nil {
+			return fmt.Sprintf("%s/%d", num, d*n)
+		}
+	}
+	value, _ := parseFrameRate(rate)
+	return formatRate(value / float64(n))
+}
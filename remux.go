@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// runFastStart implements the faststart subcommand: it remuxes an existing
+// MP4 or MOV with -movflags +faststart, copying the streams as they are.
+// Without an output the input is replaced, by way of a temp file next to it
+// so a failed remux leaves it untouched.
+func runFastStart(args []string, opts Options) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: webp2mp4 faststart input.mp4 [output.mp4]")
+	}
+	input, output := args[0], args[0]
+	if len(args) == 2 {
+		output = args[1]
+	}
+	stat, err := os.Stat(input)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrInputNotFound, input)
+	} else if err != nil {
+		return err
+	}
+	c, err := outputContainer(output, "", "")
+	if err != nil {
+		return err
+	}
+	if !c.FastStart {
+		return fmt.Errorf("faststart only applies to MP4 and MOV files, not %s", output)
+	}
+
+	target := output
+	inPlace := filepath.Clean(input) == filepath.Clean(output)
+	if inPlace {
+		file, err := os.CreateTemp(filepath.Dir(output), ".webp2mp4_faststart_*"+filepath.Ext(output))
+		if err != nil {
+			return fmt.Errorf("failed to create temp file: %w", err)
+		}
+		file.Close()
+		target = file.Name()
+		defer os.Remove(target)
+		// The temp file exists already
+		opts.Overwrite = true
+	} else if !opts.DryRun {
+		if err := checkOutput(output, &opts); err != nil {
+			return err
+		}
+	}
+
+	ffArgs := []string{"-i", ffmpegFile(input), "-map", "0", "-c", "copy", "-movflags", "+faststart",
+		"-f", c.Muxer, overwriteFlag(opts.Overwrite), ffmpegFile(target)}
+	slog.Debug("running command", "cmd", commandLine(ffmpegPath, ffArgs))
+	if err := runFFmpeg(ffArgs, opts, 0); err != nil {
+		return fmt.Errorf("%w: %w", ErrEncodeFailed, err)
+	}
+	if inPlace && !opts.DryRun {
+		// CreateTemp makes the file private, keep the original's mode
+		if err := os.Chmod(target, stat.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Rename(target, output); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", output, err)
+		}
+	}
+	return nil
+}
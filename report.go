@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// writeJSON prints v as indented JSON.
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// batchJSONEntry is a batch result as reported by -json, with the error
+// flattened to a string.
+type batchJSONEntry struct {
+	Result
+	Error   string `json:"error,omitempty"`
+	Skipped bool   `json:"skipped,omitempty"`
+}
+
+// writeBatchJSON prints the results of a batch as a JSON array.
+func writeBatchJSON(w io.Writer, results []batchResult) error {
+	entries := make([]batchJSONEntry, len(results))
+	for i, r := range results {
+		entries[i] = batchJSONEntry{Result: r.Result, Skipped: r.Skipped}
+		if r.Err != nil {
+			entries[i].Error = r.Err.Error()
+		}
+	}
+	return writeJSON(w, entries)
+}
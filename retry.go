@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// retryBackoff is the wait before the first retry, doubled for each one
+// after that.
+const retryBackoff = 500 * time.Millisecond
+
+// convertWebPToMP4 converts input to output, retrying up to opts.Retries
+// times when an external command fails. Validation errors aren't retried,
+// and neither is running out of -timeout, which covers every attempt.
+func convertWebPToMP4(input, output string, opts Options) (Result, error) {
+	start := time.Now()
+	if opts.Timeout > 0 {
+		opts.deadline = start.Add(opts.Timeout)
+	}
+	_, statErr := os.Stat(output)
+	existed := statErr == nil
+
+	result, err := convertOnce(input, output, opts)
+	for attempt := 1; attempt <= opts.Retries && retryable(err); attempt++ {
+		// A retry would append a second stream to what was already sent
+		if output == "-" && streamOut.n > 0 || output != "-" && nonSeekable(output) {
+			break
+		}
+		if existed {
+			// The first attempt was allowed to replace it
+			opts.Overwrite = true
+		}
+
+		wait := retryBackoff << (attempt - 1)
+		slog.Debug("conversion failed, retrying", "input", input, "attempt", attempt, "wait", wait, "err", err)
+		time.Sleep(wait)
+		result, err = convertOnce(input, output, opts)
+	}
+
+	result.Elapsed = time.Since(start).Seconds()
+	return result, err
+}
+
+// retryable reports whether err comes from an external command exiting
+// unsuccessfully, which may be transient. A full or read-only disk isn't.
+func retryable(err error) bool {
+	if errors.Is(err, ErrNoSpace) || errors.Is(err, ErrNotWritable) {
+		return false
+	}
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// scaleSpec is a parsed -scale expression. Either Percent is set, or Width
+// and Height hold a size where -1 keeps the aspect ratio and -2 does the same
+// while rounding to an even number, as in ffmpeg's scale filter.
+type scaleSpec struct {
+	Width   int
+	Height  int
+	Percent float64
+}
+
+// parseScale parses a -scale value such as "640:-2" or "50%".
+func parseScale(expr string) (scaleSpec, error) {
+	if strings.HasSuffix(expr, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(expr, "%"), 64)
+		if err != nil || pct <= 0 {
+			return scaleSpec{}, fmt.Errorf("invalid -scale %q: percentage must be a positive number", expr)
+		}
+		return scaleSpec{Percent: pct}, nil
+	}
+
+	w, h, ok := strings.Cut(expr, ":")
+	if !ok {
+		return scaleSpec{}, fmt.Errorf("invalid -scale %q: expected W:H or N%%", expr)
+	}
+	width, errW := strconv.Atoi(w)
+	height, errH := strconv.Atoi(h)
+	if errW != nil || errH != nil || !validScaleSide(width) || !validScaleSide(height) {
+		return scaleSpec{}, fmt.Errorf("invalid -scale %q: each side must be a positive integer, -1 or -2", expr)
+	}
+	if width < 0 && height < 0 {
+		return scaleSpec{}, fmt.Errorf("invalid -scale %q: at least one side must be fixed", expr)
+	}
+	return scaleSpec{Width: width, Height: height}, nil
+}
+
+// parseFit parses a -fit canvas such as "512x512".
+func parseFit(expr string) (scaleSpec, error) {
+	w, h, ok := strings.Cut(strings.ToLower(expr), "x")
+	width, errW := strconv.Atoi(w)
+	height, errH := strconv.Atoi(h)
+	if !ok || errW != nil || errH != nil || width <= 0 || height <= 0 {
+		return scaleSpec{}, fmt.Errorf("invalid -fit %q: expected WxH with positive sizes, e.g. 512x512", expr)
+	}
+	return scaleSpec{Width: width, Height: height}, nil
+}
+
+func validScaleSide(n int) bool {
+	return n > 0 || n == -1 || n == -2
+}
+
+// targetSize computes the output dimensions for a width x height source. The
+// -scale expression is applied first, then -maxwidth/-maxheight shrink the
+// result to fit while keeping the aspect ratio. With -resize-only-if-larger
+// a -scale size bigger than the source is shrunk back to fit it. The result
+// is even so H.264 can encode it, except for -format apng.
+func targetSize(width, height int, opts Options) (int, int) {
+	w, h := float64(width), float64(height)
+
+	if opts.Scale != "" {
+		spec, _ := parseScale(opts.Scale)
+		switch {
+		case spec.Percent > 0:
+			w, h = w*spec.Percent/100, h*spec.Percent/100
+		case spec.Width < 0:
+			w, h = w*float64(spec.Height)/h, float64(spec.Height)
+		case spec.Height < 0:
+			w, h = float64(spec.Width), h*float64(spec.Width)/w
+		default:
+			w, h = float64(spec.Width), float64(spec.Height)
+		}
+		if opts.NoUpscale {
+			if factor := math.Min(float64(width)/w, float64(height)/h); factor < 1 {
+				w, h = w*factor, h*factor
+			}
+		}
+	}
+
+	// Never upscale to meet a maximum
+	factor := 1.0
+	if opts.MaxWidth > 0 && w > float64(opts.MaxWidth) {
+		factor = float64(opts.MaxWidth) / w
+	}
+	if opts.MaxHeight > 0 && h*factor > float64(opts.MaxHeight) {
+		factor = float64(opts.MaxHeight) / h
+	}
+	w, h = w*factor, h*factor
+
+	if !opts.evenSize() {
+		return roundWithin(w, opts.MaxWidth), roundWithin(h, opts.MaxHeight)
+	}
+	return evenWithin(w, opts.MaxWidth), evenWithin(h, opts.MaxHeight)
+}
+
+// noUpscaleSide returns the ffmpeg expression for one side of a -scale size
+// that never exceeds the source's, which is in the variable side.
+func noUpscaleSide(n int, side string) string {
+	if n < 0 {
+		// -1 and -2 follow the other side
+		return strconv.Itoa(n)
+	}
+	return fmt.Sprintf("min(%d\\,%s)", n, side)
+}
+
+// evenSize reports whether the output needs even dimensions, which H.264
+// and HEVC do and APNG and WebP don't.
+func (o Options) evenSize() bool {
+	return o.isVideo()
+}
+
+// roundWithin rounds n to an integer of at least 1, capped at limit.
+func roundWithin(n float64, limit int) int {
+	v := int(math.Round(n))
+	if limit > 0 && v > limit {
+		v = limit
+	}
+	return max(v, 1)
+}
+
+// evenWithin rounds n to an even integer of at least 2, rounding down instead
+// of up when rounding up would exceed limit.
+func evenWithin(n float64, limit int) int {
+	v := makeEven(int(math.Round(n)))
+	if limit > 0 && v > limit {
+		v -= 2
+	}
+	if v < 2 {
+		v = 2
+	}
+	return v
+}
+
+// fitWithin returns the largest size with the aspect ratio of width x height
+// that fits inside boxW x boxH, even unless even is false.
+func fitWithin(width, height, boxW, boxH int, even bool) (int, int) {
+	factor := math.Min(float64(boxW)/float64(width), float64(boxH)/float64(height))
+	if !even {
+		return roundWithin(float64(width)*factor, boxW), roundWithin(float64(height)*factor, boxH)
+	}
+	return evenWithin(float64(width)*factor, boxW), evenWithin(float64(height)*factor, boxH)
+}
+
+// padFilter centres the scaled image on a width x height canvas.
+func padFilter(width, height string, opts Options) string {
+	return fmt.Sprintf("pad=%s:%s:(ow-iw)/2:(oh-ih)/2:color=%s", width, height, opts.PadColor)
+}
+
+// scalers are the scale filter flags accepted by -scaler.
+var scalers = map[string]bool{"lanczos": true, "bicubic": true, "bilinear": true, "neighbor": true}
+
+// scaleFilters returns the crop, rotation and scale filters that produce the
+// requested output size for a width x height source, along with that size.
+// A -crop is applied first, then -rotate and -flip, so the size is worked
+// out from the cropped and rotated region. -denoise runs just before the
+// scale and -sharpen after it, so both work on as few pixels as they can
+// while sharpening the final size.
+func scaleFilters(width, height int, opts Options) ([]string, int, int) {
+	var filters []string
+	if opts.Crop != "" {
+		crop, _ := parseCrop(opts.Crop)
+		if width > 0 && height > 0 {
+			width, height = crop.Width, crop.Height
+		}
+		filters = append(filters, crop.filter())
+	}
+	filters = append(filters, transformFilters(opts)...)
+	if r := opts.rotation(); r == 90 || r == 270 {
+		width, height = height, width
+	}
+	if opts.Denoise {
+		filters = append(filters, denoiseFilter(opts.DenoiseStrength))
+	}
+	size, outW, outH := sizeFilters(width, height, opts)
+	filters = append(filters, size...)
+	if opts.Sharpen {
+		filters = append(filters, sharpenFilter(opts.SharpenAmount))
+	}
+	return filters, outW, outH
+}
+
+// denoiseFilter returns an hqdn3d filter with the given spatial strength,
+// deriving the chroma and temporal strengths the way hqdn3d does when only
+// the first is given.
+func denoiseFilter(strength float64) string {
+	chroma := strength * 3 / 4
+	temporal := strength * 6 / 4
+	return fmt.Sprintf("hqdn3d=%g:%g:%g:%g", strength, chroma, temporal, temporal*chroma/strength)
+}
+
+// sharpenFilter returns a 5x5 unsharp filter that sharpens luma by amount.
+func sharpenFilter(amount float64) string {
+	return fmt.Sprintf("unsharp=5:5:%g:5:5:0", amount)
+}
+
+// sizeFilters returns the scale filters for scaleFilters. When the source
+// dimensions are unknown the size is left to ffmpeg expressions and 0x0 is
+// returned.
+func sizeFilters(width, height int, opts Options) ([]string, int, int) {
+	if width > 0 && height > 0 {
+		outW, outH := targetSize(width, height, opts)
+		if opts.Pad {
+			innerW, innerH := fitWithin(width, height, outW, outH, opts.evenSize())
+			if innerW != outW || innerH != outH {
+				return []string{
+					fmt.Sprintf("scale=%d:%d:flags=%s", innerW, innerH, opts.Scaler),
+					padFilter(strconv.Itoa(outW), strconv.Itoa(outH), opts),
+				}, outW, outH
+			}
+		}
+		if outW == width && outH == height {
+			return nil, outW, outH
+		}
+		return []string{fmt.Sprintf("scale=%d:%d:flags=%s", outW, outH, opts.Scaler)}, outW, outH
+	}
+
+	var filters []string
+	if opts.Scale != "" {
+		spec, _ := parseScale(opts.Scale)
+		if spec.Percent > 0 {
+			f := spec.Percent / 100
+			if !opts.NoUpscale || f < 1 {
+				filters = append(filters, fmt.Sprintf("scale=iw*%g:ih*%g:flags=%s", f, f, opts.Scaler))
+			}
+		} else if opts.NoUpscale && !opts.Pad {
+			filters = append(filters, fmt.Sprintf("scale='%s':'%s':flags=%s", noUpscaleSide(spec.Width, "iw"), noUpscaleSide(spec.Height, "ih"), opts.Scaler))
+		} else if opts.Pad && spec.Width > 0 && spec.Height > 0 {
+			w, h := strconv.Itoa(makeEven(spec.Width)), strconv.Itoa(makeEven(spec.Height))
+			filters = append(filters,
+				fmt.Sprintf("scale=%s:%s:force_original_aspect_ratio=decrease:force_divisible_by=2:flags=%s", w, h, opts.Scaler),
+				padFilter(w, h, opts))
+		} else {
+			filters = append(filters, fmt.Sprintf("scale=%d:%d:flags=%s", spec.Width, spec.Height, opts.Scaler))
+		}
+	}
+	if opts.MaxWidth > 0 || opts.MaxHeight > 0 {
+		maxW, maxH := "iw", "ih"
+		if opts.MaxWidth > 0 {
+			maxW = fmt.Sprintf("min(iw\\,%d)", opts.MaxWidth)
+		}
+		if opts.MaxHeight > 0 {
+			maxH = fmt.Sprintf("min(ih\\,%d)", opts.MaxHeight)
+		}
+		filters = append(filters, fmt.Sprintf("scale='%s':'%s':force_original_aspect_ratio=decrease:flags=%s", maxW, maxH, opts.Scaler))
+	}
+	// If we don't know dimensions, use a filter to ensure even dimensions
+	if opts.evenSize() {
+		filters = append(filters, "scale='trunc(iw/2)*2:trunc(ih/2)*2'")
+	}
+	return filters, 0, 0
+}
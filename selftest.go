@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// selfTestFrames are the colours of the -selftest animation, one solid
+// frame each. The canvas has odd dimensions so the even-size fixup runs.
+var selfTestFrames = []color.NRGBA{
+	{R: 0xe0, G: 0x30, B: 0x30, A: 0xff},
+	{R: 0x30, G: 0xc0, B: 0x30, A: 0xff},
+	{R: 0x30, G: 0x30, B: 0xe0, A: 0xff},
+}
+
+const (
+	selfTestWidth  = 33
+	selfTestHeight = 17
+	// selfTestFrameMs is how long each frame is shown
+	selfTestFrameMs = 100
+)
+
+// runSelfTest converts a small animated WebP generated in memory with opts,
+// checks the result with ffprobe as -verify does, and prints a line per
+// step. It reports whether every step passed.
+func runSelfTest(w io.Writer, opts Options) bool {
+	dir, err := os.MkdirTemp(opts.TempDir, "webp2mp4_selftest_")
+	if err != nil {
+		fmt.Fprintf(w, "FAIL setup: %v\n", err)
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "selftest.webp")
+	if err := os.WriteFile(input, selfTestWebP(), 0o644); err != nil {
+		fmt.Fprintf(w, "FAIL setup: %v\n", err)
+		return false
+	}
+	info, err := inspectWebP(input)
+	if err != nil {
+		fmt.Fprintf(w, "FAIL generate: %v\n", err)
+		return false
+	}
+	fmt.Fprintf(w, "PASS generate: %dx%d, %d frames, %s\n", info.Width, info.Height, info.Frames, info.Duration)
+
+	output := defaultOutput(input, opts.outputExt())
+	opts.Verify, opts.Overwrite, opts.DryRun, opts.Quiet = true, true, false, true
+	start := time.Now()
+	result, err := convertWebPToMP4(input, output, opts)
+	switch {
+	case errors.Is(err, ErrVerifyFailed):
+		fmt.Fprintf(w, "PASS convert: method %s\nFAIL verify: %v\n", result.Method, err)
+		return false
+	case err != nil:
+		fmt.Fprintf(w, "FAIL convert: %v\n", err)
+		return false
+	}
+	fmt.Fprintf(w, "PASS convert: method %s, %s\n", result.Method, time.Since(start).Round(time.Millisecond))
+	fmt.Fprintf(w, "PASS verify: %s\n", result.summary())
+	fmt.Fprintln(w, "\nThe installation works.")
+	return true
+}
+
+// selfTestWebP returns an animated WebP of the selfTestFrames.
+func selfTestWebP() []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02 // animation
+	putLE24(vp8x[4:], selfTestWidth-1)
+	putLE24(vp8x[7:], selfTestHeight-1)
+	writeChunk(&body, "VP8X", vp8x)
+	// Transparent black background, looping forever
+	writeChunk(&body, "ANIM", make([]byte, 6))
+
+	for _, c := range selfTestFrames {
+		frame := make([]byte, 16)
+		putLE24(frame[6:], selfTestWidth-1)
+		putLE24(frame[9:], selfTestHeight-1)
+		putLE24(frame[12:], selfTestFrameMs)
+		frame[15] = 0x02 // don't blend
+		var payload bytes.Buffer
+		writeChunk(&payload, "VP8L", solidVP8L(selfTestWidth, selfTestHeight, c))
+		writeChunk(&body, "ANMF", append(frame, payload.Bytes()...))
+	}
+
+	var riff bytes.Buffer
+	riff.WriteString("RIFF")
+	binary.Write(&riff, binary.LittleEndian, uint32(body.Len()))
+	riff.Write(body.Bytes())
+	return riff.Bytes()
+}
+
+// writeChunk appends a RIFF chunk, padded to an even length.
+func writeChunk(w *bytes.Buffer, id string, data []byte) {
+	w.WriteString(id)
+	binary.Write(w, binary.LittleEndian, uint32(len(data)))
+	w.Write(data)
+	if len(data)%2 != 0 {
+		w.WriteByte(0)
+	}
+}
+
+// solidVP8L encodes a width x height lossless bitstream of a single colour.
+// Each of the five prefix codes has just the one symbol, which takes no
+// bits, so the pixels themselves need no data at all.
+func solidVP8L(width, height int, c color.NRGBA) []byte {
+	var bw bitWriter
+	bw.write(0x2f, 8) // signature
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	bw.write(0, 1) // alpha hint
+	bw.write(0, 3) // version
+	bw.write(0, 1) // no transforms
+	bw.write(0, 1) // no colour cache
+	bw.write(0, 1) // no meta prefix codes
+	// Green, red, blue and alpha as 8-bit simple codes, then distance
+	for _, v := range []uint8{c.G, c.R, c.B, c.A} {
+		bw.write(1, 1) // simple code
+		bw.write(0, 1) // one symbol
+		bw.write(1, 1) // of 8 bits
+		bw.write(uint32(v), 8)
+	}
+	bw.write(1, 1)
+	bw.write(0, 1)
+	bw.write(0, 1) // of 1 bit
+	bw.write(0, 1)
+	return bw.bytes()
+}
+
+// bitWriter packs values least significant bit first, as VP8L reads them.
+type bitWriter struct {
+	buf   []byte
+	nbits uint
+}
+
+func (b *bitWriter) write(v uint32, n uint) {
+	for i := uint(0); i < n; i++ {
+		if b.nbits%8 == 0 {
+			b.buf = append(b.buf, 0)
+		}
+		b.buf[len(b.buf)-1] |= byte(v>>i&1) << (b.nbits % 8)
+		b.nbits++
+	}
+}
+
+func (b *bitWriter) bytes() []byte {
+	return b.buf
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// sequenceVerb matches the frame number in a -sequence pattern, such as %d
+// or %03d.
+var sequenceVerb = regexp.MustCompile(`%0?[0-9]*d`)
+
+// expandSequence lists the numbered WebP files matching pattern, counting up
+// from start until a number is missing.
+func expandSequence(pattern string, start int) ([]string, error) {
+	if len(sequenceVerb.FindAllString(pattern, -1)) != 1 || strings.Count(pattern, "%") != 1 {
+		return nil, fmt.Errorf("invalid -sequence %q (it needs exactly one number verb, e.g. frame_%%03d.webp)", pattern)
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("-start must not be negative")
+	}
+
+	var files []string
+	for n := start; ; n++ {
+		path := fmt.Sprintf(pattern, n)
+		if _, err := os.Stat(path); err != nil {
+			break
+		}
+		files = append(files, path)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: no files match -sequence %s starting at %d", ErrInputNotFound, pattern, start)
+	}
+	if len(files) < 2 {
+		return nil, fmt.Errorf("-sequence %s only matches %s, at least two frames are needed", pattern, files[0])
+	}
+	return files, nil
+}
+
+// sequenceName returns the path outputs of a -sequence are named after: the
+// pattern without its frame number, so frames/shot_%03d.webp gives
+// frames/shot.webp.
+func sequenceName(pattern string) string {
+	loc := sequenceVerb.FindStringIndex(pattern)
+	prefix := strings.TrimRight(pattern[:loc[0]], "_-. ")
+	if prefix == "" || strings.HasSuffix(prefix, string(os.PathSeparator)) {
+		prefix += "sequence"
+	}
+	return prefix + pattern[loc[1]:]
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// serveTimeout bounds how long a single conversion request may take,
+// including the wait for a free worker.
+const serveTimeout = 5 * time.Minute
+
+// contentTypes maps output containers to the Content-Type they are served
+// with.
+var contentTypes = map[string]string{
+	"mp4":  "video/mp4",
+	"m4v":  "video/x-m4v",
+	"mov":  "video/quicktime",
+	"mkv":  "video/x-matroska",
+	"ts":   "video/mp2t",
+	"webm": "video/webm",
+	"png":  "image/apng",
+	"webp": "image/webp",
+}
+
+// converter serves POST /convert, converting the uploaded WebP with the
+// command-line options as defaults.
+type converter struct {
+	opts Options
+	// slots limits how many conversions run at once
+	slots chan struct{}
+}
+
+// runServer listens on addr and converts uploads until the server fails,
+// running at most jobs conversions at a time.
+func runServer(addr string, jobs int, opts Options) error {
+	if jobs < 1 {
+		return errors.New("-j must be at least 1")
+	}
+	// Each request gets its own temp directory, so the output never exists
+	opts.Overwrite = true
+	opts.Progress = false
+
+	mux := http.NewServeMux()
+	mux.Handle("/convert", http.TimeoutHandler(&converter{opts: opts, slots: make(chan struct{}, jobs)}, serveTimeout, "conversion timed out\n"))
+
+	slog.Info("serving conversions", "addr", addr, "endpoint", "/convert", "jobs", jobs)
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 30 * time.Second}
+	return server.ListenAndServe()
+}
+
+// ServeHTTP accepts the WebP as a multipart "file" field or as the raw
+// request body. The fps and b query parameters override -fps and -b, and
+// format sets -container, or -format for "apng" and "webp".
+func (c *converter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST a WebP to convert it", http.StatusMethodNotAllowed)
+		return
+	}
+
+	opts, err := c.requestOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case c.slots <- struct{}{}:
+		defer func() { <-c.slots }()
+	case <-r.Context().Done():
+		return
+	}
+
+	tempDir, err := os.MkdirTemp("", "webp2mp4_serve_*")
+	if err != nil {
+		http.Error(w, "failed to create temp directory", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	input := filepath.Join(tempDir, "input.webp")
+	if err := saveUpload(w, r, input); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	container := strings.ToLower(opts.outputExt())
+	if container == "" {
+		container = "mp4"
+	}
+	output := filepath.Join(tempDir, "output."+container)
+
+	// The TimeoutHandler only replaces the response, so the deadline has to
+	// reach ffmpeg for it to be killed and the slot freed
+	if deadline, ok := r.Context().Deadline(); ok {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return
+		}
+		if opts.Timeout == 0 || left < opts.Timeout {
+			opts.Timeout = left
+		}
+	}
+	result, err := convertWebPToMP4(input, output, opts)
+	if err != nil {
+		slog.Error("conversion failed", "remote", r.RemoteAddr, "err", err)
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrEncodeFailed) || errors.Is(err, ErrVerifyFailed) {
+			status = http.StatusInternalServerError
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	slog.Info("converted upload", "remote", r.RemoteAddr, "frames", result.Frames, "method", result.Method, "elapsed", time.Duration(result.Elapsed*float64(time.Second)))
+
+	file, err := os.Open(output)
+	if err != nil {
+		http.Error(w, "failed to read converted video", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", contentTypes[container])
+	http.ServeContent(w, r, "", time.Time{}, file)
+}
+
+// requestOptions applies the query parameters of r to the server defaults.
+func (c *converter) requestOptions(r *http.Request) (Options, error) {
+	opts := c.opts
+	query := r.URL.Query()
+	if v := query.Get("fps"); v != "" {
+		if err := opts.setFPS(v); err != nil {
+			return opts, fmt.Errorf("invalid fps %q", v)
+		}
+	}
+	if v := query.Get("b"); v != "" {
+		opts.Bitrate = v
+		opts.bitrateSet = true
+	}
+	if v := query.Get("format"); v == "apng" || v == "webp" {
+		opts.Format = v
+	} else if v != "" {
+		opts.Container = v
+	}
+	if err := opts.validate(); err != nil {
+		return opts, err
+	}
+	opts.clampFPS()
+	return opts, nil
+}
+
+// saveUpload writes the uploaded WebP in r to path, capped at
+// maxDownloadSize.
+func saveUpload(w http.ResponseWriter, r *http.Request, path string) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxDownloadSize)
+
+	var body io.Reader = r.Body
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); strings.HasPrefix(mediaType, "multipart/") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return fmt.Errorf("failed to read upload: %w", err)
+		}
+		defer file.Close()
+		body = file
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, body); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+	return out.Close()
+}
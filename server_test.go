@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServeHTTPKillsFFmpegAtDeadline(t *testing.T) {
+	fake := newFakeFFmpeg(t, 8, 8)
+	fake.set("sleep", "30")
+	webp, err := os.ReadFile(animatedInput(t, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &converter{opts: testOptions(), slots: make(chan struct{}, 1)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(webp)).WithContext(ctx)
+	w := httptest.NewRecorder()
+	start := time.Now()
+	c.ServeHTTP(w, r)
+
+	// ffmpeg is killed at the deadline, not left to finish on its own
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("ServeHTTP returned after %s, want shortly after the request's deadline", elapsed)
+	}
+	if w.Code == http.StatusOK {
+		t.Errorf("status = %d, want an error", w.Code)
+	}
+	if len(c.slots) != 0 {
+		t.Error("the conversion slot is still held")
+	}
+	if _, err := os.Stat(filepath.Join(fake.dir, "calls")); err != nil {
+		t.Errorf("ffmpeg never ran: %v", err)
+	}
+}
+
+func TestServeHTTPContentType(t *testing.T) {
+	newFakeFFmpeg(t, 8, 8)
+	webp, err := os.ReadFile(animatedInput(t, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &converter{opts: testOptions(), slots: make(chan struct{}, 1)}
+
+	for format, want := range map[string]string{"": "video/mp4", "mov": "video/quicktime", "webm": "video/webm"} {
+		r := httptest.NewRequest(http.MethodPost, "/convert?format="+format, bytes.NewReader(webp))
+		w := httptest.NewRecorder()
+		c.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("format %q: status = %d %s, want 200", format, w.Code, w.Body)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != want {
+			t.Errorf("format %q: Content-Type = %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestContentTypesCoverContainers(t *testing.T) {
+	for name := range containers {
+		if contentTypes[name] == "" {
+			t.Errorf("no Content-Type for the %s container", name)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// minTargetBitrate is the lowest video bitrate -maxsize picks, in
+	// kbit/s, before warning that quality will suffer
+	minTargetBitrate = 100
+	// muxOverhead is the share of -maxsize reserved for container overhead
+	muxOverhead = 0.05
+	// audioBitrate is the AAC bitrate used for -audio, in kbit/s
+	audioBitrate = 128
+)
+
+// parseSize parses a file size such as "8MB", "500KB", "8M" or plain bytes.
+// Units are powers of 1024.
+func parseSize(s string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSuffix(upper, unit.suffix)
+			multiplier = unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(upper), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q (use e.g. 8MB or 500KB)", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// outputDuration estimates how long the encoded video runs, taking trimming,
+// -loop, -loop-to-duration, -boomerang and -speed into account.
+func outputDuration(info WebPInfo, trim trimRange, opts Options) time.Duration {
+	if info.Frames < 2 {
+		return opts.StillDuration
+	}
+	d := trim.Duration()
+	if opts.InputFPS > 0 {
+		// The frames are assembled at a fixed rate, not their own timing
+		d = time.Duration(float64(trim.Frames()) / opts.InputFPS * float64(time.Second))
+	}
+	d *= time.Duration(opts.Loop + 1)
+	if opts.Boomerang {
+		d *= 2
+	}
+	d = opts.playback(d)
+	if opts.LoopDuration > 0 {
+		return min(d, opts.LoopDuration)
+	}
+	return d
+}
+
+// loopsFor returns the -loop count that makes the animation run for at
+// least -loop-to-duration. The encode is cut at exactly that length.
+func loopsFor(info WebPInfo, trim trimRange, opts Options) (int, error) {
+	target := opts.LoopDuration
+	opts.Loop, opts.LoopDuration = 0, 0
+	once := outputDuration(info, trim, opts)
+	if once <= 0 {
+		return 0, fmt.Errorf("-loop-to-duration needs the animation's duration, but it has none")
+	}
+	plays := (target + once - 1) / once
+	return int(plays) - 1, nil
+}
+
+// targetBitrate returns the video bitrate, in kbit/s, that keeps a video
+// of the given duration within maxSize bytes, leaving room for -audio and
+// container overhead.
+func targetBitrate(maxSize int64, duration time.Duration, opts Options) int {
+	kbits := float64(maxSize) * 8 / 1000 * (1 - muxOverhead)
+	kbps := int(kbits / duration.Seconds())
+	if opts.Audio != "" {
+		kbps -= audioBitrate
+	}
+	if kbps < minTargetBitrate {
+		slog.Warn("-maxsize leaves a very low bitrate, quality will suffer (try -maxwidth or -scale to shrink the video)", "kbps", kbps)
+		kbps = max(kbps, 10)
+	}
+	return kbps
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"time"
+)
+
+// convertStreaming is -stream-frames: frames are decoded in Go, as with
+// -method go-extract, but piped to ffmpeg as raw RGBA instead of being
+// written out as PNGs. Only the frame being encoded is held in memory and
+// nothing touches the disk, which suits very long animations, but the frames
+// can't be reordered, so -reverse and -boomerang need the file-based methods.
+func convertStreaming(input, output string, info WebPInfo, trim trimRange, opts Options, result *Result) error {
+	enc, err := selectEncoder(opts)
+	if err != nil {
+		return err
+	}
+	if opts.MaxSize > 0 {
+		// The frames can only be sent once, -maxsize is just less exact
+		opts.TwoPass = false
+	}
+
+	filters, adjustedWidth, adjustedHeight := scaleFilters(info.Width, info.Height, opts)
+	result.OutputWidth, result.OutputHeight = adjustedWidth, adjustedHeight
+	filters = append(filters, rateFilters(opts)...)
+	filters = append(filters, interpolateFilters(result.SourceFPS, opts)...)
+
+	args := append([]string{}, enc.InputArgs...)
+	args = append(args,
+		"-f", "rawvideo", "-pix_fmt", "rgba",
+		"-video_size", fmt.Sprintf("%dx%d", info.Width, info.Height),
+		"-framerate", divideRate(opts.inputRateArg(), opts.step()),
+		"-i", "pipe:0",
+	)
+	if opts.Speed != 1 {
+		// Resample the retimed frames back to the output frame rate
+		args = append(args, "-r", opts.outputRate())
+	}
+	totalFrames := opts.playbackFrames(trim.Frames() * (opts.Loop + 1))
+
+	if opts.DryRun {
+		fmt.Println("# frames are decoded in Go and piped to ffmpeg")
+		return runEncode(args, encodeArgs(enc, filters, opts), output, opts, totalFrames)
+	}
+
+	reader, writer := io.Pipe()
+	decoded := make(chan error, 1)
+	go func() {
+		err := streamFrames(writer, input, info, trim, opts)
+		writer.CloseWithError(err)
+		decoded <- err
+	}()
+	opts.stdin = reader
+
+	encodeStart := time.Now()
+	err = runEncode(args, encodeArgs(enc, filters, opts), output, opts, totalFrames)
+	result.Timings.Encode += time.Since(encodeStart).Seconds()
+	// Unblock the decoder if ffmpeg stopped reading early
+	reader.Close()
+	decodeErr := <-decoded
+	if decodeErr != nil && !errors.Is(decodeErr, io.ErrClosedPipe) {
+		return fmt.Errorf("failed to decode frames: %w", decodeErr)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrEncodeFailed, err)
+	}
+	return nil
+}
+
+// canStream reports whether the frames can be piped in a single pass, as
+// -stream-frames does.
+func (o Options) canStream() bool {
+	return !o.Reverse && !o.Boomerang && (!o.TwoPass || o.MaxSize > 0) && o.KeepFrames == "" && !o.QualityMetric && len(o.Concat) == 0
+}
+
+// streamFrames writes the frames of input in trim to w as raw RGBA, once
+// per -loop repeat, passing each through the FrameFunc. Only every
+// -framestep frame is written.
+func streamFrames(w io.Writer, input string, info WebPInfo, trim trimRange, opts Options) error {
+	for i := 0; i <= opts.Loop; i++ {
+		err := decodeAnimation(input, func(index int, img image.Image) error {
+			if index < trim.StartFrame {
+				return nil
+			}
+			if index >= trim.EndFrame {
+				return errStopDecoding
+			}
+			if (index-trim.StartFrame)%opts.step() != 0 {
+				return nil
+			}
+			img, err := applyFrameFunc(opts.FrameFunc, index, img)
+			if err != nil {
+				return err
+			}
+			// ffmpeg reads a fixed number of bytes per frame
+			if b := img.Bounds(); b.Dx() != info.Width || b.Dy() != info.Height {
+				return fmt.Errorf("frame %d is %dx%d, not the %dx%d of the canvas", index, b.Dx(), b.Dy(), info.Width, info.Height)
+			}
+			_, err = w.Write(rawRGBA(img))
+			return err
+		})
+		if err != nil && !errors.Is(err, errStopDecoding) {
+			return err
+		}
+	}
+	return nil
+}
+
+// rawRGBA returns the pixels of img as tightly packed, non-premultiplied
+// RGBA, which is ffmpeg's rgba pixel format.
+func rawRGBA(img image.Image) []byte {
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Rect.Min == (image.Point{}) && nrgba.Stride == 4*nrgba.Rect.Dx() {
+		return nrgba.Pix
+	}
+	return cloneImage(img).Pix
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// frameWriter counts the frames written to it, failing t on any write that
+// isn't exactly one frame.
+type frameWriter struct {
+	t      *testing.T
+	size   int
+	frames int
+}
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	if len(p) != w.size {
+		w.t.Errorf("wrote %d bytes at once, want one %d byte frame", len(p), w.size)
+	}
+	w.frames++
+	return len(p), nil
+}
+
+func TestStreamFrames(t *testing.T) {
+	const n = 500
+	input := filepath.Join(t.TempDir(), "long.webp")
+	durations := make([]int, n)
+	for i := range durations {
+		durations[i] = 40
+	}
+	writeTestWebP(t, input, 8, 8, durations...)
+
+	tests := []struct {
+		name      string
+		trim      trimRange
+		loop      int
+		frameStep int
+		want      int
+	}{
+		{"every frame", trimRange{EndFrame: n}, 0, 1, n},
+		{"trimmed", trimRange{StartFrame: 100, EndFrame: 350}, 0, 1, 250},
+		{"looped", trimRange{EndFrame: n}, 2, 1, 3 * n},
+		{"every third frame", trimRange{EndFrame: n}, 0, 3, 167},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions()
+			opts.Loop, opts.FrameStep = tt.loop, tt.frameStep
+			// Frames are written one at a time as they are decoded, never
+			// gathered up first
+			w := &frameWriter{t: t, size: 8 * 8 * 4}
+			if err := streamFrames(w, input, WebPInfo{Width: 8, Height: 8, Frames: n}, tt.trim, opts); err != nil {
+				t.Fatal(err)
+			}
+			if w.frames != tt.want {
+				t.Errorf("streamed %d frames, want %d", w.frames, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertStreaming(t *testing.T) {
+	fake := newFakeFFmpeg(t, 8, 8)
+	dir := t.TempDir()
+	input := animatedInput(t, dir)
+	output := filepath.Join(dir, "out.mp4")
+	opts := testOptions()
+	opts.StreamFrames = true
+	info, trim := inspectTest(t, input, opts)
+
+	if err := convertStreaming(input, output, info, trim, opts, &Result{}); err != nil {
+		t.Fatal(err)
+	}
+	calls := fake.calls()
+	if len(calls) != 1 {
+		t.Fatalf("ffmpeg ran %d times, want once", len(calls))
+	}
+	assertArgs(t, calls[0][:11], []string{"-f", "rawvideo", "-pix_fmt", "rgba", "-video_size", "8x8", "-framerate", "30", "-i", "pipe:0", "-c:v"})
+	stdin, err := os.Stat(filepath.Join(fake.dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(3 * 8 * 8 * 4); stdin.Size() != want {
+		t.Errorf("ffmpeg read %d bytes, want the %d of 3 raw 8x8 frames", stdin.Size(), want)
+	}
+}
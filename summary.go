@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// summary describes a finished conversion for the success message, e.g.
+// "640x480, 3s, 1.2 MB, encoded in 840ms". Details that aren't known, such
+// as the size of a video written to stdout, are left out.
+func (r Result) summary() string {
+	var parts []string
+	if r.OutputWidth > 0 && r.OutputHeight > 0 {
+		parts = append(parts, fmt.Sprintf("%dx%d", r.OutputWidth, r.OutputHeight))
+	}
+	if r.Duration > 0 {
+		parts = append(parts, seconds(r.Duration).String())
+	}
+	if r.Size > 0 {
+		parts = append(parts, formatBytes(r.Size))
+	}
+	if r.ClampedFrames > 0 {
+		parts = append(parts, fmt.Sprintf("%d long frames clamped", r.ClampedFrames))
+	}
+	parts = append(parts, "encoded in "+seconds(r.Timings.Encode).String())
+	return strings.Join(parts, ", ")
+}
+
+// formatBytes formats n with a KB, MB or GB unit, powers of 1024 as in
+// -maxsize.
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
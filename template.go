@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// templateField matches a {placeholder} in an -template.
+var templateField = regexp.MustCompile(`\{[^{}]*\}`)
+
+// templateFields are the placeholders -template understands.
+var templateFields = map[string]bool{"{dir}": true, "{name}": true, "{ext}": true, "{date}": true}
+
+// checkTemplate rejects templates with unknown placeholders or without
+// {name}, which would give every input the same output.
+func checkTemplate(tmpl string) error {
+	for _, field := range templateField.FindAllString(tmpl, -1) {
+		if !templateFields[field] {
+			return fmt.Errorf("unknown %s in -template (use {dir}, {name}, {ext} or {date})", field)
+		}
+	}
+	if !strings.Contains(tmpl, "{name}") {
+		return fmt.Errorf("-template must contain {name}")
+	}
+	return nil
+}
+
+// expandTemplate returns the output path for input. {dir} is the input's
+// directory, {name} its file name without the extension, {ext} the extension
+// without the dot and {date} today's date as YYYY-MM-DD.
+func expandTemplate(tmpl, input string, now time.Time) string {
+	dir, name := ".", input
+	if isURL(input) {
+		name = urlFileName(input)
+	} else {
+		dir, name = filepath.Split(input)
+		dir = filepath.Clean(dir)
+	}
+	ext := filepath.Ext(name)
+
+	return strings.NewReplacer(
+		"{dir}", dir,
+		"{name}", strings.TrimSuffix(name, ext),
+		"{ext}", strings.TrimPrefix(ext, "."),
+		"{date}", now.Format("2006-01-02"),
+	).Replace(tmpl)
+}
+
+// prepareOutputDir creates the parent directories of a templated output.
+func prepareOutputDir(output string) error {
+	if info, err := os.Stat(output); err == nil && info.IsDir() {
+		return fmt.Errorf("-template gives %s, which is a directory", output)
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	return nil
+}
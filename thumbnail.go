@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// errStopDecoding ends decodeAnimation early once the wanted frame is found.
+var errStopDecoding = errors.New("stop decoding")
+
+// thumbnailFrame returns the index of the frame to use as a thumbnail: frame
+// itself, or the frame on screen at the given time when at is non-zero.
+func thumbnailFrame(info WebPInfo, frame int, at time.Duration) (int, error) {
+	if at > 0 {
+		if at >= info.Duration {
+			return 0, fmt.Errorf("-thumbtime %s is beyond the end of the %s animation", at, info.Duration)
+		}
+		var offset time.Duration
+		for i, d := range info.FrameDurations {
+			offset += d
+			if at < offset {
+				return i, nil
+			}
+		}
+		return info.Frames - 1, nil
+	}
+
+	if frame < 0 || frame >= info.Frames {
+		return 0, fmt.Errorf("-thumbframe %d is out of range, the animation has %d frames", frame, info.Frames)
+	}
+	return frame, nil
+}
+
+// writeThumbnail saves a single composited frame of input as a still image.
+// The format follows the extension of output: .jpg/.jpeg for JPEG, PNG
+// otherwise.
+func writeThumbnail(input, output string, frame int, at time.Duration) error {
+	info, err := inspectWebP(input)
+	if err != nil {
+		return err
+	}
+	index, err := thumbnailFrame(info, frame, at)
+	if err != nil {
+		return err
+	}
+
+	var thumb image.Image
+	err = decodeAnimation(input, func(i int, img image.Image) error {
+		if i < index {
+			return nil
+		}
+		// The canvas is reused between frames, so keep a copy
+		thumb = cloneImage(img)
+		return errStopDecoding
+	})
+	if err != nil && !errors.Is(err, errStopDecoding) {
+		return fmt.Errorf("failed to decode thumbnail frame: %w", err)
+	}
+	if thumb == nil {
+		return fmt.Errorf("%w: frame %d", ErrNoFrames, index)
+	}
+
+	if err := writeStill(output, thumb); err != nil {
+		return fmt.Errorf("failed to write thumbnail: %w", err)
+	}
+	return nil
+}
+
+// writeStill saves img in the format the extension of path names:
+// .jpg/.jpeg for JPEG, PNG otherwise. Nothing is left behind on failure.
+func writeStill(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		err = jpeg.Encode(file, img, &jpeg.Options{Quality: 90})
+	default:
+		err = png.Encode(file, img)
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// cloneImage copies img into a new NRGBA image.
+func cloneImage(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	out := image.NewNRGBA(bounds)
+	draw.Draw(out, bounds, img, bounds.Min, draw.Src)
+	return out
+}
+
+// makeThumbnail writes the -thumbnail still for input, honouring the same
+// overwrite rules as the video output.
+func makeThumbnail(input, output string, frame int, at time.Duration, opts Options) error {
+	if opts.DryRun {
+		fmt.Printf("# thumbnail of %s -> %s\n", input, output)
+		return nil
+	}
+	if err := checkOutput(output, &opts); err != nil {
+		return err
+	}
+	slog.Debug("writing thumbnail", "output", output, "frame", frame, "time", at)
+	return writeThumbnail(input, output, frame, at)
+}
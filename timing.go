@@ -0,0 +1,107 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// capDurations returns durations with each one longer than limit cut down to
+// it, and how many were, leaving durations itself alone. A limit of 0 keeps
+// them all.
+func capDurations(durations []time.Duration, limit time.Duration) ([]time.Duration, int) {
+	if limit <= 0 {
+		return durations, 0
+	}
+	capped := make([]time.Duration, len(durations))
+	n := 0
+	for i, d := range durations {
+		if d > limit {
+			d = limit
+			n++
+		}
+		capped[i] = d
+	}
+	return capped, n
+}
+
+// smoothTolerance is how far -smooth-timing lets a frame start from where
+// the source times it. Every rate from 200 fps up is within it, so the
+// search below always ends.
+const smoothTolerance = 2500 * time.Microsecond
+
+// smoothTiming is -smooth-timing: it picks an output frame rate whose frame
+// grid fits the durations of entries, as played at speed, and snaps each
+// entry to that grid, so every frame is duplicated a whole number of times
+// instead of the fps filter rounding each one on its own. It returns the
+// rate as passed to ffmpeg, the snapped entries and how many frames one
+// play of them encodes to.
+func smoothTiming(entries []concatEntry, speed float64) (string, []concatEntry, int) {
+	durations := make([]time.Duration, len(entries))
+	for i, entry := range entries {
+		durations[i] = time.Duration(float64(entry.Duration) / speed)
+	}
+	rate := smoothTimingRate(durations)
+	fps, _ := parseFrameRate(rate)
+
+	snapped := make([]concatEntry, len(entries))
+	var elapsed time.Duration
+	prev := 0.0
+	for i, entry := range entries {
+		elapsed += durations[i]
+		tick := math.Round(elapsed.Seconds() * fps)
+		shown := time.Duration((tick - prev) / fps * speed * float64(time.Second))
+		snapped[i] = concatEntry{File: entry.File, Duration: shown}
+		prev = tick
+	}
+	return rate, snapped, int(prev)
+}
+
+// smoothTimingRate returns the lowest frame rate on which every frame of
+// durations starts within smoothTolerance of its own time and none is
+// dropped. When the durations share a divisor that gives a usable rate,
+// such as 50ms for frames of 100ms and 150ms, that rate fits exactly.
+func smoothTimingRate(durations []time.Duration) string {
+	var divisor time.Duration
+	for _, d := range durations {
+		divisor = gcdDuration(divisor, d)
+	}
+	if divisor > 0 && float64(time.Second)/float64(divisor) <= maxFPS {
+		return durationRate(divisor)
+	}
+	for fps := 1; fps < maxFPS; fps++ {
+		if gridFits(durations, float64(fps)) {
+			return strconv.Itoa(fps)
+		}
+	}
+	return strconv.Itoa(maxFPS)
+}
+
+// gridFits reports whether frames of durations played on a fps grid each
+// start within smoothTolerance of their own time, and whether every frame
+// longer than the tolerance allows for still gets at least one tick.
+func gridFits(durations []time.Duration, fps float64) bool {
+	var elapsed time.Duration
+	prev := 0.0
+	for _, d := range durations {
+		elapsed += d
+		tick := math.Round(elapsed.Seconds() * fps)
+		snapped := time.Duration(tick / fps * float64(time.Second))
+		if (snapped - elapsed).Abs() > smoothTolerance {
+			return false
+		}
+		if tick == prev && d > 2*smoothTolerance {
+			return false
+		}
+		prev = tick
+	}
+	return true
+}
+
+// gcdDuration returns the greatest common divisor of a and b.
+func gcdDuration(a, b time.Duration) time.Duration {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a.Abs()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Timings records how long each stage of a conversion took, in seconds.
+// Extract is 0 when frames were not extracted.
+type Timings struct {
+	Inspect float64 `json:"inspect_seconds"`
+	Extract float64 `json:"extract_seconds"`
+	Encode  float64 `json:"encode_seconds"`
+}
+
+// seconds converts a duration in seconds back to a time.Duration, rounded
+// for display.
+func seconds(s float64) time.Duration {
+	d := time.Duration(s * float64(time.Second))
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(time.Millisecond)
+}
+
+// String formats the stages for -timings, e.g.
+// "inspect 2ms, extract 310ms, encode 1.2s".
+func (t Timings) String() string {
+	stages := []string{"inspect " + seconds(t.Inspect).String()}
+	if t.Extract > 0 {
+		stages = append(stages, "extract "+seconds(t.Extract).String())
+	}
+	stages = append(stages, "encode "+seconds(t.Encode).String())
+	return strings.Join(stages, ", ")
+}
+
+// printBatchTimings prints the time spent on each stage across a batch,
+// along with the average and slowest file.
+func printBatchTimings(results []batchResult) {
+	var total Timings
+	var elapsed time.Duration
+	var slowest batchResult
+	converted := 0
+	for _, r := range results {
+		if r.Err != nil || r.Skipped {
+			continue
+		}
+		converted++
+		total.Inspect += r.Result.Timings.Inspect
+		total.Extract += r.Result.Timings.Extract
+		total.Encode += r.Result.Timings.Encode
+		elapsed += r.Elapsed
+		if r.Elapsed > slowest.Elapsed {
+			slowest = r
+		}
+	}
+	if converted == 0 {
+		return
+	}
+
+	fmt.Printf("Timings: %s (summed over %d files)\n", total, converted)
+	fmt.Printf("  average %s per file, slowest %s (%s)\n",
+		(elapsed / time.Duration(converted)).Round(time.Millisecond), slowest.Input, slowest.Elapsed.Round(time.Millisecond))
+}
@@ -0,0 +1,27 @@
+package main
+
+// rotation returns -rotate as clockwise degrees between 0 and 270.
+func (o Options) rotation() int {
+	return (o.Rotate%360 + 360) % 360
+}
+
+// transformFilters returns the filters for -rotate and -flip. The flip is
+// applied to the rotated image.
+func transformFilters(opts Options) []string {
+	var filters []string
+	switch opts.rotation() {
+	case 90:
+		filters = append(filters, "transpose=clock")
+	case 180:
+		filters = append(filters, "hflip", "vflip")
+	case 270:
+		filters = append(filters, "transpose=cclock")
+	}
+	switch opts.Flip {
+	case "h":
+		filters = append(filters, "hflip")
+	case "v":
+		filters = append(filters, "vflip")
+	}
+	return filters
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// trimRange is the part of an animation selected by -ss/-to or
+// -startframe/-endframe. Frames are 0-based and End is exclusive.
+type trimRange struct {
+	StartFrame int
+	EndFrame   int
+	Start      time.Duration
+	End        time.Duration
+}
+
+// Frames returns the number of frames in the range.
+func (r trimRange) Frames() int {
+	return r.EndFrame - r.StartFrame
+}
+
+// Duration returns the length of the range.
+func (r trimRange) Duration() time.Duration {
+	return r.End - r.Start
+}
+
+// percentRange is a -range selection, as percentages of the animation.
+// End is 0 when no range was given.
+type percentRange struct {
+	Start float64
+	End   float64
+}
+
+// parseRange parses a -range value such as "40%:60%". The % signs are
+// optional.
+func parseRange(expr string) (percentRange, error) {
+	start, end, ok := strings.Cut(expr, ":")
+	from, errStart := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(start), "%"), 64)
+	to, errEnd := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(end), "%"), 64)
+	if !ok || errStart != nil || errEnd != nil {
+		return percentRange{}, fmt.Errorf("invalid -range %q: expected START%%:END%%, e.g. 40%%:60%%", expr)
+	}
+	if from < 0 || to > 100 || from >= to {
+		return percentRange{}, fmt.Errorf("invalid -range %q: the start must come before the end, both from 0%% to 100%%", expr)
+	}
+	return percentRange{Start: from, End: to}, nil
+}
+
+// trimmed reports whether any trimming was requested.
+func (o Options) trimmed() bool {
+	return o.TrimStart > 0 || o.TrimEnd > 0 || o.StartFrame > 0 || o.EndFrame >= 0 || o.Range.End > 0
+}
+
+// rangeTrim turns a -range into the -ss and -to it amounts to for info, or
+// into -startframe and -endframe when the animation has no timing.
+func rangeTrim(info WebPInfo, opts Options) Options {
+	from, to := opts.Range.Start/100, opts.Range.End/100
+	if info.Duration > 0 {
+		opts.TrimStart = time.Duration(from * float64(info.Duration))
+		opts.TrimEnd = time.Duration(to * float64(info.Duration))
+		return opts
+	}
+	opts.StartFrame = min(int(from*float64(info.Frames)), info.Frames-1)
+	opts.EndFrame = max(int(math.Ceil(to*float64(info.Frames)))-1, opts.StartFrame)
+	return opts
+}
+
+// resolveTrim converts the requested trim into both frame indices and times
+// using the animation's per-frame durations, and checks it lies within the
+// animation.
+func resolveTrim(info WebPInfo, opts Options) (trimRange, error) {
+	r := trimRange{EndFrame: info.Frames, End: info.Duration}
+	if !opts.trimmed() {
+		return r, nil
+	}
+	if opts.Range.End > 0 {
+		opts = rangeTrim(info, opts)
+	}
+
+	// Frame start offsets, with the total duration as the final entry
+	offsets := make([]time.Duration, info.Frames+1)
+	for i := 0; i < info.Frames; i++ {
+		var d time.Duration
+		if i < len(info.FrameDurations) {
+			d = info.FrameDurations[i]
+		}
+		offsets[i+1] = offsets[i] + d
+	}
+
+	if opts.StartFrame > 0 || opts.EndFrame >= 0 {
+		if opts.StartFrame >= info.Frames {
+			return r, fmt.Errorf("-startframe %d is out of range, the animation has %d frames", opts.StartFrame, info.Frames)
+		}
+		if opts.EndFrame >= info.Frames {
+			return r, fmt.Errorf("-endframe %d is out of range, the animation has %d frames", opts.EndFrame, info.Frames)
+		}
+		r.StartFrame = opts.StartFrame
+		if opts.EndFrame >= 0 {
+			if opts.EndFrame < opts.StartFrame {
+				return r, fmt.Errorf("-endframe %d is before -startframe %d", opts.EndFrame, opts.StartFrame)
+			}
+			r.EndFrame = opts.EndFrame + 1
+		}
+		r.Start, r.End = offsets[r.StartFrame], offsets[r.EndFrame]
+		return r, nil
+	}
+
+	if opts.TrimStart >= info.Duration {
+		return r, fmt.Errorf("-ss %s is beyond the end of the %s animation", opts.TrimStart, info.Duration)
+	}
+	if opts.TrimEnd > info.Duration {
+		return r, fmt.Errorf("-to %s is beyond the end of the %s animation", opts.TrimEnd, info.Duration)
+	}
+	r.Start = opts.TrimStart
+	if opts.TrimEnd > 0 {
+		if opts.TrimEnd <= opts.TrimStart {
+			return r, fmt.Errorf("-to %s must be after -ss %s", opts.TrimEnd, opts.TrimStart)
+		}
+		r.End = opts.TrimEnd
+	}
+
+	// Include every frame that is on screen during [Start, End)
+	r.StartFrame, r.EndFrame = -1, 0
+	for i := 0; i < info.Frames; i++ {
+		if offsets[i+1] > r.Start && r.StartFrame < 0 {
+			r.StartFrame = i
+		}
+		if offsets[i] < r.End {
+			r.EndFrame = i + 1
+		}
+	}
+	if r.StartFrame < 0 || r.EndFrame <= r.StartFrame {
+		return r, fmt.Errorf("no frames fall between -ss %s and -to %s", r.Start, r.End)
+	}
+
+	return r, nil
+}
+
+// parseTimestamp parses a time offset given as seconds ("1.5"), a Go
+// duration ("1500ms") or [hh:]mm:ss[.fff].
+func parseTimestamp(s string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		if secs < 0 {
+			return 0, fmt.Errorf("time must not be negative")
+		}
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < 0 {
+			return 0, fmt.Errorf("time must not be negative")
+		}
+		return d, nil
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q (use seconds, 1500ms or hh:mm:ss.fff)", s)
+	}
+	var total float64
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid time %q (use seconds, 1500ms or hh:mm:ss.fff)", s)
+		}
+		total = total*60 + v
+	}
+	return time.Duration(total * float64(time.Second)), nil
+}
+
+// ffmpegSeconds formats d for ffmpeg's time options.
+func ffmpegSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
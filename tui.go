@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// selectBatch is -tui: it lists inputs with their size and length, and
+// reads commands from r that toggle which of them are converted and change
+// -fps and -b, until an empty line starts the batch. It returns the chosen
+// inputs, or none if the user quit. The listing is plain text redrawn after
+// every command, so any terminal will do.
+func selectBatch(r io.Reader, w io.Writer, inputs []string, opts *Options) []string {
+	selected := make([]bool, len(inputs))
+	for i := range selected {
+		selected[i] = true
+	}
+	details := make([]string, len(inputs))
+	for i, in := range inputs {
+		if info, err := inspectCached(in); err != nil {
+			details[i] = fmt.Sprintf("can't be read: %v", err)
+		} else {
+			details[i] = fmt.Sprintf("%dx%d, %d frames, %s", info.Width, info.Height, info.Frames, info.Duration)
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	message := ""
+	for {
+		count := 0
+		for i, in := range inputs {
+			mark := " "
+			if selected[i] {
+				mark, count = "x", count+1
+			}
+			fmt.Fprintf(w, "%3d [%s] %s  %s\n", i+1, mark, filepath.Base(in), details[i])
+		}
+		fmt.Fprintf(w, "\n%d of %d selected, -fps %s, -b %s\n", count, len(inputs), opts.fpsArg(), opts.Bitrate)
+		if message != "" {
+			fmt.Fprintln(w, message)
+		}
+		fmt.Fprint(w, "Toggle files by number or range (1 3 5-7), a for all, n for none,\nfps RATE or b BITRATE to change them, enter to start, q to quit: ")
+		if !scanner.Scan() {
+			fmt.Fprintln(w)
+			return nil
+		}
+
+		fields := strings.Fields(scanner.Text())
+		message = ""
+		switch {
+		case len(fields) == 0:
+			var chosen []string
+			for i, in := range inputs {
+				if selected[i] {
+					chosen = append(chosen, in)
+				}
+			}
+			if len(chosen) > 0 {
+				return chosen
+			}
+			message = "Nothing is selected."
+		case fields[0] == "q":
+			return nil
+		case fields[0] == "a" || fields[0] == "n":
+			for i := range selected {
+				selected[i] = fields[0] == "a"
+			}
+		case fields[0] == "fps" || fields[0] == "b":
+			if len(fields) != 2 {
+				message = fmt.Sprintf("%s takes one value", fields[0])
+				break
+			}
+			if err := setBatchOption(opts, fields[0], fields[1]); err != nil {
+				message = err.Error()
+			}
+		default:
+			// One bad number leaves the selection as it was, rather than
+			// applying half of the command
+			ranges := make([][2]int, 0, len(fields))
+			for _, field := range fields {
+				first, last, err := parseSelection(field, len(inputs))
+				if err != nil {
+					message = err.Error()
+					ranges = nil
+					break
+				}
+				ranges = append(ranges, [2]int{first, last})
+			}
+			for _, r := range ranges {
+				for i := r[0]; i <= r[1]; i++ {
+					selected[i-1] = !selected[i-1]
+				}
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// setBatchOption sets -fps or -b, as name is fps or b, to value for
+// selectBatch, leaving opts as it was if the result doesn't validate.
+func setBatchOption(opts *Options, name, value string) error {
+	changed := *opts
+	if name == "fps" {
+		if err := changed.setFPS(value); err != nil {
+			return err
+		}
+	} else {
+		changed.Bitrate, changed.bitrateSet = value, true
+	}
+	if err := changed.validate(); err != nil {
+		return err
+	}
+	changed.clampFPS()
+	*opts = changed
+	return nil
+}
+
+// parseSelection parses a file number or an inclusive range of them such as
+// 5-7, out of n.
+func parseSelection(s string, n int) (first, last int, err error) {
+	from, to, isRange := strings.Cut(s, "-")
+	first, err = strconv.Atoi(from)
+	last = first
+	if err == nil && isRange {
+		last, err = strconv.Atoi(to)
+	}
+	if err != nil || first < 1 || last < first || last > n {
+		return 0, 0, fmt.Errorf("%q isn't a file number or range from 1 to %d", s, n)
+	}
+	return first, last, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSelectBatch(t *testing.T) {
+	dir := t.TempDir()
+	inputs := make([]string, 5)
+	for i := range inputs {
+		inputs[i] = filepath.Join(dir, string(rune('a'+i))+".webp")
+		writeTestWebP(t, inputs[i], 8, 8, 100, 100)
+	}
+	tests := []struct {
+		name     string
+		commands string
+		want     []string
+	}{
+		{"all by default", "\n", inputs},
+		{"toggle", "1 3-4\n\n", []string{inputs[1], inputs[4]}},
+		// Nothing of a command with a bad number is applied
+		{"bad number", "1 x 3\n\n", inputs},
+		{"out of range", "2 6\n\n", inputs},
+		{"none then some", "n\n2\n\n", []string{inputs[1]}},
+		{"nothing selected", "n\n\n5\n\n", []string{inputs[4]}},
+		{"quit", "1\nq\n", nil},
+		{"end of input", "1\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions()
+			got := selectBatch(strings.NewReader(tt.commands), io.Discard, inputs, &opts)
+			assertArgs(t, got, tt.want)
+		})
+	}
+}
+
+func TestSelectBatchOptions(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "a.webp")
+	writeTestWebP(t, input, 8, 8, 100, 100)
+	opts := testOptions()
+	var out strings.Builder
+
+	selectBatch(strings.NewReader("fps 24\nb 3M\nfps -1\nb\n\n"), &out, []string{input}, &opts)
+	if opts.FPS != 24 || opts.Bitrate != "3M" {
+		t.Errorf("-fps %g -b %s, want -fps 24 -b 3M", opts.FPS, opts.Bitrate)
+	}
+	if !strings.Contains(out.String(), "b takes one value") {
+		t.Errorf("a missing value wasn't reported:\n%s", out.String())
+	}
+}
+
+func TestParseSelection(t *testing.T) {
+	tests := []struct {
+		in          string
+		first, last int
+		ok          bool
+	}{
+		{"1", 1, 1, true},
+		{"5", 5, 5, true},
+		{"2-4", 2, 4, true},
+		{"3-3", 3, 3, true},
+		{"0", 0, 0, false},
+		{"6", 0, 0, false},
+		{"4-2", 0, 0, false},
+		{"2-6", 0, 0, false},
+		{"x", 0, 0, false},
+		{"2-", 0, 0, false},
+	}
+	for _, tt := range tests {
+		first, last, err := parseSelection(tt.in, 5)
+		if first != tt.first || last != tt.last || (err == nil) != tt.ok {
+			t.Errorf("parseSelection(%q, 5) = %d, %d, %v", tt.in, first, last, err)
+		}
+	}
+}
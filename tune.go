@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// x264Tunes are the -tune values, libx264's content tunings.
+var x264Tunes = map[string]bool{
+	"film": true, "animation": true, "grain": true, "stillimage": true,
+	"fastdecode": true, "zerolatency": true,
+}
+
+// x265Tunes are the -tune values for -codec hevc, which libx265 names
+// differently: it has no film or stillimage, but can tune for the PSNR and
+// SSIM metrics.
+var x265Tunes = map[string]bool{
+	"animation": true, "grain": true, "psnr": true, "ssim": true,
+	"fastdecode": true, "zerolatency": true,
+}
+
+// checkTune rejects a -tune that the software encoder for codec doesn't
+// have, listing the ones it does.
+func checkTune(tune, codec string) error {
+	tunes, encoder := x264Tunes, softwareEncoder.Name
+	switch codec {
+	case "hevc":
+		tunes, encoder = x265Tunes, hevcEncoder.Name
+	case "vp9":
+		if tune != "" {
+			return fmt.Errorf("-tune doesn't apply to -codec vp9, libvpx-vp9 has no tunings")
+		}
+	}
+	if tune == "" || tunes[tune] {
+		return nil
+	}
+	names := make([]string, 0, len(tunes))
+	for name := range tunes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("invalid -tune %q for %s (use %s)", tune, encoder, strings.Join(names, ", "))
+}
+
+// losslessBitrate is the -b that -tune-source uses for lossless sources,
+// whose flat colors and hard edges show artifacts at the default 2M.
+const losslessBitrate = "4M"
+
+// tuneForSource adjusts the defaults for -tune-source to how input was
+// compressed. Lossless WebPs are usually drawn art, so they get libx264's
+// animation tuning, when libx264 is the encoder, and a higher bitrate. An
+// explicit -tune or -b wins, and -maxsize still sets the bitrate.
+func tuneForSource(info WebPInfo, opts Options) Options {
+	if info.Compression != "lossless" {
+		return opts
+	}
+	if enc, err := selectEncoder(opts); opts.Tune == "" && err == nil && (enc.Name == softwareEncoder.Name || enc.Name == hevcEncoder.Name) {
+		opts.Tune = "animation"
+	}
+	if !opts.bitrateSet {
+		opts.Bitrate = losslessBitrate
+	}
+	if info.Alpha && opts.Format == "video" {
+		slog.Info("source is lossless with transparency, which H.264 can't keep (-format apng keeps it)")
+	}
+	slog.Debug("tuned for lossless source", "tune", opts.Tune, "bitrate", opts.Bitrate)
+	return opts
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// verifyTolerance is how far, as a fraction, -verify lets the output's
+// duration stray from the expected one. Frame timing is rounded to -fps, so
+// a few frames' worth is always allowed on top.
+const verifyTolerance = 0.1
+
+// verifyOutput checks that ffmpeg really wrote output: that it exists and
+// isn't empty and, with -verify, that ffprobe finds a video stream of about
+// the expected duration in it. -verify-duration allows a single output
+// frame's worth of difference instead.
+func verifyOutput(output string, result Result, opts Options) error {
+	stat, err := os.Stat(output)
+	if err != nil {
+		return fmt.Errorf("%w: output was not written: %w", ErrVerifyFailed, err)
+	}
+	if stat.Size() == 0 {
+		return fmt.Errorf("%w: output %s is empty", ErrVerifyFailed, output)
+	}
+	if !opts.Verify && !opts.VerifyDuration {
+		return nil
+	}
+
+	out, err := exec.Command(ffprobePath(), "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=codec_type:format=duration", "-of", "default=noprint_wrappers=1", ffmpegFile(output)).Output()
+	if err != nil {
+		return fmt.Errorf("%w: ffprobe can't read %s: %w", ErrVerifyFailed, output, err)
+	}
+	fields := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			fields[key] = value
+		}
+	}
+	if fields["codec_type"] != "video" {
+		return fmt.Errorf("%w: %s has no video stream", ErrVerifyFailed, output)
+	}
+
+	duration, err := strconv.ParseFloat(fields["duration"], 64)
+	if opts.VerifyDuration {
+		if err != nil || result.Duration <= 0 {
+			return fmt.Errorf("%w: -verify-duration can't compare %s, ffprobe reports no duration (%q) or the source has none", ErrVerifyFailed, output, fields["duration"])
+		}
+		// Frames start on the output's frame grid, plus the container's
+		// millisecond rounding
+		slack := 1/opts.outputFPS() + 0.001
+		if diff := duration - result.Duration; math.Abs(diff) > slack {
+			return fmt.Errorf("%w: %s is %.3fs long, but the source frames add up to %.3fs (%+.3fs, more than one frame at %g fps)", ErrVerifyFailed, output, duration, result.Duration, diff, opts.outputFPS())
+		}
+		slog.Debug("verified output duration", "output", output, "duration", duration, "expected", result.Duration)
+		return nil
+	}
+	if err != nil || result.Duration <= 0 {
+		// Some containers don't record one, and stills have no source duration
+		slog.Debug("verified output", "output", output, "duration", fields["duration"])
+		return nil
+	}
+	slack := result.Duration*verifyTolerance + 3/opts.FPS
+	if math.Abs(duration-result.Duration) > slack {
+		return fmt.Errorf("%w: %s is %.3fs long, expected %.3fs", ErrVerifyFailed, output, duration, result.Duration)
+	}
+	slog.Debug("verified output", "output", output, "duration", duration)
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+)
+
+// Build information, set at link time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+// printVersion writes the build information and the detected ffmpeg version.
+func printVersion(w io.Writer) {
+	rev, built := commit, date
+	// Fall back to the VCS stamp Go embeds when built from a checkout
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && rev == "unknown":
+				rev = setting.Value
+			case setting.Key == "vcs.time" && built == "unknown":
+				built = setting.Value
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "webp2mp4 %s (commit %s, built %s)\n", version, rev, built)
+
+	ffmpeg := checkTool("ffmpeg", ffmpegPath, "required", "-version", versionField(2))
+	switch {
+	case ffmpeg.Path == "":
+		fmt.Fprintln(w, "ffmpeg: not found")
+	case ffmpeg.Version == "":
+		fmt.Fprintf(w, "ffmpeg: unknown version (%s)\n", ffmpeg.Path)
+	default:
+		fmt.Fprintf(w, "ffmpeg: %s (%s)\n", ffmpeg.Version, ffmpeg.Path)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"strings"
+)
+
+// watermarkMargin is the distance in pixels between a watermark and the
+// edges of the video.
+const watermarkMargin = 10
+
+// watermarkPositions maps -watermark-pos values to overlay coordinates.
+var watermarkPositions = map[string]string{
+	"top-left":     fmt.Sprintf("%d:%d", watermarkMargin, watermarkMargin),
+	"top-right":    fmt.Sprintf("W-w-%d:%d", watermarkMargin, watermarkMargin),
+	"bottom-left":  fmt.Sprintf("%d:H-h-%d", watermarkMargin, watermarkMargin),
+	"bottom-right": fmt.Sprintf("W-w-%d:H-h-%d", watermarkMargin, watermarkMargin),
+	"center":       "(W-w)/2:(H-h)/2",
+}
+
+// checkWatermark verifies the -watermark image exists and is a format that
+// can be decoded (PNG, JPEG or WebP).
+func checkWatermark(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open watermark: %w", err)
+	}
+	defer file.Close()
+
+	if _, _, err := image.DecodeConfig(file); err != nil {
+		return fmt.Errorf("unsupported watermark image %s: use PNG, JPEG or WebP", path)
+	}
+	return nil
+}
+
+// watermarkGraph returns a -filter_complex graph that runs chain on the
+// video, overlays the watermark and then applies post, usually filters the
+// encoder requires. The watermark is input 0. The result is labelled [vout].
+func watermarkGraph(chain, post []string, opts Options) string {
+	var b strings.Builder
+	b.WriteString("[0:v]format=rgba")
+	if opts.WatermarkOpacity < 1 {
+		fmt.Fprintf(&b, ",colorchannelmixer=aa=%g", opts.WatermarkOpacity)
+	}
+	fmt.Fprintf(&b, "[wm];[%d:v]", videoInput(opts))
+	if len(chain) > 0 {
+		b.WriteString(strings.Join(chain, ",") + "[base];[base]")
+	}
+	b.WriteString("[wm]overlay=" + watermarkPositions[opts.WatermarkPos])
+	if len(post) > 0 {
+		b.WriteString("," + strings.Join(post, ","))
+	}
+	b.WriteString("[vout]")
+	return b.String()
+}
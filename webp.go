@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// validateWebPHeader checks that input starts with a RIFF WEBP header and
+// is as long as the header says, so empty files and interrupted downloads
+// are reported as such rather than as an ffmpeg error.
+func validateWebPHeader(input string) error {
+	file, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() == 0 {
+		return fmt.Errorf("%w: %s is empty", ErrCorruptInput, input)
+	}
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return fmt.Errorf("%w: %s is only %d bytes", ErrCorruptInput, input, stat.Size())
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return fmt.Errorf("%w: %s has no RIFF WEBP header", ErrCorruptInput, input)
+	}
+	// The RIFF size covers everything after the size field itself
+	if want := 8 + int64(binary.LittleEndian.Uint32(header[4:8])); stat.Size() < want {
+		return fmt.Errorf("%w: %s is %d bytes, but its header says %d", ErrCorruptInput, input, stat.Size(), want)
+	}
+	return nil
+}
+
+// webpChunk is a single top-level chunk of a WebP RIFF container.
+type webpChunk struct {
+	ID   string
+	Data []byte
+}
+
+// readWebPChunks reads the top-level chunks of a WebP RIFF container.
+func readWebPChunks(filename string) ([]webpChunk, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("not a WebP file: %s", filename)
+	}
+
+	// The RIFF size covers everything after the size field itself
+	end := 8 + int(binary.LittleEndian.Uint32(data[4:8]))
+	if end > len(data) {
+		end = len(data)
+	}
+
+	var chunks []webpChunk
+	pos := 12
+	for pos+8 <= end {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		pos += 8
+		if size > end-pos {
+			return chunks, fmt.Errorf("truncated %s chunk in %s", id, filename)
+		}
+		chunks = append(chunks, webpChunk{ID: id, Data: data[pos : pos+size]})
+		// Chunks are padded to an even size
+		pos += size + size&1
+	}
+
+	return chunks, nil
+}
+
+// WebPInfo describes the contents of a WebP file.
+type WebPInfo struct {
+	Width    int
+	Height   int
+	Frames   int
+	Duration time.Duration
+	// Loops reports whether the animation repeats. LoopCount is the number
+	// of times it plays, with 0 meaning forever.
+	Loops     bool
+	LoopCount int
+	// Alpha reports whether the image has an alpha channel
+	Alpha bool
+	// FrameDurations holds the display time of each animation frame
+	FrameDurations []time.Duration
+	// Compression is "lossless" when every frame is VP8L, "lossy" when
+	// every frame is VP8 and "mixed" otherwise
+	Compression string
+	// ICCProfile is the size of the embedded ICC colour profile, 0 if
+	// there is none
+	ICCProfile int
+	// Orientation is the EXIF orientation, 1 (upright) to 8, or 0 if the
+	// file has none
+	Orientation int
+}
+
+// inspectWebP parses the container of a WebP file and reports its canvas
+// dimensions, frame count, total duration and looping behaviour. Files that
+// aren't WebP at all are rejected.
+func inspectWebP(input string) (WebPInfo, error) {
+	chunks, err := readWebPChunks(input)
+	if err != nil {
+		return WebPInfo{}, err
+	}
+
+	var info WebPInfo
+	durationMs := 0
+	lossless, lossy := 0, 0
+	for _, chunk := range chunks {
+		switch chunk.ID {
+		case "VP8X":
+			if len(chunk.Data) >= 10 {
+				info.Alpha = chunk.Data[0]&0x10 != 0
+				info.Width = le24(chunk.Data[4:7]) + 1
+				info.Height = le24(chunk.Data[7:10]) + 1
+			}
+		case "ICCP":
+			info.ICCProfile = len(chunk.Data)
+		case "EXIF":
+			info.Orientation = exifOrientation(chunk.Data)
+		case "ANIM":
+			if len(chunk.Data) >= 6 {
+				info.LoopCount = int(binary.LittleEndian.Uint16(chunk.Data[4:6]))
+				info.Loops = info.LoopCount != 1
+			}
+		case "ANMF":
+			if len(chunk.Data) >= 16 {
+				ms := le24(chunk.Data[12:15])
+				info.Frames++
+				info.FrameDurations = append(info.FrameDurations, time.Duration(ms)*time.Millisecond)
+				durationMs += ms
+				switch frameBitstream(chunk.Data[16:]) {
+				case "VP8L":
+					lossless++
+				case "VP8 ":
+					lossy++
+				}
+			}
+		// Chunk IDs are four characters, so the lossy one ends in a space
+		case "VP8 ", "VP8L":
+			if chunk.ID == "VP8L" {
+				lossless++
+			} else {
+				lossy++
+			}
+			// In a simple lossless file the header carries the alpha hint
+			if chunk.ID == "VP8L" && len(chunk.Data) >= 5 && binary.LittleEndian.Uint32(chunk.Data[1:5])&(1<<28) != 0 {
+				info.Alpha = true
+			}
+			if info.Width == 0 {
+				info.Width, info.Height, err = bitstreamDimensions(chunk)
+				if err != nil {
+					return info, err
+				}
+			}
+		}
+	}
+
+	// A file without ANMF chunks is a still image
+	if info.Frames == 0 {
+		info.Frames = 1
+	}
+	info.Duration = time.Duration(durationMs) * time.Millisecond
+	switch {
+	case lossy == 0 && lossless > 0:
+		info.Compression = "lossless"
+	case lossless == 0 && lossy > 0:
+		info.Compression = "lossy"
+	case lossless > 0:
+		info.Compression = "mixed"
+	}
+
+	if info.Width == 0 || info.Height == 0 {
+		return info, fmt.Errorf("could not determine dimensions of %s", input)
+	}
+
+	return info, nil
+}
+
+// frameBitstream returns the ID of the image chunk, VP8 or VP8L, in the
+// frame data of an ANMF chunk, skipping the ALPH chunk lossy frames carry
+// their transparency in. It is empty if there is none.
+func frameBitstream(data []byte) string {
+	for len(data) >= 8 {
+		id := string(data[0:4])
+		if id == "VP8 " || id == "VP8L" {
+			return id
+		}
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		if size > len(data)-8 {
+			break
+		}
+		data = data[min(8+size+size&1, len(data)):]
+	}
+	return ""
+}
+
+// bitstreamDimensions reads the image size from a VP8 or VP8L chunk header.
+func bitstreamDimensions(chunk webpChunk) (int, int, error) {
+	d := chunk.Data
+	if chunk.ID == "VP8L" {
+		if len(d) < 5 || d[0] != 0x2f {
+			return 0, 0, fmt.Errorf("invalid VP8L header")
+		}
+		bits := binary.LittleEndian.Uint32(d[1:5])
+		return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, nil
+	}
+
+	if len(d) < 10 || d[3] != 0x9d || d[4] != 0x01 || d[5] != 0x2a {
+		return 0, 0, fmt.Errorf("invalid VP8 header")
+	}
+	width := int(binary.LittleEndian.Uint16(d[6:8]) & 0x3fff)
+	height := int(binary.LittleEndian.Uint16(d[8:10]) & 0x3fff)
+	return width, height, nil
+}
+
+// le24 decodes a 24-bit little-endian unsigned integer.
+func le24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
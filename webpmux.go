@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/webp"
+)
+
+// extractFramesWebpmux pulls every frame of input out with webpmux and
+// writes them named after frameName into dir. webpmux returns each
+// frame's own image without its offset, blending or disposal, so the frames
+// are composited onto the canvas here, the same way decodeAnimation does.
+func extractFramesWebpmux(input, dir string, opts Options) error {
+	bin, err := exec.LookPath("webpmux")
+	if err != nil {
+		return fmt.Errorf("-extractor webpmux: %w", err)
+	}
+	info, err := inspectWebP(input)
+	if err != nil {
+		return err
+	}
+	chunks, err := readWebPChunks(input)
+	if err != nil {
+		return err
+	}
+	var placements []animFrame
+	for _, chunk := range chunks {
+		if chunk.ID == "ANMF" && len(chunk.Data) >= 16 {
+			placements = append(placements, parseANMF(chunk.Data))
+		}
+	}
+	if len(placements) == 0 {
+		return ErrNoFrames
+	}
+
+	if strings.HasPrefix(input, "-") {
+		// webpmux would parse it as an option
+		input = "./" + input
+	}
+	frameFile := filepath.Join(dir, "webpmux_frame.webp")
+	defer os.Remove(frameFile)
+
+	canvas := newCompositor(info.Width, info.Height)
+	for i, placement := range placements {
+		// webpmux counts frames from 1, 0 being the last
+		cmd, cancel := deadlineCommand(opts, bin, "-get", "frame", strconv.Itoa(i+1), input, "-o", frameFile)
+		out, err := combinedOutput(cmd, opts)
+		cancel()
+		if err := checkDeadline(err, opts); err != nil {
+			return fmt.Errorf("webpmux failed on frame %d: %w: %s", i, err, strings.TrimSpace(string(out)))
+		}
+
+		frame, err := decodeWebPFile(frameFile)
+		if err != nil {
+			return fmt.Errorf("failed to decode frame %d from webpmux: %w", i, err)
+		}
+		if err := writeFrame(filepath.Join(dir, fmt.Sprintf(frameName(opts), i+1)), canvas.draw(placement, frame), opts.FrameQuality); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeWebPFile decodes the single-image WebP at path.
+func decodeWebPFile(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return webp.Decode(file)
+}
+
+// webpmuxDurations returns the display time of each frame of input as
+// webpmux -info reports it, for -extractor webpmux, so the timing comes
+// from the same tool as the frames.
+func webpmuxDurations(input string, opts Options) ([]time.Duration, error) {
+	bin, err := exec.LookPath("webpmux")
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(input, "-") {
+		input = "./" + input
+	}
+	cmd, cancel := deadlineCommand(opts, bin, "-info", input)
+	defer cancel()
+	out, err := combinedOutput(cmd, opts)
+	if err := checkDeadline(err, opts); err != nil {
+		return nil, fmt.Errorf("webpmux -info failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return parseWebpmuxInfo(string(out))
+}
+
+// parseWebpmuxInfo reads the frame table of webpmux -info output, whose
+// header names the columns, e.g.
+//
+//	No.: width height alpha x_offset y_offset duration   dispose blend image_size  compression
+//	  1:   400   400    no        0        0       70       none    no       5338    lossy
+func parseWebpmuxInfo(out string) ([]time.Duration, error) {
+	column := -1
+	var durations []time.Duration
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "No.:" {
+			for i, name := range fields {
+				if name == "duration" {
+					column = i
+				}
+			}
+			continue
+		}
+		if column < 0 || column >= len(fields) || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimSuffix(fields[0], ":")); err != nil {
+			continue
+		}
+		ms, err := strconv.Atoi(fields[column])
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration %q in webpmux -info output", fields[column])
+		}
+		durations = append(durations, time.Duration(ms)*time.Millisecond)
+	}
+	if column < 0 {
+		return nil, fmt.Errorf("webpmux -info listed no frame durations")
+	}
+	return durations, nil
+}